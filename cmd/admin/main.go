@@ -0,0 +1,259 @@
+package main
+
+// Административные команды, работающие напрямую с БД (без HTTP сервера).
+// Конфигурация берётся из тех же переменных окружения / .env, что и у сервера (DB_PATH, JWT_SECRET, ENCRYPTION_KEYS).
+//
+//	admin create-user -tg-id 123 -username ivan [-admin]
+//	admin grant-admin|revoke-admin|activate|deactivate -tg-id 123
+//	admin list [-q ivan] [-admins]
+//	admin token -tg-id 123 [-ttl 15m]
+//	admin genkey
+//	admin rekey
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"geminiBackend/config"
+	"geminiBackend/internal/app"
+	"geminiBackend/internal/domain"
+	"geminiBackend/internal/provider/db"
+	"geminiBackend/internal/service"
+	"geminiBackend/pkg/keyring"
+	"geminiBackend/pkg/logger"
+)
+
+// cliActorTgID actor_tg_id в журнале admin_audit для действий из CLI
+const cliActorTgID = 0
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+		os.Exit(2)
+	}
+	cfg := config.LoadConfig()
+	// Логи в stderr, чтобы stdout можно было использовать в скриптах (например, token)
+	logger.L = slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: slog.LevelWarn}))
+
+	cmd, args := os.Args[1], os.Args[2:]
+	var err error
+	switch cmd {
+	case "create-user":
+		err = createUser(cfg, args)
+	case "grant-admin", "revoke-admin", "activate", "deactivate":
+		err = setFlag(cfg, cmd, args)
+	case "list":
+		err = listUsers(cfg, args)
+	case "token":
+		err = issueToken(cfg, args)
+	case "genkey":
+		err = genKey()
+	case "rekey":
+		err = rekey(cfg)
+	case "help", "-h", "--help":
+		usage()
+		return
+	default:
+		fmt.Fprintf(os.Stderr, "unknown command %q\n\n", cmd)
+		usage()
+		os.Exit(2)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "error:", err)
+		os.Exit(1)
+	}
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, `usage: admin <command> [flags]
+
+commands:
+  create-user   create a user: -tg-id N -username NAME [-admin]
+  grant-admin   grant admin role: -tg-id N
+  revoke-admin  revoke admin role: -tg-id N
+  activate      activate account: -tg-id N
+  deactivate    deactivate account and revoke its sessions: -tg-id N
+  list          list users: [-q SUBSTRING] [-admins] [-json]
+  token         print a short-lived JWT for an admin: -tg-id N [-ttl 15m] [-json]
+  genkey        print a new random master key (base64, 32 bytes)
+  rekey         encrypt plaintext gemini keys and re-wrap keys of old master key versions`)
+}
+
+// openDB открывает БД по DB_PATH и загружает ключи шифрования так же, как сервер
+func openDB(cfg *config.Config) (*sql.DB, *keyring.Keyring, error) {
+	sqlDB, err := db.InitDBLite(cfg.DBPath)
+	if err != nil {
+		return nil, nil, err
+	}
+	keys, err := app.LoadKeyring(cfg, sqlDB)
+	if err != nil {
+		sqlDB.Close()
+		return nil, nil, err
+	}
+	return sqlDB, keys, nil
+}
+
+func createUser(cfg *config.Config, args []string) error {
+	fs := flag.NewFlagSet("create-user", flag.ExitOnError)
+	tgID := fs.Int("tg-id", 0, "telegram id")
+	username := fs.String("username", "", "username")
+	isAdmin := fs.Bool("admin", false, "grant admin role")
+	fs.Parse(args)
+
+	sqlDB, keys, err := openDB(cfg)
+	if err != nil {
+		return err
+	}
+	defer sqlDB.Close()
+
+	user, err := service.NewAdminService(sqlDB, keys).CreateUser(cliActorTgID, *tgID, *username, *isAdmin)
+	if err != nil {
+		if errors.Is(err, domain.ErrInvalidInput) {
+			return fmt.Errorf("-tg-id and -username are required")
+		}
+		return err
+	}
+	fmt.Printf("created user %d (%s), admin=%t\n", user.TgID, user.Username, user.IsAdmin)
+	return nil
+}
+
+func setFlag(cfg *config.Config, cmd string, args []string) error {
+	fs := flag.NewFlagSet(cmd, flag.ExitOnError)
+	tgID := fs.Int("tg-id", 0, "telegram id")
+	fs.Parse(args)
+	if *tgID <= 0 {
+		return fmt.Errorf("-tg-id is required")
+	}
+
+	sqlDB, keys, err := openDB(cfg)
+	if err != nil {
+		return err
+	}
+	defer sqlDB.Close()
+
+	admin := service.NewAdminService(sqlDB, keys)
+	var user *domain.UserSummary
+	switch cmd {
+	case "grant-admin":
+		user, err = admin.SetAdmin(cliActorTgID, *tgID, true)
+	case "revoke-admin":
+		user, err = admin.SetAdmin(cliActorTgID, *tgID, false)
+	case "activate":
+		user, err = admin.SetActive(cliActorTgID, *tgID, true)
+	case "deactivate":
+		user, err = admin.SetActive(cliActorTgID, *tgID, false)
+	}
+	if err != nil {
+		return err
+	}
+	fmt.Printf("user %d (%s): admin=%t active=%t\n", user.TgID, user.Username, user.IsAdmin, user.IsActive)
+	return nil
+}
+
+func listUsers(cfg *config.Config, args []string) error {
+	fs := flag.NewFlagSet("list", flag.ExitOnError)
+	query := fs.String("q", "", "username substring")
+	adminsOnly := fs.Bool("admins", false, "only admins")
+	asJSON := fs.Bool("json", false, "print as JSON")
+	fs.Parse(args)
+
+	sqlDB, keys, err := openDB(cfg)
+	if err != nil {
+		return err
+	}
+	defer sqlDB.Close()
+
+	filter := domain.UserFilter{Query: *query, Limit: -1}
+	if *adminsOnly {
+		filter.IsAdmin = adminsOnly
+	}
+	users, _, err := service.NewAdminService(sqlDB, keys).ListUsers(filter)
+	if err != nil {
+		return err
+	}
+	if *asJSON {
+		return json.NewEncoder(os.Stdout).Encode(users)
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "TG_ID\tUSERNAME\tADMIN\tACTIVE\tHAS_KEY\tCREATED_AT")
+	for _, u := range users {
+		fmt.Fprintf(w, "%d\t%s\t%t\t%t\t%t\t%s\n", u.TgID, u.Username, u.IsAdmin, u.IsActive, u.HasKey, u.CreatedAt.Format(time.RFC3339))
+	}
+	return w.Flush()
+}
+
+func issueToken(cfg *config.Config, args []string) error {
+	fs := flag.NewFlagSet("token", flag.ExitOnError)
+	tgID := fs.Int("tg-id", 0, "telegram id of an admin")
+	ttl := fs.Duration("ttl", 15*time.Minute, "token lifetime")
+	asJSON := fs.Bool("json", false, "print token, expiry and tg_id as JSON")
+	fs.Parse(args)
+	if *tgID <= 0 {
+		return fmt.Errorf("-tg-id is required")
+	}
+	if *ttl <= 0 || *ttl > 24*time.Hour {
+		return fmt.Errorf("-ttl must be between 0 and 24h")
+	}
+
+	sqlDB, keys, err := openDB(cfg)
+	if err != nil {
+		return err
+	}
+	defer sqlDB.Close()
+
+	user, err := service.NewAdminService(sqlDB, keys).GetUser(*tgID)
+	if err != nil {
+		return err
+	}
+	if !user.IsAdmin {
+		return fmt.Errorf("user %d is not an admin (use grant-admin first)", *tgID)
+	}
+	resp, err := service.NewAuthService(cfg, sqlDB, keys).IssueToken(*tgID, *ttl)
+	if err != nil {
+		return err
+	}
+	if *asJSON {
+		return json.NewEncoder(os.Stdout).Encode(map[string]any{"token": resp.Token, "expires_in": resp.ExpiresIn, "tg_id": *tgID})
+	}
+	fmt.Println(resp.Token)
+	return nil
+}
+
+func genKey() error {
+	key, err := keyring.Generate()
+	if err != nil {
+		return err
+	}
+	fmt.Println(key)
+	return nil
+}
+
+func rekey(cfg *config.Config) error {
+	keys, err := keyring.Load(cfg.EncryptionKeys, cfg.EncryptionKeyFile)
+	if err != nil {
+		return err
+	}
+	if keys == nil {
+		return fmt.Errorf("ENCRYPTION_KEYS or ENCRYPTION_KEY_FILE must be set")
+	}
+	sqlDB, err := db.InitDBLite(cfg.DBPath)
+	if err != nil {
+		return err
+	}
+	defer sqlDB.Close()
+
+	stats, err := db.NewUsersProvider(sqlDB, keys).ReencryptAPIKeys()
+	if err != nil {
+		return err
+	}
+	fmt.Printf("active key version: %d\nmigrated from plaintext: %d\nre-wrapped: %d\nunchanged: %d\n",
+		keys.ActiveVersion(), stats.Migrated, stats.Rewrapped, stats.Unchanged)
+	return nil
+}
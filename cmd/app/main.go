@@ -23,6 +23,12 @@ func main() {
 	if cfg.JWTSecret == "change-me-in-production" {
 		logger.L.Warn("warning: default JWT secret in use")
 	}
+	if cfg.TelegramBotToken == "" {
+		logger.L.Warn("warning: TELEGRAM_BOT_TOKEN not set, signed telegram login is unavailable")
+	}
+	if cfg.AllowUnsignedLogin {
+		logger.L.Warn("warning: unsigned tg_id login is allowed (ALLOW_UNSIGNED_LOGIN)")
+	}
 	if cfg.ApiGemini == "" {
 		logger.L.Warn("warning: GEMINI_API_KEY not set")
 	}
@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"os"
 	"strings"
+	"time"
 
 	"github.com/joho/godotenv"
 )
@@ -21,8 +22,108 @@ type Config struct {
 	RateLimitPerMin  bool     `yaml:"rateLimitPerMin"`  // ограничение запросов в минуту
 	LocalLLMEndpoint string   `yaml:"localLLMEndpoint"` // URL локального Ollama (например, http://ollama:11434)
 	LocalLLMMaxChars int      `yaml:"localLLMMaxChars"` // макс символов для локальной LLM (10000 по умолчанию)
+
+	LocalLLMMaxOutputTokens int `yaml:"localLLMMaxOutputTokens"` // num_predict по умолчанию и лимит max_output_tokens (4096)
+
+	TelegramBotToken   string `yaml:"telegramBotToken"`   // токен бота для проверки подписи Telegram
+	TelegramAuthMaxAge int    `yaml:"telegramAuthMaxAge"` // макс. возраст auth_date в секундах (86400 по умолчанию; 0 и меньше — тоже 86400)
+	AllowUnsignedLogin bool   `yaml:"allowUnsignedLogin"` // разрешить вход по голому tg_id (только не в release)
+
+	AccessTokenTTL  time.Duration `yaml:"accessTokenTTL"`  // время жизни access токена (1h по умолчанию)
+	RefreshTokenTTL time.Duration `yaml:"refreshTokenTTL"` // время жизни refresh токена (720h по умолчанию)
+
+	EncryptionKeys    string `yaml:"encryptionKeys"`    // мастер-ключи для шифрования API ключей: "1:base64,2:base64"
+	EncryptionKeyFile string `yaml:"encryptionKeyFile"` // файл с мастер-ключами (по одному "версия:base64" на строку)
+
+	ChatHistoryMaxChars int `yaml:"chatHistoryMaxChars"` // сколько символов истории диалога отправлять в Gemini (200000 по умолчанию)
+
+	VisionMaxImages  int    `yaml:"visionMaxImages"`  // макс. изображений в одном запросе (5 по умолчанию)
+	VisionMaxImageMB int    `yaml:"visionMaxImageMB"` // макс. размер одного изображения в МБ (10 по умолчанию)
+	LocalVisionModel string `yaml:"localVisionModel"` // vision-модель Ollama, если у пользователя нет ключа Gemini (например, llava; по умолчанию пусто — без fallback)
+
+	OCRModel string `yaml:"ocrModel"` // модель /ai/ocr-correct по умолчанию (qwen2:1.5b)
+
+	LocalEmbeddingModel string `yaml:"localEmbeddingModel"` // модель эмбеддингов Ollama, если у пользователя нет ключа Gemini (например, nomic-embed-text; по умолчанию пусто — без fallback)
+	EmbeddingsMaxTexts  int    `yaml:"embeddingsMaxTexts"`  // макс. текстов в одном запросе эмбеддингов (1000 по умолчанию)
+
+	KBChunkChars   int    `yaml:"kbChunkChars"`   // макс. символов во фрагменте документа базы знаний (1000 по умолчанию)
+	KBChunkOverlap int    `yaml:"kbChunkOverlap"` // символов конца фрагмента, повторяемых в начале следующего (150 по умолчанию)
+	KBTopK         int    `yaml:"kbTopK"`         // сколько фрагментов отдавать модели в /ai/ask по умолчанию (5)
+	LocalAskModel  string `yaml:"localAskModel"`  // модель ответа /ai/ask, если у пользователя нет ключа Gemini (например, qwen2.5:3b; по умолчанию пусто — без fallback)
+
+	DocumentMaxMB          int `yaml:"documentMaxMB"`          // макс. размер загружаемого документа в МБ (20 по умолчанию)
+	DocumentMaxExtractedMB int `yaml:"documentMaxExtractedMB"` // макс. распакованного содержимого DOCX и текста PDF в МБ (50 по умолчанию, 0 — без лимита)
+	DocumentChunkChars     int `yaml:"documentChunkChars"`     // макс. символов в части документа для Gemini (100000 по умолчанию)
+
+	LLMRoutes          string `yaml:"llmRoutes"`          // маршруты моделей к поставщикам: "gemma-*=gemini,qwen*=ollama" (первое совпадение)
+	LLMDefaultProvider string `yaml:"llmDefaultProvider"` // поставщик для моделей без маршрута (gemini по умолчанию)
+	LLMPrices          string `yaml:"llmPrices"`          // цены моделей за 1M токенов в USD: "gemini-2.5-pro*=1.25/10" (вход/выход, первое совпадение)
+
+	OpenAIUpstreams []OpenAIUpstream `yaml:"openAIUpstreams"` // OpenAI-совместимые серверы (llama.cpp, vLLM, LM Studio)
+
+	LLMPolicies      []LLMPolicy `yaml:"llmPolicies"`      // политики перебора моделей при ошибках
+	LLMDefaultPolicy string      `yaml:"llmDefaultPolicy"` // политика для запросов текста без модели (пусто — модель по умолчанию)
+
+	LLMRetryMaxAttempts int           `yaml:"llmRetryMaxAttempts"` // попыток вызова Gemini при 429/5xx, включая первую (3 по умолчанию, 1 — без повторов)
+	LLMRetryBaseDelay   time.Duration `yaml:"llmRetryBaseDelay"`   // пауза перед первым повтором, дальше удваивается (500ms по умолчанию)
+	LLMRetryMaxDelay    time.Duration `yaml:"llmRetryMaxDelay"`    // макс. пауза по экспоненте (8s по умолчанию)
+	LLMRetryBudget      time.Duration `yaml:"llmRetryBudget"`      // общее время на попытки одного вызова (30s по умолчанию)
+
+	LLMChunkWorkers    int           `yaml:"llmChunkWorkers"`    // сколько частей длинного текста обрабатывать параллельно (4 по умолчанию; для Ollama — как OLLAMA_NUM_PARALLEL)
+	LLMChunkRetries    int           `yaml:"llmChunkRetries"`    // повторов части при 429/5xx/таймауте (1 по умолчанию)
+	LLMChunkRetryDelay time.Duration `yaml:"llmChunkRetryDelay"` // пауза перед повтором части, растёт с номером попытки (1s по умолчанию)
+	LLMChunkOverlap    int           `yaml:"llmChunkOverlap"`    // символов конца части, повторяемых в начале следующей (0 по умолчанию, не больше половины лимита)
+
+	GeminiClientCacheSize int           `yaml:"geminiClientCacheSize"` // клиентов genai в кэше по ключам API (256 по умолчанию, 0 — без кэша)
+	GeminiClientIdleTTL   time.Duration `yaml:"geminiClientIdleTTL"`   // клиент без обращений дольше этого пересоздаётся (30m по умолчанию)
+
+	AITimeouts AITimeouts `yaml:"aiTimeouts"` // дедлайны AI-маршрутов
+}
+
+// AITimeouts дедлайны обработки AI-запросов по маршрутам; по истечении контекст
+// запроса отменяется и вызовы моделей прерываются. 0 — без дедлайна.
+type AITimeouts struct {
+	Text       time.Duration `yaml:"text"`       // /user/ai/text (2m по умолчанию)
+	Stream     time.Duration `yaml:"stream"`     // /user/ai/text/stream (10m по умолчанию)
+	Vision     time.Duration `yaml:"vision"`     // /user/ai/vision (2m по умолчанию)
+	Document   time.Duration `yaml:"document"`   // /user/ai/document (10m по умолчанию)
+	Chat       time.Duration `yaml:"chat"`       // сообщения диалогов и /v1/chat/completions (2m по умолчанию)
+	Models     time.Duration `yaml:"models"`     // списки моделей и /user/ai/count-tokens (30s по умолчанию)
+	Embeddings time.Duration `yaml:"embeddings"` // /v1/embeddings (1m по умолчанию)
+}
+
+// LLMPolicy политика маршрутизации: модели пробуются по порядку, к следующей
+// переходим при ошибке из классов On (429, 503, timeout, safety)
+type LLMPolicy struct {
+	Name   string        `yaml:"name"`
+	Models []string      `yaml:"models"`
+	On     []string      `yaml:"on"`    // классы ошибок (429,503,timeout по умолчанию)
+	Delay  time.Duration `yaml:"delay"` // пауза перед следующей моделью (0 по умолчанию)
+}
+
+// OpenAIUpstream OpenAI-совместимый сервер моделей. Имя — поставщик в LLM_ROUTES;
+// модели из Models направляются к нему раньше маршрутов LLM_ROUTES.
+type OpenAIUpstream struct {
+	Name            string        `yaml:"name"`
+	BaseURL         string        `yaml:"baseURL"`         // адрес API вместе с /v1, например http://gpu:8000/v1
+	APIKey          string        `yaml:"apiKey"`          // Bearer-ключ сервера (пусто — без авторизации)
+	Models          []string      `yaml:"models"`          // модели сервера, допускаются glob-шаблоны
+	Timeout         time.Duration `yaml:"timeout"`         // таймаут запроса без стриминга (120s по умолчанию)
+	ConnectTimeout  time.Duration `yaml:"connectTimeout"`  // таймаут установки соединения (10s по умолчанию)
+	MaxChars        int           `yaml:"maxChars"`        // макс. символов входа (10000 по умолчанию)
+	MaxOutputTokens int           `yaml:"maxOutputTokens"` // max_tokens по умолчанию и лимит max_output_tokens (4096)
 }
 
+// DefaultLLMRoutes маршруты по умолчанию: модели Gemini API (включая gemma-3-*-it) — в Gemini,
+// известные семейства Ollama — в локальную LLM. Порядок важен: побеждает первое совпадение.
+const DefaultLLMRoutes = "gemini-*=gemini,gemma-*=gemini,local*=ollama,qwen*=ollama,phi*=ollama,llama*=ollama," +
+	"mistral*=ollama,gemma*=ollama,llava*=ollama,moondream*=ollama,nomic-embed*=ollama,mxbai-embed*=ollama"
+
+// DefaultLLMPrices цены платного уровня Gemini API за 1M токенов (вход/выход, USD) для
+// оценки стоимости в /ai/count-tokens. Более узкие шаблоны идут раньше общих.
+const DefaultLLMPrices = "gemini-2.5-pro*=1.25/10,gemini-2.5-flash-lite*=0.10/0.40,gemini-2.5-flash*=0.30/2.50," +
+	"gemini-2.0-flash-lite*=0.075/0.30,gemini-2.0-flash*=0.10/0.40"
+
 func LoadConfig() *Config {
 	// Загружаем .env файл (если существует, ошибка игнорируется)
 	_ = godotenv.Load(".env")
@@ -38,6 +139,65 @@ func LoadConfig() *Config {
 		RateLimitPerMin:  getEnv("RATE_LIMIT_PER_MIN", "false") == "true",
 		LocalLLMEndpoint: getEnv("LOCAL_LLM_ENDPOINT", "http://ollama:11434"),
 		LocalLLMMaxChars: getEnvInt("LOCAL_LLM_MAX_CHARS", 10000),
+
+		LocalLLMMaxOutputTokens: getEnvInt("LOCAL_LLM_MAX_OUTPUT_TOKENS", 4096),
+
+		TelegramBotToken:   getEnv("TELEGRAM_BOT_TOKEN", ""),
+		TelegramAuthMaxAge: getEnvInt("TELEGRAM_AUTH_MAX_AGE", 86400),
+		AllowUnsignedLogin: getEnv("ALLOW_UNSIGNED_LOGIN", "false") == "true",
+
+		AccessTokenTTL:  getEnvDuration("ACCESS_TOKEN_TTL", time.Hour),
+		RefreshTokenTTL: getEnvDuration("REFRESH_TOKEN_TTL", 30*24*time.Hour),
+
+		EncryptionKeys:    getEnv("ENCRYPTION_KEYS", ""),
+		EncryptionKeyFile: getEnv("ENCRYPTION_KEY_FILE", ""),
+
+		ChatHistoryMaxChars: getEnvInt("CHAT_HISTORY_MAX_CHARS", 200000),
+
+		VisionMaxImages:  getEnvInt("VISION_MAX_IMAGES", 5),
+		VisionMaxImageMB: getEnvInt("VISION_MAX_IMAGE_MB", 10),
+		LocalVisionModel: getEnv("LOCAL_VISION_MODEL", ""),
+
+		OCRModel: getEnv("OCR_MODEL", "qwen2:1.5b"),
+
+		LocalEmbeddingModel: getEnv("LOCAL_EMBEDDING_MODEL", ""),
+		EmbeddingsMaxTexts:  getEnvInt("EMBEDDINGS_MAX_TEXTS", 1000),
+
+		KBChunkChars:   getEnvInt("KB_CHUNK_CHARS", 1000),
+		KBChunkOverlap: getEnvInt("KB_CHUNK_OVERLAP", 150),
+		KBTopK:         getEnvInt("KB_TOP_K", 5),
+		LocalAskModel:  getEnv("LOCAL_ASK_MODEL", ""),
+
+		DocumentMaxMB:          getEnvInt("DOCUMENT_MAX_MB", 20),
+		DocumentMaxExtractedMB: getEnvInt("DOCUMENT_MAX_EXTRACTED_MB", 50),
+		DocumentChunkChars:     getEnvInt("DOCUMENT_CHUNK_CHARS", 100000),
+
+		LLMRoutes:          getEnv("LLM_ROUTES", DefaultLLMRoutes),
+		LLMDefaultProvider: getEnv("LLM_DEFAULT_PROVIDER", "gemini"),
+		LLMPrices:          getEnv("LLM_PRICES", DefaultLLMPrices),
+
+		LLMRetryMaxAttempts: getEnvInt("LLM_RETRY_MAX_ATTEMPTS", 3),
+		LLMRetryBaseDelay:   getEnvDuration("LLM_RETRY_BASE_DELAY", 500*time.Millisecond),
+		LLMRetryMaxDelay:    getEnvDuration("LLM_RETRY_MAX_DELAY", 8*time.Second),
+		LLMRetryBudget:      getEnvDuration("LLM_RETRY_BUDGET", 30*time.Second),
+
+		LLMChunkWorkers:    getEnvInt("LLM_CHUNK_WORKERS", 4),
+		LLMChunkRetries:    getEnvInt("LLM_CHUNK_RETRIES", 1),
+		LLMChunkRetryDelay: getEnvDuration("LLM_CHUNK_RETRY_DELAY", time.Second),
+		LLMChunkOverlap:    getEnvInt("LLM_CHUNK_OVERLAP", 0),
+
+		GeminiClientCacheSize: getEnvInt("GEMINI_CLIENT_CACHE_SIZE", 256),
+		GeminiClientIdleTTL:   getEnvDuration("GEMINI_CLIENT_IDLE_TTL", 30*time.Minute),
+
+		AITimeouts: AITimeouts{
+			Text:       getEnvDuration("AI_TIMEOUT_TEXT", 2*time.Minute),
+			Stream:     getEnvDuration("AI_TIMEOUT_STREAM", 10*time.Minute),
+			Vision:     getEnvDuration("AI_TIMEOUT_VISION", 2*time.Minute),
+			Document:   getEnvDuration("AI_TIMEOUT_DOCUMENT", 10*time.Minute),
+			Chat:       getEnvDuration("AI_TIMEOUT_CHAT", 2*time.Minute),
+			Models:     getEnvDuration("AI_TIMEOUT_MODELS", 30*time.Second),
+			Embeddings: getEnvDuration("AI_TIMEOUT_EMBEDDINGS", time.Minute),
+		},
 	}
 
 	// Определяем Gin mode в зависимости от ENV
@@ -47,6 +207,15 @@ func LoadConfig() *Config {
 		cfg.GinMode = "debug"
 	}
 
+	// Вход без подписи Telegram допустим только вне release
+	if cfg.Env == "release" {
+		cfg.AllowUnsignedLogin = false
+	}
+
+	cfg.OpenAIUpstreams = loadOpenAIUpstreams(getEnv("OPENAI_UPSTREAMS", ""))
+	cfg.LLMPolicies = loadLLMPolicies(getEnv("LLM_POLICIES", ""))
+	cfg.LLMDefaultPolicy = getEnv("LLM_DEFAULT_POLICY", "")
+
 	// Парсим trusted proxies из env
 	proxyStr := getEnv("TRUSTED_PROXIES", "")
 	if proxyStr != "" {
@@ -59,6 +228,54 @@ func LoadConfig() *Config {
 	return cfg
 }
 
+// loadOpenAIUpstreams читает настройки серверов из списка имён "gpu,vllm":
+// для имени gpu — OPENAI_UPSTREAM_GPU_URL, _API_KEY, _MODELS, _TIMEOUT и т.д.
+func loadOpenAIUpstreams(names string) []OpenAIUpstream {
+	var upstreams []OpenAIUpstream
+	for _, name := range splitList(names) {
+		prefix := "OPENAI_UPSTREAM_" + strings.ToUpper(strings.ReplaceAll(name, "-", "_")) + "_"
+		upstream := OpenAIUpstream{
+			Name:            name,
+			BaseURL:         strings.TrimRight(getEnv(prefix+"URL", ""), "/"),
+			APIKey:          getEnv(prefix+"API_KEY", ""),
+			Timeout:         getEnvDuration(prefix+"TIMEOUT", 120*time.Second),
+			ConnectTimeout:  getEnvDuration(prefix+"CONNECT_TIMEOUT", 10*time.Second),
+			MaxChars:        getEnvInt(prefix+"MAX_CHARS", 10000),
+			MaxOutputTokens: getEnvInt(prefix+"MAX_OUTPUT_TOKENS", 4096),
+		}
+		upstream.Models = splitList(getEnv(prefix+"MODELS", ""))
+		upstreams = append(upstreams, upstream)
+	}
+	return upstreams
+}
+
+// loadLLMPolicies читает политики из списка имён "fast,cheap": для имени fast —
+// LLM_POLICY_FAST_MODELS, LLM_POLICY_FAST_ON и LLM_POLICY_FAST_DELAY
+func loadLLMPolicies(names string) []LLMPolicy {
+	var policies []LLMPolicy
+	for _, name := range splitList(names) {
+		prefix := "LLM_POLICY_" + strings.ToUpper(strings.ReplaceAll(name, "-", "_")) + "_"
+		policies = append(policies, LLMPolicy{
+			Name:   name,
+			Models: splitList(getEnv(prefix+"MODELS", "")),
+			On:     splitList(getEnv(prefix+"ON", "429,503,timeout")),
+			Delay:  getEnvDuration(prefix+"DELAY", 0),
+		})
+	}
+	return policies
+}
+
+// splitList разбивает список через запятую, пропуская пустые элементы
+func splitList(s string) []string {
+	var items []string
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
 func getEnv(key, fallback string) string {
 	if value, ok := os.LookupEnv(key); ok {
 		return value
@@ -75,3 +292,12 @@ func getEnvInt(key string, fallback int) int {
 	}
 	return fallback
 }
+
+func getEnvDuration(key string, fallback time.Duration) time.Duration {
+	if value, ok := os.LookupEnv(key); ok {
+		if d, err := time.ParseDuration(value); err == nil {
+			return d
+		}
+	}
+	return fallback
+}
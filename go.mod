@@ -1,11 +1,12 @@
 module geminiBackend
 
-go 1.24.0
+go 1.24.1
 
 require (
 	github.com/gin-gonic/gin v1.11.0
 	github.com/golang-jwt/jwt/v5 v5.3.0
 	github.com/joho/godotenv v1.5.1
+	github.com/ledongthuc/pdf v0.0.0-20250511090121-5959a4027728
 	github.com/mattn/go-sqlite3 v1.14.32
 	github.com/swaggo/files v1.0.1
 	github.com/swaggo/gin-swagger v1.6.1
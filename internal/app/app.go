@@ -2,14 +2,21 @@ package app
 
 import (
 	"database/sql"
+	"fmt"
 	"geminiBackend/config"
 	delivery "geminiBackend/internal/delivery/http"
 	"geminiBackend/internal/delivery/http/middleware"
 	"geminiBackend/internal/provider/db"
+	"geminiBackend/internal/provider/gemini"
+	"geminiBackend/internal/provider/llm"
+	"geminiBackend/internal/provider/ollama"
+	"geminiBackend/internal/provider/openai"
 	"geminiBackend/internal/service"
+	"geminiBackend/pkg/keyring"
 	"geminiBackend/pkg/logger"
 	"os"
 	"os/signal"
+	"path"
 	"syscall"
 	"time"
 
@@ -35,26 +42,121 @@ func (a *App) SetupRouter() (*gin.Engine, error) {
 	}
 	a.sqlDB = sqlDB
 
+	keys, err := LoadKeyring(a.cfg, sqlDB)
+	if err != nil {
+		sqlDB.Close()
+		return nil, err
+	}
+
+	registry, err := NewLLMRegistry(a.cfg)
+	if err != nil {
+		sqlDB.Close()
+		return nil, err
+	}
+
 	// Провайдеры и сервисы
-	authService := service.NewAuthService(a.cfg.JWTSecret, sqlDB)
-	aiService := service.NewAIService(a.cfg)
-	handler := delivery.NewHandler(authService, aiService, sqlDB)
+	authService := service.NewAuthService(a.cfg, sqlDB, keys)
+	aiService := service.NewAIService(a.cfg, registry)
+	adminService := service.NewAdminService(sqlDB, keys)
+	conversationService := service.NewConversationService(sqlDB, aiService)
+	knowledgeService := service.NewKnowledgeService(sqlDB, aiService)
+	handler := delivery.NewHandler(authService, aiService, adminService, conversationService, knowledgeService, sqlDB, keys)
 
 	// Rate limiters
 	var ginRouter *gin.Engine
 	if a.cfg.RateLimitPerMin {
 		logger.L.Info("rate limiting enabled: requests limited per minute")
 		rl := middleware.NewIPRateLimiter(10, time.Minute)
-		ginRouter = delivery.NewRouter(handler, middleware.JWTAuth(authService), middleware.AdminOnly(), rl)
+		ginRouter = delivery.NewRouter(handler, middleware.JWTAuth(authService), middleware.APIAuth(authService), middleware.AdminOnly(), rl, a.cfg.AITimeouts)
 	} else {
 		logger.L.Info("rate limiting disabled")
-		ginRouter = delivery.NewRouter(handler, middleware.JWTAuth(authService), middleware.AdminOnly(), nil)
+		ginRouter = delivery.NewRouter(handler, middleware.JWTAuth(authService), middleware.APIAuth(authService), middleware.AdminOnly(), nil, a.cfg.AITimeouts)
 	}
 	// Gin роутер
 	a.router = ginRouter
 	return ginRouter, nil
 }
 
+// LoadKeyring загружает мастер-ключи шифрования API ключей и проверяет,
+// что для всех уже зашифрованных записей в БД есть ключ нужной версии
+func LoadKeyring(cfg *config.Config, sqlDB *sql.DB) (*keyring.Keyring, error) {
+	keys, err := keyring.Load(cfg.EncryptionKeys, cfg.EncryptionKeyFile)
+	if err != nil {
+		return nil, err
+	}
+	versions, err := db.NewUsersProvider(sqlDB, keys).KeyVersionsInUse()
+	if err != nil {
+		return nil, err
+	}
+	for _, v := range versions {
+		if keys == nil || !keys.Has(v) {
+			return nil, fmt.Errorf("gemini api keys encrypted with master key version %d, but that key is not configured", v)
+		}
+	}
+	if keys == nil {
+		logger.L.Warn("warning: ENCRYPTION_KEYS not set, gemini api keys are stored unencrypted")
+	}
+	return keys, nil
+}
+
+// NewLLMRegistry собирает из конфига реестр поставщиков, маршрутов, политик и цен моделей;
+// модели OpenAI-совместимых серверов маршрутизируются раньше LLM_ROUTES
+func NewLLMRegistry(cfg *config.Config) (*llm.Registry, error) {
+	configured, err := llm.ParseRoutes(cfg.LLMRoutes)
+	if err != nil {
+		return nil, err
+	}
+	retrier := llm.NewRetrier(llm.RetryConfig{
+		MaxAttempts: cfg.LLMRetryMaxAttempts,
+		BaseDelay:   cfg.LLMRetryBaseDelay,
+		MaxDelay:    cfg.LLMRetryMaxDelay,
+		Budget:      cfg.LLMRetryBudget,
+	})
+	providers := []llm.Provider{
+		gemini.NewProvider(retrier, gemini.NewClientCache(cfg.GeminiClientCacheSize, cfg.GeminiClientIdleTTL)),
+		ollama.NewProvider(cfg.LocalLLMEndpoint, cfg.LocalLLMMaxChars, cfg.LocalLLMMaxOutputTokens),
+	}
+	var routes []llm.Route
+	for _, upstream := range cfg.OpenAIUpstreams {
+		for _, p := range providers {
+			if p.Name() == upstream.Name {
+				return nil, fmt.Errorf("openai upstream %q: name is already used by another provider", upstream.Name)
+			}
+		}
+		if upstream.BaseURL == "" {
+			return nil, fmt.Errorf("openai upstream %q: base URL is not set", upstream.Name)
+		}
+		provider := openai.NewProvider(upstream)
+		for _, route := range provider.Routes() {
+			if _, err := path.Match(route.Pattern, ""); err != nil {
+				return nil, fmt.Errorf("openai upstream %q: invalid model pattern %q: %w", upstream.Name, route.Pattern, err)
+			}
+		}
+		routes = append(routes, provider.Routes()...)
+		providers = append(providers, provider)
+	}
+	prices, err := llm.ParsePrices(cfg.LLMPrices)
+	if err != nil {
+		return nil, err
+	}
+	registry := llm.NewRegistry(append(routes, configured...), cfg.LLMDefaultProvider)
+	registry.SetRetrier(retrier)
+	registry.SetPrices(prices)
+	for _, p := range providers {
+		registry.Register(p)
+	}
+	for _, p := range cfg.LLMPolicies {
+		registry.AddPolicy(llm.Policy{Name: p.Name, Models: p.Models, On: p.On, Delay: p.Delay})
+	}
+	if err := registry.Validate(); err != nil {
+		return nil, err
+	}
+	if _, ok := registry.Policy(cfg.LLMDefaultPolicy); cfg.LLMDefaultPolicy != "" && !ok {
+		return nil, fmt.Errorf("unknown default routing policy %q", cfg.LLMDefaultPolicy)
+	}
+	return registry, nil
+}
+
 func (a *App) Run() error {
 	ginRouter, err := a.SetupRouter()
 	if err != nil {
@@ -0,0 +1,287 @@
+package http
+
+import (
+	"geminiBackend/internal/delivery/http/middleware"
+	"geminiBackend/internal/domain"
+	"geminiBackend/pkg/utils"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	defaultPageSize = 20
+	maxPageSize     = 100
+)
+
+// @Summary Список пользователей
+// @Description Постраничный список пользователей с фильтрами и поиском по username
+// @Tags admin
+// @Produce json
+// @Security BearerAuth
+// @Param page query int false "Номер страницы (с 1)"
+// @Param page_size query int false "Размер страницы (до 100)"
+// @Param q query string false "Поиск по подстроке username"
+// @Param is_admin query bool false "Фильтр по роли администратора"
+// @Param is_active query bool false "Фильтр по активности"
+// @Param has_key query bool false "Фильтр по наличию Gemini ключа"
+// @Success 200 {object} domain.AdminUsersSuccessResponse
+// @Failure 400 {object} domain.ErrorResponse
+// @Failure 403 {object} domain.ErrorResponse
+// @Router /admin/users [get]
+func (h *Handler) AdminListUsers(c *gin.Context) {
+	page, pageSize, ok := parsePage(c)
+	if !ok {
+		return
+	}
+	filter := domain.UserFilter{Query: c.Query("q"), Limit: pageSize, Offset: (page - 1) * pageSize}
+	if filter.IsAdmin, ok = queryBool(c, "is_admin"); !ok {
+		return
+	}
+	if filter.IsActive, ok = queryBool(c, "is_active"); !ok {
+		return
+	}
+	if filter.HasKey, ok = queryBool(c, "has_key"); !ok {
+		return
+	}
+	users, total, err := h.admin.ListUsers(filter)
+	if err != nil {
+		utils.Error(c.Writer, http.StatusInternalServerError, "db_error", err.Error())
+		return
+	}
+	utils.Success(c.Writer, domain.AdminUsersResponse{Users: users, Total: total, Page: page, PageSize: pageSize})
+}
+
+// @Summary Пользователь
+// @Tags admin
+// @Produce json
+// @Security BearerAuth
+// @Param tg_id path int true "Telegram ID"
+// @Success 200 {object} domain.AdminUserSuccessResponse
+// @Failure 400 {object} domain.ErrorResponse
+// @Failure 404 {object} domain.ErrorResponse
+// @Router /admin/users/{tg_id} [get]
+func (h *Handler) AdminGetUser(c *gin.Context) {
+	tgID, ok := tgIDParam(c)
+	if !ok {
+		return
+	}
+	user, err := h.admin.GetUser(tgID)
+	if err != nil {
+		writeAdminError(c, err)
+		return
+	}
+	utils.Success(c.Writer, user)
+}
+
+// @Summary Назначить или снять администратора
+// @Tags admin
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param tg_id path int true "Telegram ID"
+// @Param payload body domain.AdminSetAdminRequest true "Новый статус"
+// @Success 200 {object} domain.AdminUserSuccessResponse
+// @Failure 400 {object} domain.ErrorResponse
+// @Failure 403 {object} domain.ErrorResponse
+// @Failure 404 {object} domain.ErrorResponse
+// @Router /admin/users/{tg_id}/admin [put]
+func (h *Handler) AdminSetAdmin(c *gin.Context) {
+	claims, tgID, ok := adminTarget(c)
+	if !ok {
+		return
+	}
+	var req domain.AdminSetAdminRequest
+	if err := c.ShouldBindJSON(&req); err != nil || req.IsAdmin == nil {
+		utils.Error(c.Writer, http.StatusBadRequest, "bad_request", "is_admin required")
+		return
+	}
+	user, err := h.admin.SetAdmin(claims.TgID, tgID, *req.IsAdmin)
+	if err != nil {
+		writeAdminError(c, err)
+		return
+	}
+	utils.Success(c.Writer, user)
+}
+
+// @Summary Активировать или деактивировать пользователя
+// @Description При деактивации все сессии пользователя отзываются сразу
+// @Tags admin
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param tg_id path int true "Telegram ID"
+// @Param payload body domain.AdminSetActiveRequest true "Новый статус"
+// @Success 200 {object} domain.AdminUserSuccessResponse
+// @Failure 400 {object} domain.ErrorResponse
+// @Failure 403 {object} domain.ErrorResponse
+// @Failure 404 {object} domain.ErrorResponse
+// @Router /admin/users/{tg_id}/active [put]
+func (h *Handler) AdminSetActive(c *gin.Context) {
+	claims, tgID, ok := adminTarget(c)
+	if !ok {
+		return
+	}
+	var req domain.AdminSetActiveRequest
+	if err := c.ShouldBindJSON(&req); err != nil || req.IsActive == nil {
+		utils.Error(c.Writer, http.StatusBadRequest, "bad_request", "is_active required")
+		return
+	}
+	user, err := h.admin.SetActive(claims.TgID, tgID, *req.IsActive)
+	if err != nil {
+		writeAdminError(c, err)
+		return
+	}
+	utils.Success(c.Writer, user)
+}
+
+// @Summary Удалить Gemini ключ пользователя
+// @Tags admin
+// @Produce json
+// @Security BearerAuth
+// @Param tg_id path int true "Telegram ID"
+// @Success 200 {object} domain.AdminUserSuccessResponse
+// @Failure 400 {object} domain.ErrorResponse
+// @Failure 404 {object} domain.ErrorResponse
+// @Router /admin/users/{tg_id}/key [delete]
+func (h *Handler) AdminClearKey(c *gin.Context) {
+	claims, tgID, ok := adminTarget(c)
+	if !ok {
+		return
+	}
+	user, err := h.admin.ClearKey(claims.TgID, tgID)
+	if err != nil {
+		writeAdminError(c, err)
+		return
+	}
+	utils.Success(c.Writer, user)
+}
+
+// @Summary Удалить пользователя
+// @Description Удаляет пользователя и все его сессии
+// @Tags admin
+// @Produce json
+// @Security BearerAuth
+// @Param tg_id path int true "Telegram ID"
+// @Success 200 {object} domain.OptionsSuccessResponse
+// @Failure 400 {object} domain.ErrorResponse
+// @Failure 403 {object} domain.ErrorResponse
+// @Failure 404 {object} domain.ErrorResponse
+// @Router /admin/users/{tg_id} [delete]
+func (h *Handler) AdminDeleteUser(c *gin.Context) {
+	claims, tgID, ok := adminTarget(c)
+	if !ok {
+		return
+	}
+	if err := h.admin.DeleteUser(claims.TgID, tgID); err != nil {
+		writeAdminError(c, err)
+		return
+	}
+	utils.Success(c.Writer, map[string]string{"status": "ok"})
+}
+
+// @Summary Журнал действий администраторов
+// @Tags admin
+// @Produce json
+// @Security BearerAuth
+// @Param page query int false "Номер страницы (с 1)"
+// @Param page_size query int false "Размер страницы (до 100)"
+// @Param actor_tg_id query int false "Кто выполнил действие"
+// @Param target_tg_id query int false "Над кем выполнено действие"
+// @Success 200 {object} domain.AdminAuditSuccessResponse
+// @Failure 400 {object} domain.ErrorResponse
+// @Failure 403 {object} domain.ErrorResponse
+// @Router /admin/audit [get]
+func (h *Handler) AdminAudit(c *gin.Context) {
+	page, pageSize, ok := parsePage(c)
+	if !ok {
+		return
+	}
+	actor, _ := strconv.Atoi(c.Query("actor_tg_id"))
+	target, _ := strconv.Atoi(c.Query("target_tg_id"))
+	entries, total, err := h.admin.ListAudit(actor, target, pageSize, (page-1)*pageSize)
+	if err != nil {
+		utils.Error(c.Writer, http.StatusInternalServerError, "db_error", err.Error())
+		return
+	}
+	utils.Success(c.Writer, domain.AdminAuditResponse{Entries: entries, Total: total, Page: page, PageSize: pageSize})
+}
+
+// @Summary Счётчики AI-запросов
+// @Description Переходы к резервным моделям (всего, по причинам и по парам моделей) и повторы вызовов Gemini с момента запуска сервера
+// @Tags admin
+// @Produce json
+// @Security BearerAuth
+// @Success 200 {object} domain.AIStatsSuccessResponse
+// @Failure 403 {object} domain.ErrorResponse
+// @Router /admin/ai/stats [get]
+func (h *Handler) AdminAIStats(c *gin.Context) {
+	utils.Success(c.Writer, h.ai.Stats())
+}
+
+func writeAdminError(c *gin.Context, err error) {
+	switch err {
+	case domain.ErrUserNotFound:
+		utils.Error(c.Writer, http.StatusNotFound, "user_not_found", err.Error())
+	case domain.ErrSelfModification:
+		utils.Error(c.Writer, http.StatusForbidden, "forbidden", err.Error())
+	default:
+		utils.Error(c.Writer, http.StatusInternalServerError, "db_error", err.Error())
+	}
+}
+
+// adminTarget достаёт клеймы администратора и tg_id пользователя из пути
+func adminTarget(c *gin.Context) (*domain.Claims, int, bool) {
+	claims, ok := middleware.ClaimsFromContext(c)
+	if !ok {
+		utils.Error(c.Writer, http.StatusUnauthorized, "unauthorized", "no claims")
+		return nil, 0, false
+	}
+	tgID, ok := tgIDParam(c)
+	return claims, tgID, ok
+}
+
+func tgIDParam(c *gin.Context) (int, bool) {
+	tgID, err := strconv.Atoi(c.Param("tg_id"))
+	if err != nil || tgID <= 0 {
+		utils.Error(c.Writer, http.StatusBadRequest, "validation_error", "invalid tg_id")
+		return 0, false
+	}
+	return tgID, true
+}
+
+func parsePage(c *gin.Context) (page, pageSize int, ok bool) {
+	page, pageSize = 1, defaultPageSize
+	if v := c.Query("page"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			utils.Error(c.Writer, http.StatusBadRequest, "validation_error", "invalid page")
+			return 0, 0, false
+		}
+		page = n
+	}
+	if v := c.Query("page_size"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > maxPageSize {
+			utils.Error(c.Writer, http.StatusBadRequest, "validation_error", "page_size must be between 1 and 100")
+			return 0, 0, false
+		}
+		pageSize = n
+	}
+	return page, pageSize, true
+}
+
+// queryBool разбирает необязательный булев query-параметр; nil — параметр не задан
+func queryBool(c *gin.Context, name string) (*bool, bool) {
+	v := c.Query(name)
+	if v == "" {
+		return nil, true
+	}
+	b, err := strconv.ParseBool(v)
+	if err != nil {
+		utils.Error(c.Writer, http.StatusBadRequest, "validation_error", "invalid "+name)
+		return nil, false
+	}
+	return &b, true
+}
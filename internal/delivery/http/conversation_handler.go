@@ -0,0 +1,236 @@
+package http
+
+import (
+	"geminiBackend/internal/delivery/http/middleware"
+	"geminiBackend/internal/domain"
+	"geminiBackend/pkg/utils"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+// @Summary Создать диалог
+// @Description Создаёт пустой диалог. Если title не задан, он будет взят из первого сообщения.
+// @Tags conversations
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param payload body domain.CreateConversationRequest true "Заголовок и модель диалога"
+// @Success 200 {object} domain.ConversationSuccessResponse
+// @Failure 400 {object} domain.ErrorResponse
+// @Failure 401 {object} domain.ErrorResponse
+// @Router /user/conversations [post]
+func (h *Handler) CreateConversation(c *gin.Context) {
+	claims, ok := middleware.ClaimsFromContext(c)
+	if !ok {
+		utils.Error(c.Writer, http.StatusUnauthorized, "unauthorized", "no claims")
+		return
+	}
+	var req domain.CreateConversationRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		utils.Error(c.Writer, http.StatusBadRequest, "bad_request", "invalid body")
+		return
+	}
+	if req.Model == "" {
+		req.Model = defaultTextModel
+	}
+	conv, err := h.conversations.Create(claims.TgID, req.Title, req.Model)
+	if err != nil {
+		utils.Error(c.Writer, http.StatusInternalServerError, "db_error", err.Error())
+		return
+	}
+	utils.Success(c.Writer, domain.ConversationResponse{Conversation: *conv, Messages: []domain.ChatMessage{}})
+}
+
+// @Summary Список диалогов
+// @Description Диалоги пользователя, последние активные сначала
+// @Tags conversations
+// @Produce json
+// @Security BearerAuth
+// @Param page query int false "Номер страницы (с 1)"
+// @Param page_size query int false "Размер страницы (до 100)"
+// @Success 200 {object} domain.ConversationsSuccessResponse
+// @Failure 400 {object} domain.ErrorResponse
+// @Failure 401 {object} domain.ErrorResponse
+// @Router /user/conversations [get]
+func (h *Handler) ListConversations(c *gin.Context) {
+	claims, ok := middleware.ClaimsFromContext(c)
+	if !ok {
+		utils.Error(c.Writer, http.StatusUnauthorized, "unauthorized", "no claims")
+		return
+	}
+	page, pageSize, ok := parsePage(c)
+	if !ok {
+		return
+	}
+	convs, total, err := h.conversations.List(claims.TgID, pageSize, (page-1)*pageSize)
+	if err != nil {
+		utils.Error(c.Writer, http.StatusInternalServerError, "db_error", err.Error())
+		return
+	}
+	utils.Success(c.Writer, domain.ConversationsResponse{Conversations: convs, Total: total, Page: page, PageSize: pageSize})
+}
+
+// @Summary Диалог с сообщениями
+// @Tags conversations
+// @Produce json
+// @Security BearerAuth
+// @Param id path int true "ID диалога"
+// @Success 200 {object} domain.ConversationSuccessResponse
+// @Failure 400 {object} domain.ErrorResponse
+// @Failure 404 {object} domain.ErrorResponse
+// @Router /user/conversations/{id} [get]
+func (h *Handler) GetConversation(c *gin.Context) {
+	claims, id, ok := conversationTarget(c)
+	if !ok {
+		return
+	}
+	conv, err := h.conversations.Get(claims.TgID, id)
+	if err != nil {
+		writeConversationError(c, err, "db_error")
+		return
+	}
+	utils.Success(c.Writer, conv)
+}
+
+// @Summary Переименовать диалог
+// @Tags conversations
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param id path int true "ID диалога"
+// @Param payload body domain.RenameConversationRequest true "Новый заголовок"
+// @Success 200 {object} domain.ConversationSuccessResponse
+// @Failure 400 {object} domain.ErrorResponse
+// @Failure 404 {object} domain.ErrorResponse
+// @Router /user/conversations/{id} [put]
+func (h *Handler) RenameConversation(c *gin.Context) {
+	claims, id, ok := conversationTarget(c)
+	if !ok {
+		return
+	}
+	var req domain.RenameConversationRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		utils.Error(c.Writer, http.StatusBadRequest, "bad_request", "invalid body")
+		return
+	}
+	if req.Title == "" {
+		utils.Error(c.Writer, http.StatusBadRequest, "validation_error", "title required")
+		return
+	}
+	conv, err := h.conversations.Rename(claims.TgID, id, req.Title)
+	if err != nil {
+		writeConversationError(c, err, "db_error")
+		return
+	}
+	utils.Success(c.Writer, conv)
+}
+
+// @Summary Удалить диалог
+// @Description Удаляет диалог вместе со всеми сообщениями
+// @Tags conversations
+// @Produce json
+// @Security BearerAuth
+// @Param id path int true "ID диалога"
+// @Success 200 {object} domain.OptionsSuccessResponse
+// @Failure 400 {object} domain.ErrorResponse
+// @Failure 404 {object} domain.ErrorResponse
+// @Router /user/conversations/{id} [delete]
+func (h *Handler) DeleteConversation(c *gin.Context) {
+	claims, id, ok := conversationTarget(c)
+	if !ok {
+		return
+	}
+	if err := h.conversations.Delete(claims.TgID, id); err != nil {
+		writeConversationError(c, err, "db_error")
+		return
+	}
+	utils.Success(c.Writer, map[string]string{"status": "ok"})
+}
+
+// @Summary Отправить сообщение в диалог
+// @Description Отправляет модели сохранённую историю диалога вместе с новым сообщением и сохраняет ответ.
+// @Description Если история не помещается в лимит входа модели, самые старые сообщения не отправляются (history_trimmed).
+// @Tags conversations
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param id path int true "ID диалога"
+// @Param payload body domain.PostMessageRequest true "Сообщение"
+// @Success 200 {object} domain.PostMessageSuccessResponse
+// @Failure 400 {object} domain.ErrorResponse
+// @Failure 401 {object} domain.ErrorResponse
+// @Failure 402 {object} domain.ErrorResponse
+// @Failure 403 {object} domain.ErrorResponse
+// @Failure 404 {object} domain.ErrorResponse
+// @Failure 429 {object} domain.ErrorResponse
+// @Failure 500 {object} domain.ErrorResponse
+// @Failure 502 {object} domain.ErrorResponse
+// @Failure 503 {object} domain.ErrorResponse
+// @Router /user/conversations/{id}/messages [post]
+func (h *Handler) PostConversationMessage(c *gin.Context) {
+	claims, id, ok := conversationTarget(c)
+	if !ok {
+		return
+	}
+	var req domain.PostMessageRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		utils.Error(c.Writer, http.StatusBadRequest, "bad_request", "invalid body")
+		return
+	}
+	if req.Content == "" {
+		utils.Error(c.Writer, http.StatusBadRequest, "validation_error", "content required")
+		return
+	}
+	model := req.Model
+	if model == "" {
+		conv, err := h.conversations.Find(claims.TgID, id)
+		if err != nil {
+			writeConversationError(c, err, "db_error")
+			return
+		}
+		model = conv.Model
+	}
+	apiKey, ok := h.userAPIKey(c, claims.TgID, model)
+	if !ok {
+		return
+	}
+
+	resp, err := h.conversations.PostMessage(c.Request.Context(), claims.TgID, id, req.Content, model, apiKey)
+	if err != nil {
+		writeConversationError(c, err, "ai_error")
+		return
+	}
+	utils.Success(c.Writer, resp)
+}
+
+func writeConversationError(c *gin.Context, err error, fallbackCode string) {
+	switch err {
+	case domain.ErrConversationNotFound:
+		utils.Error(c.Writer, http.StatusNotFound, "conversation_not_found", err.Error())
+	case domain.ErrMessageTooLong:
+		utils.Error(c.Writer, http.StatusBadRequest, "message_too_long", err.Error())
+	default:
+		if fallbackCode == "ai_error" {
+			writeAIError(c, err)
+			return
+		}
+		utils.Error(c.Writer, http.StatusInternalServerError, fallbackCode, err.Error())
+	}
+}
+
+// conversationTarget достаёт клеймы пользователя и id диалога из пути
+func conversationTarget(c *gin.Context) (*domain.Claims, int64, bool) {
+	claims, ok := middleware.ClaimsFromContext(c)
+	if !ok {
+		utils.Error(c.Writer, http.StatusUnauthorized, "unauthorized", "no claims")
+		return nil, 0, false
+	}
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil || id <= 0 {
+		utils.Error(c.Writer, http.StatusBadRequest, "validation_error", "invalid conversation id")
+		return nil, 0, false
+	}
+	return claims, id, true
+}
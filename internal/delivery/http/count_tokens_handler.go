@@ -0,0 +1,58 @@
+package http
+
+import (
+	"geminiBackend/internal/delivery/http/middleware"
+	"geminiBackend/internal/domain"
+	"geminiBackend/pkg/utils"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// @Summary Подсчёт токенов
+// @Description Считает токены prompt до отправки: Gemini — через CountTokens API, локальные модели — приблизительным
+// @Description токенизатором (estimated). headroom — сколько токенов ещё помещается во вход модели; chunked — /ai/text
+// @Description разрежет промпт на chunks частей по входному лимиту в символах. cost — оценка стоимости по таблице
+// @Description LLM_PRICES (выход — по max_output_tokens); для моделей без цены не возвращается.
+// @Tags ai
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param payload body domain.CountTokensRequest true "Промпт и модель"
+// @Success 200 {object} domain.CountTokensSuccessResponse
+// @Failure 400 {object} domain.ErrorResponse
+// @Failure 401 {object} domain.ErrorResponse
+// @Failure 402 {object} domain.ErrorResponse
+// @Failure 403 {object} domain.ErrorResponse
+// @Failure 404 {object} domain.ErrorResponse
+// @Failure 429 {object} domain.ErrorResponse
+// @Failure 500 {object} domain.ErrorResponse
+// @Failure 502 {object} domain.ErrorResponse
+// @Failure 503 {object} domain.ErrorResponse
+// @Router /user/ai/count-tokens [post]
+func (h *Handler) AICountTokens(c *gin.Context) {
+	claims, ok := middleware.ClaimsFromContext(c)
+	if !ok {
+		utils.Error(c.Writer, http.StatusUnauthorized, "unauthorized", "no claims")
+		return
+	}
+	var req domain.CountTokensRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		utils.Error(c.Writer, http.StatusBadRequest, "bad_request", "invalid body")
+		return
+	}
+	if req.Model == "" {
+		req.Model = defaultTextModel
+	}
+	apiKey, ok := h.userAPIKey(c, claims.TgID, req.Model)
+	if !ok {
+		return
+	}
+
+	resp, err := h.ai.CountTokens(c.Request.Context(), req.Model, apiKey, req)
+	if err != nil {
+		writeAIError(c, err)
+		return
+	}
+	utils.Success(c.Writer, resp)
+}
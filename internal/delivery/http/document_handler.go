@@ -0,0 +1,108 @@
+package http
+
+import (
+	"errors"
+	"geminiBackend/internal/delivery/http/middleware"
+	"geminiBackend/internal/domain"
+	"geminiBackend/pkg/utils"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// @Summary Обработка документа
+// @Description Принимает PDF, DOCX, TXT или Markdown и выполняет задание: summarize (пересказ),
+// @Description question (ответ на вопрос), extract (извлечение полей в JSON) или ocr_correct (исправление OCR).
+// @Description PDF передаётся в Gemini напрямую, если модель это поддерживает; иначе извлекается текст,
+// @Description и большие документы обрабатываются по группам страниц (результаты частей — в chunks).
+// @Description Части сводятся по стратегии strategy: map_reduce — частичные результаты сворачиваются
+// @Description в один (по умолчанию), refine — результат уточняется часть за частью, concat — ответы
+// @Description частей склеиваются (для ocr_correct — только concat). debug=true возвращает промежуточные шаги в steps.
+// @Tags ai
+// @Accept multipart/form-data
+// @Produce json
+// @Security BearerAuth
+// @Param file formData file true "Документ"
+// @Param task formData string false "summarize | question | extract | ocr_correct (по умолчанию summarize)"
+// @Param question formData string false "Вопрос (для task=question)"
+// @Param fields formData string false "Поля через запятую (для task=extract)"
+// @Param strategy formData string false "concat | map_reduce | refine (по умолчанию map_reduce, для ocr_correct — concat)"
+// @Param debug formData bool false "Вернуть промежуточные результаты свёртки в steps"
+// @Param model formData string false "Модель (по умолчанию gemini-2.5-flash)"
+// @Success 200 {object} domain.DocumentSuccessResponse
+// @Failure 400 {object} domain.ErrorResponse
+// @Failure 401 {object} domain.ErrorResponse
+// @Failure 402 {object} domain.ErrorResponse
+// @Failure 403 {object} domain.ErrorResponse
+// @Failure 404 {object} domain.ErrorResponse
+// @Failure 413 {object} domain.ErrorResponse
+// @Failure 429 {object} domain.ErrorResponse
+// @Failure 500 {object} domain.ErrorResponse
+// @Failure 502 {object} domain.ErrorResponse
+// @Failure 503 {object} domain.ErrorResponse
+// @Router /user/ai/document [post]
+func (h *Handler) AIDocument(c *gin.Context) {
+	claims, ok := middleware.ClaimsFromContext(c)
+	if !ok {
+		utils.Error(c.Writer, http.StatusUnauthorized, "unauthorized", "no claims")
+		return
+	}
+
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, h.ai.DocumentUploadLimit())
+	fh, err := c.FormFile("file")
+	if err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			utils.Error(c.Writer, http.StatusRequestEntityTooLarge, "request_too_large", "upload exceeds size limit")
+			return
+		}
+		utils.Error(c.Writer, http.StatusBadRequest, "validation_error", "file required")
+		return
+	}
+	f, err := fh.Open()
+	if err != nil {
+		utils.Error(c.Writer, http.StatusBadRequest, "bad_request", "failed to read file")
+		return
+	}
+	data, err := io.ReadAll(f)
+	f.Close()
+	if err != nil {
+		utils.Error(c.Writer, http.StatusBadRequest, "bad_request", "failed to read file")
+		return
+	}
+
+	task := domain.DocumentTask{
+		Task:     c.DefaultPostForm("task", domain.DocTaskSummarize),
+		Question: c.PostForm("question"),
+		Fields:   formList(c, "fields"),
+		Strategy: c.PostForm("strategy"),
+		Debug:    c.PostForm("debug") == "true",
+	}
+	model := h.ai.DocumentModel(c.PostForm("model"))
+	apiKey, ok := h.userAPIKey(c, claims.TgID, model)
+	if !ok {
+		return
+	}
+
+	resp, err := h.ai.ProcessDocument(c.Request.Context(), model, apiKey, fh.Filename, data, task)
+	if err != nil {
+		writeAIError(c, err)
+		return
+	}
+	utils.Success(c.Writer, resp)
+}
+
+// formList значения поля формы: повторяющиеся поля и/или значения через запятую
+func formList(c *gin.Context, name string) []string {
+	var values []string
+	for _, raw := range c.PostFormArray(name) {
+		for _, v := range strings.Split(raw, ",") {
+			if v = strings.TrimSpace(v); v != "" {
+				values = append(values, v)
+			}
+		}
+	}
+	return values
+}
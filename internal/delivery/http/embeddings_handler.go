@@ -0,0 +1,75 @@
+package http
+
+import (
+	"errors"
+	"geminiBackend/internal/delivery/http/middleware"
+	"geminiBackend/internal/domain"
+	"geminiBackend/internal/provider/llm"
+	"geminiBackend/pkg/utils"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// @Summary Эмбеддинги
+// @Description Возвращает векторы текстов: Gemini — через EmbedContent, локальные модели — через /api/embed Ollama.
+// @Description Без model используется gemini-embedding-001, а без ключа Gemini — LOCAL_EMBEDDING_MODEL, если она задана.
+// @Description task_type — назначение векторов (для Gemini — task_type, для nomic-embed — префикс текста).
+// @Description Тексты сверх лимита поставщика на запрос (Gemini — 100, Ollama — 64) отправляются пачками.
+// @Tags ai
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param payload body domain.EmbeddingsRequest true "Тексты и модель"
+// @Success 200 {object} domain.EmbeddingsSuccessResponse
+// @Failure 400 {object} domain.ErrorResponse
+// @Failure 401 {object} domain.ErrorResponse
+// @Failure 402 {object} domain.ErrorResponse
+// @Failure 403 {object} domain.ErrorResponse
+// @Failure 404 {object} domain.ErrorResponse
+// @Failure 429 {object} domain.ErrorResponse
+// @Failure 500 {object} domain.ErrorResponse
+// @Failure 502 {object} domain.ErrorResponse
+// @Failure 503 {object} domain.ErrorResponse
+// @Router /user/ai/embeddings [post]
+func (h *Handler) AIEmbeddings(c *gin.Context) {
+	claims, ok := middleware.ClaimsFromContext(c)
+	if !ok {
+		utils.Error(c.Writer, http.StatusUnauthorized, "unauthorized", "no claims")
+		return
+	}
+	var req domain.EmbeddingsRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		utils.Error(c.Writer, http.StatusBadRequest, "bad_request", "invalid body")
+		return
+	}
+
+	model, apiKey, ok := h.embeddingModelKey(c, claims.TgID, req.Model)
+	if !ok {
+		return
+	}
+
+	resp, err := h.ai.Embed(c.Request.Context(), llm.EmbedRequest{
+		Model:      model,
+		APIKey:     apiKey,
+		Inputs:     req.Texts,
+		Dimensions: req.Dimensions,
+		TaskType:   req.TaskType,
+	})
+	var paramErr *domain.ParamError
+	if errors.As(err, &paramErr) && paramErr.Field == "input" {
+		err = &domain.ParamError{Field: "texts", Reason: paramErr.Reason}
+	}
+	if err != nil {
+		writeAIError(c, err)
+		return
+	}
+	data := domain.EmbeddingsResponse{Model: model, Embeddings: make([]domain.Embedding, len(resp.Vectors)), Usage: resp.Usage}
+	for i, vec := range resp.Vectors {
+		data.Embeddings[i] = domain.Embedding{Index: i, Dimensions: len(vec), Vector: vec}
+	}
+	if len(resp.Vectors) > 0 {
+		data.Dimensions = len(resp.Vectors[0])
+	}
+	utils.Success(c.Writer, data)
+}
@@ -1,12 +1,15 @@
 package http
 
 import (
+	"context"
 	"database/sql"
+	"errors"
 	"geminiBackend/internal/delivery/http/middleware"
 	"geminiBackend/internal/domain"
 	"geminiBackend/internal/provider/db"
-	"geminiBackend/internal/provider/gemini"
+	"geminiBackend/internal/provider/llm"
 	"geminiBackend/internal/service"
+	"geminiBackend/pkg/keyring"
 	"geminiBackend/pkg/logger"
 	"geminiBackend/pkg/utils"
 	"net/http"
@@ -14,25 +17,35 @@ import (
 	"github.com/gin-gonic/gin"
 )
 
+// defaultTextModel модель для генерации текста, если клиент её не указал
+const defaultTextModel = "gemini-2.0-flash-exp"
+
 type Handler struct {
-	auth *service.AuthService
-	ai   *service.AIService
-	db   *sql.DB
+	auth          *service.AuthService
+	ai            *service.AIService
+	admin         *service.AdminService
+	conversations *service.ConversationService
+	kb            *service.KnowledgeService
+	db            *sql.DB
+	keys          *keyring.Keyring
 }
 
-func NewHandler(auth *service.AuthService, ai *service.AIService, database *sql.DB) *Handler {
-	return &Handler{auth: auth, ai: ai, db: database}
+func NewHandler(auth *service.AuthService, ai *service.AIService, admin *service.AdminService, conversations *service.ConversationService,
+	kb *service.KnowledgeService, database *sql.DB, keys *keyring.Keyring) *Handler {
+	return &Handler{auth: auth, ai: ai, admin: admin, conversations: conversations, kb: kb, db: database, keys: keys}
 }
 
 // @Summary Регистрация
-// @Description Регистрация нового пользователя
+// @Description Регистрация нового пользователя по подписанным данным Telegram (init_data или widget)
 // @Tags auth
 // @Accept json
 // @Produce json
 // @Param payload body domain.RegisterRequest true "Данные для регистрации"
 // @Success 200 {object} domain.RegisterResponse
 // @Failure 400 {object} domain.ErrorResponse
+// @Failure 401 {object} domain.ErrorResponse
 // @Failure 500 {object} domain.ErrorResponse
+// @Failure 503 {object} domain.ErrorResponse
 // @Router /register [post]
 func (h *Handler) Register(c *gin.Context) {
 	var req domain.RegisterRequest
@@ -42,11 +55,18 @@ func (h *Handler) Register(c *gin.Context) {
 	}
 	resp, err := h.auth.Register(req)
 	if err != nil {
-		if err == domain.ErrUserExists {
+		switch err {
+		case domain.ErrUserExists:
 			utils.Error(c.Writer, http.StatusBadRequest, "user_exists", err.Error())
-			return
+		case domain.ErrInvalidInput:
+			utils.Error(c.Writer, http.StatusBadRequest, "validation_error", err.Error())
+		case domain.ErrInvalidSignature, domain.ErrAuthDataExpired, domain.ErrUnsignedLogin:
+			utils.Error(c.Writer, http.StatusUnauthorized, "unauthorized", err.Error())
+		case domain.ErrTelegramAuthDisabled:
+			utils.Error(c.Writer, http.StatusServiceUnavailable, "auth_not_configured", err.Error())
+		default:
+			utils.Error(c.Writer, http.StatusInternalServerError, "internal_error", err.Error())
 		}
-		utils.Error(c.Writer, http.StatusInternalServerError, "internal_error", err.Error())
 		return
 	}
 	utils.Success(c.Writer, resp)
@@ -54,15 +74,16 @@ func (h *Handler) Register(c *gin.Context) {
 }
 
 // @Summary Логин
-// @Description Аутентификация пользователя и получение JWT токена
+// @Description Аутентификация по подписанным данным Telegram (init_data или widget) и получение JWT токена
 // @Tags auth
 // @Accept json
 // @Produce json
 // @Param payload body domain.LoginRequest true "Данные для входа"
-// @Success 200 {object} domain.LoginResponse
+// @Success 200 {object} domain.LoginSuccessResponse
 // @Failure 400 {object} domain.ErrorResponse
 // @Failure 401 {object} domain.ErrorResponse
 // @Failure 429 {object} domain.ErrorResponse
+// @Failure 503 {object} domain.ErrorResponse
 // @Router /login [post]
 func (h *Handler) Login(c *gin.Context) {
 	var req domain.LoginRequest
@@ -71,6 +92,10 @@ func (h *Handler) Login(c *gin.Context) {
 		return
 	}
 	resp, err := h.auth.Login(req)
+	if err == domain.ErrTelegramAuthDisabled {
+		utils.Error(c.Writer, http.StatusServiceUnavailable, "auth_not_configured", err.Error())
+		return
+	}
 	if err != nil {
 		utils.Error(c.Writer, http.StatusUnauthorized, "unauthorized", err.Error())
 		return
@@ -79,6 +104,79 @@ func (h *Handler) Login(c *gin.Context) {
 	logger.L.Debug("user logged in", "username", req.Username)
 }
 
+// @Summary Обновление токенов
+// @Description Обменивает refresh токен на новую пару токенов. Старый refresh токен после этого недействителен
+// @Tags auth
+// @Accept json
+// @Produce json
+// @Param payload body domain.RefreshRequest true "Refresh токен"
+// @Success 200 {object} domain.LoginSuccessResponse
+// @Failure 400 {object} domain.ErrorResponse
+// @Failure 401 {object} domain.ErrorResponse
+// @Failure 429 {object} domain.ErrorResponse
+// @Router /token/refresh [post]
+func (h *Handler) RefreshToken(c *gin.Context) {
+	var req domain.RefreshRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		utils.Error(c.Writer, http.StatusBadRequest, "bad_request", "invalid body")
+		return
+	}
+	resp, err := h.auth.Refresh(req)
+	if err != nil {
+		if err == domain.ErrInvalidRefresh {
+			utils.Error(c.Writer, http.StatusUnauthorized, "invalid_refresh_token", err.Error())
+			return
+		}
+		utils.Error(c.Writer, http.StatusInternalServerError, "internal_error", err.Error())
+		return
+	}
+	utils.Success(c.Writer, resp)
+}
+
+// @Summary Выход
+// @Description Отзывает текущую сессию: access и refresh токены перестают действовать
+// @Tags auth
+// @Produce json
+// @Security BearerAuth
+// @Success 200 {object} domain.OptionsSuccessResponse
+// @Failure 401 {object} domain.ErrorResponse
+// @Router /logout [post]
+func (h *Handler) Logout(c *gin.Context) {
+	claims, ok := middleware.ClaimsFromContext(c)
+	if !ok {
+		utils.Error(c.Writer, http.StatusUnauthorized, "unauthorized", "no claims")
+		return
+	}
+	if err := h.auth.Logout(claims); err != nil {
+		utils.Error(c.Writer, http.StatusInternalServerError, "db_error", err.Error())
+		return
+	}
+	logger.L.Debug("user logged out", "tg_id", claims.TgID)
+	utils.Success(c.Writer, map[string]string{"status": "ok"})
+}
+
+// @Summary Выход со всех устройств
+// @Description Отзывает все сессии пользователя
+// @Tags auth
+// @Produce json
+// @Security BearerAuth
+// @Success 200 {object} domain.OptionsSuccessResponse
+// @Failure 401 {object} domain.ErrorResponse
+// @Router /logout/all [post]
+func (h *Handler) LogoutAll(c *gin.Context) {
+	claims, ok := middleware.ClaimsFromContext(c)
+	if !ok {
+		utils.Error(c.Writer, http.StatusUnauthorized, "unauthorized", "no claims")
+		return
+	}
+	if err := h.auth.LogoutAll(claims.TgID); err != nil {
+		utils.Error(c.Writer, http.StatusInternalServerError, "db_error", err.Error())
+		return
+	}
+	logger.L.Debug("user logged out of all sessions", "tg_id", claims.TgID)
+	utils.Success(c.Writer, map[string]string{"status": "ok"})
+}
+
 // @Summary Опции сервера
 // @Description Возвращает основные параметры конфигурации (пример)
 // @Tags admin
@@ -92,7 +190,7 @@ func (h *Handler) Options(c *gin.Context) {
 }
 
 // @Summary Список моделей AI
-// @Description Возвращает список доступных моделей Gemini для пользователя с подробной информацией
+// @Description Возвращает объединённый список моделей всех поставщиков (Gemini — при наличии ключа, Ollama) с подробной информацией
 // @Tags ai
 // @Produce json
 // @Security BearerAuth
@@ -107,22 +205,26 @@ func (h *Handler) AIModels(c *gin.Context) {
 		utils.Error(c.Writer, http.StatusUnauthorized, "unauthorized", "no claims")
 		return
 	}
-	usersDB := db.NewUsersProvider(h.db)
+	usersDB := db.NewUsersProvider(h.db, h.keys)
 	user, err := usersDB.GetUserByTelegramID(claims.TgID)
 	if err != nil {
 		utils.Error(c.Writer, http.StatusUnauthorized, "unauthorizeds", "user not found")
 		return
 	}
-	models, err := h.ai.ListModels(user.GeminiAPIKey.String)
+	models, err := h.ai.ListModels(c.Request.Context(), user.GeminiAPIKey.String)
 	if err != nil {
-		utils.Error(c.Writer, http.StatusInternalServerError, "ai_error", err.Error())
+		writeAIError(c, err)
 		return
 	}
 	utils.Success(c.Writer, map[string]interface{}{"models": models})
 }
 
 // @Summary Генерация текста
-// @Description Генерирует текст по переданному prompt через Gemini
+// @Description Генерирует текст по переданному prompt через Gemini или локальную модель.
+// @Description Необязательные параметры генерации (system_instruction, temperature, top_p, top_k,
+// @Description max_output_tokens, stop_sequences, seed, candidate_count) проверяются по лимитам модели.
+// @Description policy (политика из конфига) или fallback (резервные модели) включают переход к следующей модели
+// @Description при ошибках 429, 503, timeout или safety; в ответе model — модель, которая ответила.
 // @Tags ai
 // @Accept json
 // @Produce json
@@ -131,50 +233,163 @@ func (h *Handler) AIModels(c *gin.Context) {
 // @Success 200 {object} domain.AITextSuccessResponse
 // @Failure 400 {object} domain.ErrorResponse
 // @Failure 401 {object} domain.ErrorResponse
+// @Failure 402 {object} domain.ErrorResponse
+// @Failure 403 {object} domain.ErrorResponse
+// @Failure 404 {object} domain.ErrorResponse
 // @Failure 500 {object} domain.ErrorResponse
+// @Failure 502 {object} domain.ErrorResponse
+// @Failure 503 {object} domain.ErrorResponse
 // @Failure 429 {object} domain.ErrorResponse
 // @Router /user/ai/text [post]
 func (h *Handler) AIText(c *gin.Context) {
-	var req domain.AITextRequest
+	req, policy, apiKey, ok := h.bindAITextRequest(c)
+	if !ok {
+		return
+	}
+
+	resp, err := h.ai.AskText(c.Request.Context(), policy, apiKey, req.Prompt, req.GenerationParams)
+	if err != nil {
+		writeAIError(c, err)
+		return
+	}
+	utils.Success(c.Writer, resp)
+}
+
+// writeAIError отвечает на ошибку генерации статусом и кодом из aiErrorStatus;
+// клиенту уходит только безопасный текст (aiErrorMessage), полная ошибка — в лог
+func writeAIError(c *gin.Context, err error) {
+	status, code := aiErrorStatus(err)
+	logAIError(err, status, code)
+	utils.Error(c.Writer, status, code, aiErrorMessage(err, status))
+}
+
+// aiErrorMessage текст ошибки генерации для клиента: причина ParamError, Message
+// поставщика или текст типовой ошибки. Адреса, ответы API и ошибки БД в него не попадают.
+func aiErrorMessage(err error, status int) string {
+	var paramErr *domain.ParamError
+	var providerErr *domain.ProviderError
+	switch {
+	case errors.As(err, &paramErr):
+		return paramErr.Error()
+	case errors.As(err, &providerErr):
+		return providerErr.Message
+	case errors.Is(err, domain.ErrNoProvider):
+		return err.Error() // имя модели из запроса
+	case errors.Is(err, domain.ErrMissingAPIKey):
+		return domain.ErrMissingAPIKey.Error()
+	case errors.Is(err, context.DeadlineExceeded):
+		return "request deadline exceeded"
+	case status == http.StatusInternalServerError:
+		return "internal error"
+	}
+	return http.StatusText(status)
+}
+
+// logAIError пишет полную ошибку генерации в лог: 5xx — как ошибку, прочие — для отладки
+func logAIError(err error, status int, code string) {
+	if status >= http.StatusInternalServerError {
+		logger.L.Error("ai request failed", "error", err.Error(), "code", code)
+		return
+	}
+	logger.L.Debug("ai request rejected", "error", err.Error(), "code", code)
+}
+
+// aiErrorStatus HTTP-статус и стабильный код ошибки генерации: недопустимые
+// параметры и модель без поставщика — 400, истёк дедлайн маршрута — 504, ошибки
+// поставщика — по их типу (domain.ProviderError), прочее — 500 ai_error
+func aiErrorStatus(err error) (int, string) {
+	var paramErr *domain.ParamError
+	switch {
+	case errors.As(err, &paramErr):
+		return http.StatusBadRequest, "validation_error"
+	case errors.Is(err, domain.ErrNoProvider):
+		return http.StatusBadRequest, "unknown_model"
+	case errors.Is(err, domain.ErrMissingAPIKey):
+		return http.StatusBadRequest, "missing_api_key"
+	case errors.Is(err, domain.ErrContentBlocked):
+		return http.StatusBadRequest, "content_blocked"
+	case errors.Is(err, domain.ErrProviderRejected):
+		return http.StatusBadRequest, "invalid_request"
+	case errors.Is(err, domain.ErrInvalidAPIKey):
+		return http.StatusUnauthorized, "invalid_api_key"
+	case errors.Is(err, domain.ErrBillingRequired):
+		return http.StatusPaymentRequired, "billing_required"
+	case errors.Is(err, domain.ErrPermissionDenied):
+		return http.StatusForbidden, "permission_denied"
+	case errors.Is(err, domain.ErrModelNotFound):
+		return http.StatusNotFound, "model_not_found"
+	case errors.Is(err, domain.ErrQuotaExceeded):
+		return http.StatusTooManyRequests, "quota_exceeded"
+	case errors.Is(err, context.DeadlineExceeded):
+		return http.StatusGatewayTimeout, "timeout"
+	case errors.Is(err, domain.ErrProviderError):
+		return http.StatusBadGateway, "provider_error"
+	case errors.Is(err, domain.ErrProviderUnavailable):
+		return http.StatusServiceUnavailable, "provider_unavailable"
+	}
+	return http.StatusInternalServerError, "ai_error"
+}
+
+// bindAITextRequest разбирает и валидирует запрос генерации текста, собирает политику
+// перебора моделей и достаёт ключ Gemini пользователя. При ошибке ответ уже записан и ok == false.
+func (h *Handler) bindAITextRequest(c *gin.Context) (req domain.AITextRequest, policy llm.Policy, apiKey string, ok bool) {
 	if err := c.ShouldBindJSON(&req); err != nil {
 		utils.Error(c.Writer, http.StatusBadRequest, "bad_request", "invalid body")
-		return
+		return req, policy, "", false
 	}
 	if req.Prompt == "" {
 		utils.Error(c.Writer, http.StatusBadRequest, "validation_error", "prompt required")
-		return
+		return req, policy, "", false
 	}
-	// Если модель не указана, используем дефолтную
-	if req.Model == "" {
-		req.Model = "gemini-2.0-flash-exp"
+	// Без модели и политики — политика по умолчанию из конфига, иначе модель по умолчанию
+	if req.Model == "" && req.Policy == "" {
+		if req.Fallback == nil {
+			req.Policy = h.ai.DefaultPolicy()
+		}
+		if req.Policy == "" {
+			req.Model = defaultTextModel
+		}
+	}
+	policy, err := h.ai.TextPolicy(req.Model, req.Policy, req.Fallback)
+	if err != nil {
+		writeAIError(c, err)
+		return req, policy, "", false
 	}
 	claims, ok := middleware.ClaimsFromContext(c)
 	if !ok {
 		utils.Error(c.Writer, http.StatusUnauthorized, "unauthorized", "no claims")
-		return
+		return req, policy, "", false
 	}
-	users := db.NewUsersProvider(h.db)
-	user, err := users.GetUserByTelegramID(claims.TgID)
+	apiKey, ok = h.userAPIKey(c, claims.TgID, policy.Models...)
+	return req, policy, apiKey, ok
+}
+
+// userAPIKey достаёт ключ Gemini пользователя для запроса к моделям.
+// Ключ обязателен, только если его требуют поставщики всех моделей: модели, которым
+// нужен ключ, политика пропускает. При ошибке ответ уже записан.
+func (h *Handler) userAPIKey(c *gin.Context, tgID int, models ...string) (string, bool) {
+	users := db.NewUsersProvider(h.db, h.keys)
+	user, err := users.GetUserByTelegramID(tgID)
 	if err != nil {
 		utils.Error(c.Writer, http.StatusUnauthorized, "unauthorized", "user not found")
-		return
+		return "", false
 	}
 
 	// Для локальных моделей ключ не требуется
-	apiKey := user.GeminiAPIKey.String
-	if !gemini.IsLocalModel(req.Model) {
-		if !user.GeminiAPIKey.Valid || user.GeminiAPIKey.String == "" {
-			utils.Error(c.Writer, http.StatusBadRequest, "missing_api_key", "set your Gemini API key first")
-			return
+	needsKey := true
+	for _, model := range models {
+		required, err := h.ai.RequiresAPIKey(model)
+		if err != nil {
+			writeAIError(c, err)
+			return "", false
 		}
+		needsKey = needsKey && required
 	}
-
-	text, err := h.ai.AskText(req.Model, apiKey, req.Prompt)
-	if err != nil {
-		utils.Error(c.Writer, http.StatusInternalServerError, "ai_error", err.Error())
-		return
+	if needsKey && (!user.GeminiAPIKey.Valid || user.GeminiAPIKey.String == "") {
+		utils.Error(c.Writer, http.StatusBadRequest, "missing_api_key", "set your Gemini API key first")
+		return "", false
 	}
-	utils.Success(c.Writer, map[string]string{"text": text})
+	return user.GeminiAPIKey.String, true
 }
 
 // @Summary Установить ключ Gemini
@@ -208,7 +423,7 @@ func (h *Handler) AISetKey(c *gin.Context) {
 		utils.Error(c.Writer, http.StatusBadRequest, "validation_error", "api_key too short")
 		return
 	}
-	users := db.NewUsersProvider(h.db)
+	users := db.NewUsersProvider(h.db, h.keys)
 	if err := users.SetGeminiAPIKey(claims.TgID, req.APIKey); err != nil {
 		utils.Error(c.Writer, http.StatusInternalServerError, "db_error", err.Error())
 		return
@@ -230,7 +445,7 @@ func (h *Handler) AIClearKey(c *gin.Context) {
 		utils.Error(c.Writer, http.StatusUnauthorized, "unauthorized", "no claims")
 		return
 	}
-	users := db.NewUsersProvider(h.db)
+	users := db.NewUsersProvider(h.db, h.keys)
 	if err := users.ClearGeminiAPIKey(claims.TgID); err != nil {
 		utils.Error(c.Writer, http.StatusInternalServerError, "db_error", err.Error())
 		return
@@ -252,7 +467,7 @@ func (h *Handler) AIKeyStatus(c *gin.Context) {
 		utils.Error(c.Writer, http.StatusUnauthorized, "unauthorized", "no claims")
 		return
 	}
-	users := db.NewUsersProvider(h.db)
+	users := db.NewUsersProvider(h.db, h.keys)
 	user, err := users.GetUserByTelegramID(claims.TgID)
 	if err != nil {
 		utils.Error(c.Writer, http.StatusUnauthorized, "unauthorized", "user not found")
@@ -0,0 +1,294 @@
+package http
+
+import (
+	"errors"
+	"geminiBackend/internal/delivery/http/middleware"
+	"geminiBackend/internal/domain"
+	"geminiBackend/internal/provider/db"
+	"geminiBackend/pkg/utils"
+	"io"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+// @Summary Добавить документ в базу знаний
+// @Description Принимает PDF, DOCX, TXT или Markdown, извлекает текст, режет его на фрагменты по KB_CHUNK_CHARS символов
+// @Description и сохраняет их вместе с эмбеддингами. Без model используется gemini-embedding-001, а без ключа Gemini — LOCAL_EMBEDDING_MODEL.
+// @Tags knowledge
+// @Accept multipart/form-data
+// @Produce json
+// @Security BearerAuth
+// @Param file formData file true "Документ"
+// @Param model formData string false "Модель эмбеддингов"
+// @Success 200 {object} domain.KBDocumentSuccessResponse
+// @Failure 400 {object} domain.ErrorResponse
+// @Failure 401 {object} domain.ErrorResponse
+// @Failure 402 {object} domain.ErrorResponse
+// @Failure 403 {object} domain.ErrorResponse
+// @Failure 413 {object} domain.ErrorResponse
+// @Failure 429 {object} domain.ErrorResponse
+// @Failure 500 {object} domain.ErrorResponse
+// @Failure 502 {object} domain.ErrorResponse
+// @Failure 503 {object} domain.ErrorResponse
+// @Router /user/kb/documents [post]
+func (h *Handler) UploadKBDocument(c *gin.Context) {
+	claims, ok := middleware.ClaimsFromContext(c)
+	if !ok {
+		utils.Error(c.Writer, http.StatusUnauthorized, "unauthorized", "no claims")
+		return
+	}
+
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, h.ai.DocumentUploadLimit())
+	fh, err := c.FormFile("file")
+	if err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			utils.Error(c.Writer, http.StatusRequestEntityTooLarge, "request_too_large", "upload exceeds size limit")
+			return
+		}
+		utils.Error(c.Writer, http.StatusBadRequest, "validation_error", "file required")
+		return
+	}
+	f, err := fh.Open()
+	if err != nil {
+		utils.Error(c.Writer, http.StatusBadRequest, "bad_request", "failed to read file")
+		return
+	}
+	data, err := io.ReadAll(f)
+	f.Close()
+	if err != nil {
+		utils.Error(c.Writer, http.StatusBadRequest, "bad_request", "failed to read file")
+		return
+	}
+
+	model, apiKey, ok := h.embeddingModelKey(c, claims.TgID, c.PostForm("model"))
+	if !ok {
+		return
+	}
+	doc, err := h.kb.AddDocument(c.Request.Context(), claims.TgID, model, apiKey, fh.Filename, data)
+	if err != nil {
+		writeKBError(c, err, "ai_error")
+		return
+	}
+	utils.Success(c.Writer, doc)
+}
+
+// @Summary Документы базы знаний
+// @Description Документы пользователя, новые сначала: число страниц и фрагментов, модель эмбеддингов
+// @Tags knowledge
+// @Produce json
+// @Security BearerAuth
+// @Param page query int false "Номер страницы (с 1)"
+// @Param page_size query int false "Размер страницы (до 100)"
+// @Success 200 {object} domain.KBDocumentsSuccessResponse
+// @Failure 400 {object} domain.ErrorResponse
+// @Failure 401 {object} domain.ErrorResponse
+// @Router /user/kb/documents [get]
+func (h *Handler) ListKBDocuments(c *gin.Context) {
+	claims, ok := middleware.ClaimsFromContext(c)
+	if !ok {
+		utils.Error(c.Writer, http.StatusUnauthorized, "unauthorized", "no claims")
+		return
+	}
+	page, pageSize, ok := parsePage(c)
+	if !ok {
+		return
+	}
+	docs, total, err := h.kb.List(claims.TgID, pageSize, (page-1)*pageSize)
+	if err != nil {
+		utils.Error(c.Writer, http.StatusInternalServerError, "db_error", err.Error())
+		return
+	}
+	utils.Success(c.Writer, domain.KBDocumentsResponse{Documents: docs, Total: total, Page: page, PageSize: pageSize})
+}
+
+// @Summary Удалить документ из базы знаний
+// @Description Удаляет документ вместе с фрагментами и их эмбеддингами
+// @Tags knowledge
+// @Produce json
+// @Security BearerAuth
+// @Param id path int true "ID документа"
+// @Success 200 {object} domain.OptionsSuccessResponse
+// @Failure 400 {object} domain.ErrorResponse
+// @Failure 401 {object} domain.ErrorResponse
+// @Failure 404 {object} domain.ErrorResponse
+// @Router /user/kb/documents/{id} [delete]
+func (h *Handler) DeleteKBDocument(c *gin.Context) {
+	claims, id, ok := kbDocumentTarget(c)
+	if !ok {
+		return
+	}
+	if err := h.kb.Delete(claims.TgID, id); err != nil {
+		writeKBError(c, err, "db_error")
+		return
+	}
+	utils.Success(c.Writer, map[string]string{"status": "ok"})
+}
+
+// @Summary Переиндексировать документ
+// @Description Заново режет сохранённый текст документа по текущим KB_CHUNK_CHARS и KB_CHUNK_OVERLAP
+// @Description и строит эмбеддинги указанной моделью (без model — прежней моделью документа).
+// @Tags knowledge
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param id path int true "ID документа"
+// @Param payload body domain.ReindexKBDocumentRequest false "Модель эмбеддингов"
+// @Success 200 {object} domain.KBDocumentSuccessResponse
+// @Failure 400 {object} domain.ErrorResponse
+// @Failure 401 {object} domain.ErrorResponse
+// @Failure 402 {object} domain.ErrorResponse
+// @Failure 403 {object} domain.ErrorResponse
+// @Failure 404 {object} domain.ErrorResponse
+// @Failure 429 {object} domain.ErrorResponse
+// @Failure 500 {object} domain.ErrorResponse
+// @Failure 502 {object} domain.ErrorResponse
+// @Failure 503 {object} domain.ErrorResponse
+// @Router /user/kb/documents/{id}/reindex [post]
+func (h *Handler) ReindexKBDocument(c *gin.Context) {
+	claims, id, ok := kbDocumentTarget(c)
+	if !ok {
+		return
+	}
+	var req domain.ReindexKBDocumentRequest
+	if c.Request.ContentLength != 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			utils.Error(c.Writer, http.StatusBadRequest, "bad_request", "invalid body")
+			return
+		}
+	}
+	requested := req.Model
+	if requested == "" {
+		doc, err := h.kb.Find(claims.TgID, id)
+		if err != nil {
+			writeKBError(c, err, "db_error")
+			return
+		}
+		requested = doc.Model
+	}
+	model, apiKey, ok := h.embeddingModelKey(c, claims.TgID, requested)
+	if !ok {
+		return
+	}
+
+	doc, err := h.kb.Reindex(c.Request.Context(), claims.TgID, id, model, apiKey)
+	if err != nil {
+		writeKBError(c, err, "ai_error")
+		return
+	}
+	utils.Success(c.Writer, doc)
+}
+
+// @Summary Вопрос к базе знаний
+// @Description Находит фрагменты документов пользователя, ближайшие к вопросу по косинусной близости эмбеддингов,
+// @Description и отвечает на вопрос по ним. citations — фрагменты, переданные модели как источники [n];
+// @Description cited отмечает те, на которые модель сослалась в ответе. Без model используется gemini-2.5-flash,
+// @Description а без ключа Gemini — LOCAL_ASK_MODEL, если она задана.
+// @Tags ai
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param payload body domain.AskRequest true "Вопрос"
+// @Success 200 {object} domain.AskSuccessResponse
+// @Failure 400 {object} domain.ErrorResponse
+// @Failure 401 {object} domain.ErrorResponse
+// @Failure 402 {object} domain.ErrorResponse
+// @Failure 403 {object} domain.ErrorResponse
+// @Failure 404 {object} domain.ErrorResponse
+// @Failure 429 {object} domain.ErrorResponse
+// @Failure 500 {object} domain.ErrorResponse
+// @Failure 502 {object} domain.ErrorResponse
+// @Failure 503 {object} domain.ErrorResponse
+// @Router /user/ai/ask [post]
+func (h *Handler) AIAsk(c *gin.Context) {
+	claims, ok := middleware.ClaimsFromContext(c)
+	if !ok {
+		utils.Error(c.Writer, http.StatusUnauthorized, "unauthorized", "no claims")
+		return
+	}
+	var req domain.AskRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		utils.Error(c.Writer, http.StatusBadRequest, "bad_request", "invalid body")
+		return
+	}
+
+	users := db.NewUsersProvider(h.db, h.keys)
+	user, err := users.GetUserByTelegramID(claims.TgID)
+	if err != nil {
+		utils.Error(c.Writer, http.StatusUnauthorized, "unauthorized", "user not found")
+		return
+	}
+	apiKey := user.GeminiAPIKey.String
+	model := h.kb.AskModel(req.Model, apiKey != "")
+	needsKey, err := h.ai.RequiresAPIKey(model)
+	if err != nil {
+		writeAIError(c, err)
+		return
+	}
+	if needsKey && apiKey == "" {
+		utils.Error(c.Writer, http.StatusBadRequest, "missing_api_key", "set your Gemini API key first")
+		return
+	}
+
+	resp, err := h.kb.Ask(c.Request.Context(), claims.TgID, model, apiKey, req)
+	if err != nil {
+		writeKBError(c, err, "ai_error")
+		return
+	}
+	utils.Success(c.Writer, resp)
+}
+
+// embeddingModelKey выбирает модель эмбеддингов (см. AIService.EmbeddingModel) и достаёт
+// ключ Gemini пользователя, если он нужен модели. При ошибке ответ уже записан и ok == false.
+func (h *Handler) embeddingModelKey(c *gin.Context, tgID int, requested string) (model, apiKey string, ok bool) {
+	users := db.NewUsersProvider(h.db, h.keys)
+	user, err := users.GetUserByTelegramID(tgID)
+	if err != nil {
+		utils.Error(c.Writer, http.StatusUnauthorized, "unauthorized", "user not found")
+		return "", "", false
+	}
+	apiKey = user.GeminiAPIKey.String
+	model = h.ai.EmbeddingModel(requested, apiKey != "")
+	needsKey, err := h.ai.RequiresAPIKey(model)
+	if err != nil {
+		writeAIError(c, err)
+		return "", "", false
+	}
+	if needsKey && apiKey == "" {
+		utils.Error(c.Writer, http.StatusBadRequest, "missing_api_key", "set your Gemini API key first")
+		return "", "", false
+	}
+	return model, apiKey, true
+}
+
+func writeKBError(c *gin.Context, err error, fallbackCode string) {
+	switch err {
+	case domain.ErrKBDocumentNotFound:
+		utils.Error(c.Writer, http.StatusNotFound, "document_not_found", err.Error())
+	case domain.ErrKBEmpty:
+		utils.Error(c.Writer, http.StatusBadRequest, "knowledge_base_empty", err.Error())
+	default:
+		if fallbackCode == "ai_error" {
+			writeAIError(c, err)
+			return
+		}
+		utils.Error(c.Writer, http.StatusInternalServerError, fallbackCode, err.Error())
+	}
+}
+
+// kbDocumentTarget достаёт клеймы пользователя и id документа базы знаний из пути
+func kbDocumentTarget(c *gin.Context) (*domain.Claims, int64, bool) {
+	claims, ok := middleware.ClaimsFromContext(c)
+	if !ok {
+		utils.Error(c.Writer, http.StatusUnauthorized, "unauthorized", "no claims")
+		return nil, 0, false
+	}
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil || id <= 0 {
+		utils.Error(c.Writer, http.StatusBadRequest, "validation_error", "invalid document id")
+		return nil, 0, false
+	}
+	return claims, id, true
+}
@@ -14,17 +14,16 @@ const ClaimsContextKey = "claims"
 
 func JWTAuth(auth *service.AuthService) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		header := c.GetHeader("Authorization")
-		parts := strings.Split(header, " ")
-		if len(parts) != 2 || parts[0] != "Bearer" {
+		token, ok := bearerToken(c)
+		if !ok {
 			utils.Error(c.Writer, http.StatusUnauthorized, "auth_error", "invalid auth header format")
 			c.Abort()
 			return
 		}
 
-		claims, err := auth.Parse(parts[1])
-		if err != nil {
-			utils.Error(c.Writer, http.StatusUnauthorized, "invalid_token", "invalid or expired token")
+		claims, code, message := sessionClaims(auth, token)
+		if claims == nil {
+			utils.Error(c.Writer, http.StatusUnauthorized, code, message)
 			c.Abort()
 			return
 		}
@@ -34,6 +33,58 @@ func JWTAuth(auth *service.AuthService) gin.HandlerFunc {
 	}
 }
 
+// APIAuth авторизация OpenAI-совместимого API: JWT сессии или персональный токен.
+// Ошибки возвращаются в формате OpenAI, чтобы их понимали клиентские SDK.
+func APIAuth(auth *service.AuthService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		token, ok := bearerToken(c)
+		if !ok {
+			utils.OpenAIError(c.Writer, http.StatusUnauthorized, "invalid_request_error", "auth_error", "invalid auth header format")
+			c.Abort()
+			return
+		}
+
+		var claims *domain.Claims
+		code, message := "invalid_api_key", "invalid api token"
+		if service.IsAPIToken(token) {
+			claims, _ = auth.AuthenticateAPIToken(token)
+		} else {
+			claims, code, message = sessionClaims(auth, token)
+		}
+		if claims == nil {
+			utils.OpenAIError(c.Writer, http.StatusUnauthorized, "invalid_request_error", code, message)
+			c.Abort()
+			return
+		}
+
+		c.Set(ClaimsContextKey, claims)
+		c.Next()
+	}
+}
+
+// bearerToken достаёт токен из заголовка "Authorization: Bearer <token>"
+func bearerToken(c *gin.Context) (string, bool) {
+	parts := strings.Split(c.GetHeader("Authorization"), " ")
+	if len(parts) != 2 || parts[0] != "Bearer" {
+		return "", false
+	}
+	return parts[1], true
+}
+
+// sessionClaims проверяет JWT и его сессию. При ошибке claims == nil, code и message — для ответа.
+func sessionClaims(auth *service.AuthService, token string) (claims *domain.Claims, code, message string) {
+	claims, err := auth.Parse(token)
+	if err != nil {
+		return nil, "invalid_token", "invalid or expired token"
+	}
+
+	// Сессия могла быть отозвана (logout) или пользователь деактивирован
+	if err := auth.CheckSession(claims); err != nil {
+		return nil, "session_revoked", "session revoked or user inactive"
+	}
+	return claims, "", ""
+}
+
 func AdminOnly() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		claims, exists := c.Get(ClaimsContextKey)
@@ -0,0 +1,57 @@
+package http
+
+import (
+	"geminiBackend/internal/delivery/http/middleware"
+	"geminiBackend/internal/domain"
+	"geminiBackend/pkg/utils"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// @Summary Исправление текста после OCR
+// @Description Исправляет ошибки распознавания в тексте. language — подсказка языка (ru, en, «русский»),
+// @Description strictness — строгость: light (только явные ошибки распознавания), normal (и опечатки, по умолчанию),
+// @Description aggressive (также орфография, пунктуация, разорванные строки). В ответе — исправленный текст,
+// @Description правки по словам (diff: equal, delete, insert) и доля изменённых слов change_ratio.
+// @Description Без model используется OCR_MODEL; длинный текст исправляется по частям.
+// @Tags ai
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param payload body domain.OCRCorrectRequest true "Текст и параметры исправления"
+// @Success 200 {object} domain.OCRCorrectSuccessResponse
+// @Failure 400 {object} domain.ErrorResponse
+// @Failure 401 {object} domain.ErrorResponse
+// @Failure 402 {object} domain.ErrorResponse
+// @Failure 403 {object} domain.ErrorResponse
+// @Failure 404 {object} domain.ErrorResponse
+// @Failure 429 {object} domain.ErrorResponse
+// @Failure 500 {object} domain.ErrorResponse
+// @Failure 502 {object} domain.ErrorResponse
+// @Failure 503 {object} domain.ErrorResponse
+// @Router /user/ai/ocr-correct [post]
+func (h *Handler) AIOCRCorrect(c *gin.Context) {
+	claims, ok := middleware.ClaimsFromContext(c)
+	if !ok {
+		utils.Error(c.Writer, http.StatusUnauthorized, "unauthorized", "no claims")
+		return
+	}
+	var req domain.OCRCorrectRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		utils.Error(c.Writer, http.StatusBadRequest, "bad_request", "invalid body")
+		return
+	}
+	model := h.ai.OCRModel(req.Model)
+	apiKey, ok := h.userAPIKey(c, claims.TgID, model)
+	if !ok {
+		return
+	}
+
+	resp, err := h.ai.CorrectOCR(c.Request.Context(), model, apiKey, req)
+	if err != nil {
+		writeAIError(c, err)
+		return
+	}
+	utils.Success(c.Writer, resp)
+}
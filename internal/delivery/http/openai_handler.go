@@ -0,0 +1,361 @@
+package http
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"geminiBackend/internal/delivery/http/middleware"
+	"geminiBackend/internal/domain"
+	"geminiBackend/internal/provider/db"
+	"geminiBackend/internal/provider/llm"
+	"geminiBackend/pkg/logger"
+	"geminiBackend/pkg/utils"
+	"math"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// OpenAI-совместимый API (/v1): запросы и ответы в формате OpenAI, маршрутизация моделей
+// та же, что у /api/user/ai. Эндпоинты не описаны в Swagger — их контракт задаёт OpenAI.
+
+// OpenAIChatCompletions POST /v1/chat/completions, в том числе stream=true (SSE-чанки chat.completion.chunk)
+func (h *Handler) OpenAIChatCompletions(c *gin.Context) {
+	claims, ok := middleware.ClaimsFromContext(c)
+	if !ok {
+		utils.OpenAIError(c.Writer, http.StatusUnauthorized, "invalid_request_error", "unauthorized", "no claims")
+		return
+	}
+	var body domain.OpenAIChatRequest
+	if err := c.ShouldBindJSON(&body); err != nil {
+		utils.OpenAIError(c.Writer, http.StatusBadRequest, "invalid_request_error", "invalid_body", "invalid body: "+err.Error())
+		return
+	}
+	req, err := completionRequest(body)
+	if err != nil {
+		writeOpenAIError(c, err)
+		return
+	}
+	if req.APIKey, ok = h.openAIUserKey(c, claims.TgID, req.Model); !ok {
+		return
+	}
+
+	id := "chatcmpl-" + randomID()
+	created := time.Now().Unix()
+	if body.Stream {
+		includeUsage := body.StreamOptions != nil && body.StreamOptions.IncludeUsage
+		h.streamChatCompletion(c, req, id, created, includeUsage)
+		return
+	}
+
+	resp, err := h.ai.Complete(c.Request.Context(), req)
+	if err != nil {
+		writeOpenAIError(c, err)
+		return
+	}
+	stop := "stop"
+	choices := make([]domain.OpenAIChoice, len(resp.Texts))
+	for i, text := range resp.Texts {
+		choices[i] = domain.OpenAIChoice{
+			Index:        i,
+			Message:      &domain.OpenAIResponseMessage{Role: domain.ChatRoleAssistant, Content: text},
+			FinishReason: &stop,
+		}
+	}
+	utils.RespondWithJSON(c.Writer, http.StatusOK, domain.OpenAIChatResponse{
+		ID:      id,
+		Object:  "chat.completion",
+		Created: created,
+		Model:   req.Model,
+		Choices: choices,
+		Usage:   openAIUsage(resp.Usage),
+	})
+}
+
+// streamChatCompletion отдаёт ответ SSE-чанками в формате OpenAI и завершает поток строкой [DONE].
+// Ошибки до начала потока возвращаются обычным JSON, после — чанком {"error": {...}}.
+func (h *Handler) streamChatCompletion(c *gin.Context, req llm.Request, id string, created int64, includeUsage bool) {
+	if err := h.ai.ValidateCompletion(c.Request.Context(), req, true); err != nil {
+		writeOpenAIError(c, err)
+		return
+	}
+
+	w := c.Writer
+	header := w.Header()
+	header.Set("Content-Type", "text/event-stream")
+	header.Set("Cache-Control", "no-cache")
+	header.Set("Connection", "keep-alive")
+	header.Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+
+	chunk := func(delta *domain.OpenAIResponseMessage, finishReason *string) domain.OpenAIChatResponse {
+		return domain.OpenAIChatResponse{
+			ID:      id,
+			Object:  "chat.completion.chunk",
+			Created: created,
+			Model:   req.Model,
+			Choices: []domain.OpenAIChoice{{Delta: delta, FinishReason: finishReason}},
+		}
+	}
+	if err := writeSSEData(w, chunk(&domain.OpenAIResponseMessage{Role: domain.ChatRoleAssistant}, nil)); err != nil {
+		return
+	}
+
+	ctx := c.Request.Context()
+	usage, err := h.ai.StreamCompletion(ctx, req, func(text string) error {
+		return writeSSEData(w, chunk(&domain.OpenAIResponseMessage{Content: text}, nil))
+	})
+	if errors.Is(ctx.Err(), context.Canceled) {
+		logger.L.Debug("openai stream stopped: client disconnected", "model", req.Model)
+		return
+	}
+	if err != nil {
+		logger.L.Error("openai stream failed", "error", err.Error(), "model", req.Model)
+		status, code := aiErrorStatus(err)
+		writeSSEData(w, domain.OpenAIErrorResponse{Error: domain.OpenAIError{Message: aiErrorMessage(err, status), Type: openAIErrorType(status), Code: code}})
+		return
+	}
+
+	stop := "stop"
+	writeSSEData(w, chunk(&domain.OpenAIResponseMessage{}, &stop))
+	if includeUsage {
+		final := chunk(nil, nil)
+		final.Choices = []domain.OpenAIChoice{}
+		final.Usage = openAIUsage(usage)
+		writeSSEData(w, final)
+	}
+	fmt.Fprint(w, "data: [DONE]\n\n")
+	w.Flush()
+}
+
+// OpenAIModels GET /v1/models — объединённый список моделей всех поставщиков
+func (h *Handler) OpenAIModels(c *gin.Context) {
+	claims, ok := middleware.ClaimsFromContext(c)
+	if !ok {
+		utils.OpenAIError(c.Writer, http.StatusUnauthorized, "invalid_request_error", "unauthorized", "no claims")
+		return
+	}
+	user, err := db.NewUsersProvider(h.db, h.keys).GetUserByTelegramID(claims.TgID)
+	if err != nil {
+		utils.OpenAIError(c.Writer, http.StatusUnauthorized, "invalid_request_error", "unauthorized", "user not found")
+		return
+	}
+	models, err := h.ai.ListModels(c.Request.Context(), user.GeminiAPIKey.String)
+	if err != nil {
+		writeOpenAIError(c, err)
+		return
+	}
+	list := domain.OpenAIModelList{Object: "list", Data: make([]domain.OpenAIModel, 0, len(models))}
+	for _, m := range models {
+		list.Data = append(list.Data, domain.OpenAIModel{
+			ID:      strings.TrimPrefix(m.Name, "models/"),
+			Object:  "model",
+			OwnedBy: m.Provider,
+		})
+	}
+	utils.RespondWithJSON(c.Writer, http.StatusOK, list)
+}
+
+// OpenAIEmbeddings POST /v1/embeddings; encoding_format float (по умолчанию) или base64
+func (h *Handler) OpenAIEmbeddings(c *gin.Context) {
+	claims, ok := middleware.ClaimsFromContext(c)
+	if !ok {
+		utils.OpenAIError(c.Writer, http.StatusUnauthorized, "invalid_request_error", "unauthorized", "no claims")
+		return
+	}
+	var body domain.OpenAIEmbeddingRequest
+	if err := c.ShouldBindJSON(&body); err != nil {
+		utils.OpenAIError(c.Writer, http.StatusBadRequest, "invalid_request_error", "invalid_body", "invalid body: "+err.Error())
+		return
+	}
+	if body.Model == "" {
+		writeOpenAIError(c, &domain.ParamError{Field: "model", Reason: "required"})
+		return
+	}
+	if body.EncodingFormat != "" && body.EncodingFormat != "float" && body.EncodingFormat != "base64" {
+		writeOpenAIError(c, &domain.ParamError{Field: "encoding_format", Reason: "must be float or base64"})
+		return
+	}
+	apiKey, ok := h.openAIUserKey(c, claims.TgID, body.Model)
+	if !ok {
+		return
+	}
+	req := llm.EmbedRequest{Model: body.Model, APIKey: apiKey, Inputs: body.Input}
+	if body.Dimensions != nil {
+		req.Dimensions = *body.Dimensions
+	}
+	resp, err := h.ai.Embed(c.Request.Context(), req)
+	if err != nil {
+		writeOpenAIError(c, err)
+		return
+	}
+
+	data := make([]domain.OpenAIEmbedding, len(resp.Vectors))
+	for i, vec := range resp.Vectors {
+		var embedding any = vec
+		if body.EncodingFormat == "base64" {
+			embedding = encodeVector(vec)
+		}
+		data[i] = domain.OpenAIEmbedding{Object: "embedding", Index: i, Embedding: embedding}
+	}
+	utils.RespondWithJSON(c.Writer, http.StatusOK, domain.OpenAIEmbeddingResponse{
+		Object: "list",
+		Data:   data,
+		Model:  body.Model,
+		Usage:  domain.OpenAIUsage{PromptTokens: resp.Usage.PromptTokens, TotalTokens: resp.Usage.TotalTokens},
+	})
+}
+
+// completionRequest переводит запрос OpenAI во внутренний: system/developer-сообщения
+// становятся системной инструкцией, изображения (только data: URL) — вложениями
+// последнего сообщения
+func completionRequest(body domain.OpenAIChatRequest) (llm.Request, error) {
+	req := llm.Request{Model: body.Model}
+	if body.Model == "" {
+		return req, &domain.ParamError{Field: "model", Reason: "required"}
+	}
+
+	var system []string
+	for i, m := range body.Messages {
+		switch m.Role {
+		case "system", "developer":
+			system = append(system, m.Content.Text)
+			continue
+		case domain.ChatRoleUser, domain.ChatRoleAssistant:
+		default:
+			return req, &domain.ParamError{Field: "messages", Reason: fmt.Sprintf("unsupported role %q", m.Role)}
+		}
+		if len(m.Content.ImageURLs) > 0 && i != len(body.Messages)-1 {
+			return req, &domain.ParamError{Field: "messages", Reason: "images are supported only in the last message"}
+		}
+		for _, url := range m.Content.ImageURLs {
+			data, err := decodeDataURL(url)
+			if err != nil {
+				return req, &domain.ParamError{Field: "messages", Reason: err.Error()}
+			}
+			req.Attachments = append(req.Attachments, domain.Attachment{Data: data})
+		}
+		req.Messages = append(req.Messages, domain.ChatMessage{Role: m.Role, Content: m.Content.Text})
+	}
+
+	req.Params = domain.GenerationParams{
+		SystemInstruction: strings.Join(system, "\n\n"),
+		Temperature:       body.Temperature,
+		TopP:              body.TopP,
+		TopK:              body.TopK,
+		MaxOutputTokens:   body.MaxTokens,
+		StopSequences:     body.Stop,
+		Seed:              body.Seed,
+		CandidateCount:    body.N,
+	}
+	if body.MaxCompletionTokens != nil {
+		req.Params.MaxOutputTokens = body.MaxCompletionTokens
+	}
+	return req, nil
+}
+
+// decodeDataURL достаёт данные из "data:<mime>;base64,<data>"; внешние URL не загружаются
+func decodeDataURL(url string) ([]byte, error) {
+	meta, payload, ok := strings.Cut(strings.TrimPrefix(url, "data:"), ",")
+	if !strings.HasPrefix(url, "data:") || !ok || !strings.HasSuffix(meta, ";base64") {
+		return nil, errors.New("only base64 data: URLs are supported for images")
+	}
+	data, err := base64.StdEncoding.DecodeString(payload)
+	if err != nil {
+		return nil, errors.New("invalid base64 in image data URL")
+	}
+	return data, nil
+}
+
+// openAIUserKey достаёт ключ Gemini пользователя, если он нужен поставщику модели.
+// При ошибке ответ в формате OpenAI уже записан.
+func (h *Handler) openAIUserKey(c *gin.Context, tgID int, model string) (string, bool) {
+	user, err := db.NewUsersProvider(h.db, h.keys).GetUserByTelegramID(tgID)
+	if err != nil {
+		utils.OpenAIError(c.Writer, http.StatusUnauthorized, "invalid_request_error", "unauthorized", "user not found")
+		return "", false
+	}
+	needsKey, err := h.ai.RequiresAPIKey(model)
+	if err != nil {
+		writeOpenAIError(c, err)
+		return "", false
+	}
+	if needsKey && (!user.GeminiAPIKey.Valid || user.GeminiAPIKey.String == "") {
+		utils.OpenAIError(c.Writer, http.StatusBadRequest, "invalid_request_error", "missing_api_key", "set your Gemini API key first")
+		return "", false
+	}
+	return user.GeminiAPIKey.String, true
+}
+
+// writeOpenAIError переводит ошибку сервиса в ответ формата OpenAI; статус, код
+// и текст — как у writeAIError
+func writeOpenAIError(c *gin.Context, err error) {
+	var paramErr *domain.ParamError
+	if errors.As(err, &paramErr) {
+		utils.OpenAIParamError(c.Writer, http.StatusBadRequest, "invalid_request_error", "invalid_value", paramErr.Field, paramErr.Error())
+		return
+	}
+	if errors.Is(err, domain.ErrNoProvider) {
+		utils.OpenAIParamError(c.Writer, http.StatusNotFound, "invalid_request_error", "model_not_found", "model", err.Error())
+		return
+	}
+	status, code := aiErrorStatus(err)
+	logAIError(err, status, code)
+	utils.OpenAIError(c.Writer, status, openAIErrorType(status), code, aiErrorMessage(err, status))
+}
+
+// openAIErrorType тип ошибки OpenAI по HTTP-статусу
+func openAIErrorType(status int) string {
+	switch status {
+	case http.StatusUnauthorized:
+		return "authentication_error"
+	case http.StatusForbidden:
+		return "permission_error"
+	case http.StatusTooManyRequests:
+		return "rate_limit_error"
+	}
+	if status < http.StatusInternalServerError {
+		return "invalid_request_error"
+	}
+	return "api_error"
+}
+
+func openAIUsage(u domain.AIUsage) *domain.OpenAIUsage {
+	return &domain.OpenAIUsage{PromptTokens: u.PromptTokens, CompletionTokens: u.CompletionTokens, TotalTokens: u.TotalTokens}
+}
+
+// encodeVector кодирует вектор как base64 от float32 little-endian (encoding_format=base64)
+func encodeVector(vec []float32) string {
+	buf := make([]byte, 4*len(vec))
+	for i, v := range vec {
+		binary.LittleEndian.PutUint32(buf[4*i:], math.Float32bits(v))
+	}
+	return base64.StdEncoding.EncodeToString(buf)
+}
+
+// writeSSEData пишет событие SSE без имени (формат потоков OpenAI) и сразу отправляет его клиенту
+func writeSSEData(w gin.ResponseWriter, data any) error {
+	payload, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	if _, err := fmt.Fprintf(w, "data: %s\n\n", payload); err != nil {
+		return err
+	}
+	w.Flush()
+	return nil
+}
+
+// randomID случайный идентификатор ответа
+func randomID() string {
+	b := make([]byte, 12)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
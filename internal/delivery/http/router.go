@@ -1,6 +1,7 @@
 package http
 
 import (
+	"geminiBackend/config"
 	"geminiBackend/internal/delivery/http/middleware"
 	"geminiBackend/pkg/logger"
 
@@ -9,7 +10,8 @@ import (
 	ginSwagger "github.com/swaggo/gin-swagger"
 )
 
-func NewRouter(h *Handler, jwtMiddleware gin.HandlerFunc, adminOnly gin.HandlerFunc, rl middleware.RateLimiter) *gin.Engine {
+// NewRouter регистрирует маршруты; AI-маршруты получают дедлайны из timeouts
+func NewRouter(h *Handler, jwtMiddleware, apiAuth, adminOnly gin.HandlerFunc, rl middleware.RateLimiter, timeouts config.AITimeouts) *gin.Engine {
 	r := gin.Default()
 	logger.L.Info("Initializing Gin router")
 
@@ -29,22 +31,64 @@ func NewRouter(h *Handler, jwtMiddleware gin.HandlerFunc, adminOnly gin.HandlerF
 	public.Use(rlMiddleware)
 	public.POST("/login", h.Login)
 	public.POST("/register", h.Register)
+	public.POST("/token/refresh", h.RefreshToken)
+
+	// Управление сессией
+	session := api.Group("")
+	session.Use(jwtMiddleware)
+	session.POST("/logout", h.Logout)
+	session.POST("/logout/all", h.LogoutAll)
 
 	// Маршруты администратора
 	admin := api.Group("/admin")
 	admin.Use(jwtMiddleware, adminOnly)
 	admin.GET("/ping", h.AdminPing)
 	admin.GET("/options", h.Options)
+	admin.GET("/users", h.AdminListUsers)
+	admin.GET("/users/:tg_id", h.AdminGetUser)
+	admin.PUT("/users/:tg_id/admin", h.AdminSetAdmin)
+	admin.PUT("/users/:tg_id/active", h.AdminSetActive)
+	admin.DELETE("/users/:tg_id/key", h.AdminClearKey)
+	admin.DELETE("/users/:tg_id", h.AdminDeleteUser)
+	admin.GET("/audit", h.AdminAudit)
+	admin.GET("/ai/stats", h.AdminAIStats)
 
 	// Пользовательские маршруты
 	user := api.Group("/user")
 	user.Use(jwtMiddleware)
 	user.GET("/ping", h.UserPing)
-	user.GET("/ai/models", rlMiddleware, h.AIModels)
-	user.POST("/ai/text", rlMiddleware, h.AIText)
+	user.GET("/ai/models", rlMiddleware, middleware.Deadline(timeouts.Models), h.AIModels)
+	user.POST("/ai/text", rlMiddleware, middleware.Deadline(timeouts.Text), h.AIText)
+	user.POST("/ai/text/stream", rlMiddleware, middleware.Deadline(timeouts.Stream), h.AITextStream)
+	user.POST("/ai/vision", rlMiddleware, middleware.Deadline(timeouts.Vision), h.AIVision)
+	user.POST("/ai/document", rlMiddleware, middleware.Deadline(timeouts.Document), h.AIDocument)
+	user.POST("/ai/ocr-correct", rlMiddleware, middleware.Deadline(timeouts.Text), h.AIOCRCorrect)
+	user.POST("/ai/embeddings", rlMiddleware, middleware.Deadline(timeouts.Embeddings), h.AIEmbeddings)
+	user.POST("/ai/count-tokens", rlMiddleware, middleware.Deadline(timeouts.Models), h.AICountTokens)
+	user.POST("/ai/ask", rlMiddleware, middleware.Deadline(timeouts.Chat), h.AIAsk)
 	user.POST("/ai/key", rlMiddleware, h.AISetKey)
 	user.DELETE("/ai/key", rlMiddleware, h.AIClearKey)
 	user.GET("/ai/key", rlMiddleware, h.AIKeyStatus)
+	user.POST("/conversations", rlMiddleware, h.CreateConversation)
+	user.GET("/conversations", rlMiddleware, h.ListConversations)
+	user.GET("/conversations/:id", rlMiddleware, h.GetConversation)
+	user.PUT("/conversations/:id", rlMiddleware, h.RenameConversation)
+	user.DELETE("/conversations/:id", rlMiddleware, h.DeleteConversation)
+	user.POST("/conversations/:id/messages", rlMiddleware, middleware.Deadline(timeouts.Chat), h.PostConversationMessage)
+	user.POST("/kb/documents", rlMiddleware, middleware.Deadline(timeouts.Document), h.UploadKBDocument)
+	user.GET("/kb/documents", rlMiddleware, h.ListKBDocuments)
+	user.DELETE("/kb/documents/:id", rlMiddleware, h.DeleteKBDocument)
+	user.POST("/kb/documents/:id/reindex", rlMiddleware, middleware.Deadline(timeouts.Document), h.ReindexKBDocument)
+	user.POST("/tokens", rlMiddleware, h.CreateAPIToken)
+	user.GET("/tokens", rlMiddleware, h.ListAPITokens)
+	user.DELETE("/tokens/:id", rlMiddleware, h.DeleteAPIToken)
+
+	// OpenAI-совместимый API: JWT или персональный токен
+	v1 := r.Group("/v1")
+	v1.Use(apiAuth, rlMiddleware)
+	v1.GET("/models", middleware.Deadline(timeouts.Models), h.OpenAIModels)
+	v1.POST("/chat/completions", middleware.Deadline(timeouts.Chat), h.OpenAIChatCompletions)
+	v1.POST("/embeddings", middleware.Deadline(timeouts.Embeddings), h.OpenAIEmbeddings)
 
 	// Swagger документация
 	r.GET("/swagger/*any", ginSwagger.WrapHandler(swaggerFiles.NewHandler()))
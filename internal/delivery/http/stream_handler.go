@@ -0,0 +1,90 @@
+package http
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"geminiBackend/internal/domain"
+	"geminiBackend/pkg/logger"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// @Summary Потоковая генерация текста (SSE)
+// @Description Генерирует текст и отдаёт его по мере готовности как Server-Sent Events.
+// @Description События: `delta` — фрагмент текста `{"text": "..."}`; `usage` — итоговый расход токенов
+// @Description и модель, которая ответила (последнее событие при успехе); `fallback` — переход к следующей
+// @Description модели политики до начала ответа; `progress` — ход обработки длинного текста по частям
+// @Description `{"done": 2, "total": 5, "failed": 0}` (ответ каждой части приходит одним `delta`, по порядку частей,
+// @Description неудачная часть — в `failed_chunk` и пропускается); `error` — ошибка генерации `{"code": "...", "message": "..."}`.
+// @Description Ошибки валидации (в т.ч. параметров генерации; candidate_count только 1) до начала потока возвращаются обычным JSON.
+// @Tags ai
+// @Accept json
+// @Produce text/event-stream
+// @Security BearerAuth
+// @Param payload body domain.AITextRequest true "Запрос на генерацию"
+// @Success 200 {string} string "text/event-stream"
+// @Failure 400 {object} domain.ErrorResponse
+// @Failure 401 {object} domain.ErrorResponse
+// @Failure 402 {object} domain.ErrorResponse
+// @Failure 403 {object} domain.ErrorResponse
+// @Failure 404 {object} domain.ErrorResponse
+// @Failure 429 {object} domain.ErrorResponse
+// @Failure 502 {object} domain.ErrorResponse
+// @Failure 503 {object} domain.ErrorResponse
+// @Router /user/ai/text/stream [post]
+func (h *Handler) AITextStream(c *gin.Context) {
+	req, policy, apiKey, ok := h.bindAITextRequest(c)
+	if !ok {
+		return
+	}
+	if err := h.ai.ValidatePolicy(c.Request.Context(), policy, apiKey, req.GenerationParams, true); err != nil {
+		writeAIError(c, err)
+		return
+	}
+
+	w := c.Writer
+	header := w.Header()
+	header.Set("Content-Type", "text/event-stream")
+	header.Set("Cache-Control", "no-cache")
+	header.Set("Connection", "keep-alive")
+	header.Set("X-Accel-Buffering", "no") // nginx не должен буферизовать поток
+	w.WriteHeader(http.StatusOK)
+	w.Flush()
+
+	// Контекст запроса отменяется при отключении клиента — генерация останавливается
+	ctx := c.Request.Context()
+	usage, err := h.ai.StreamText(ctx, policy, apiKey, req.Prompt, req.GenerationParams, func(text string) error {
+		return writeSSE(w, "delta", domain.AIStreamDelta{Text: text})
+	}, func(event domain.FallbackEvent) {
+		writeSSE(w, "fallback", event)
+	}, func(progress domain.ChunkProgress) {
+		writeSSE(w, "progress", progress)
+	})
+	if errors.Is(ctx.Err(), context.Canceled) {
+		logger.L.Debug("ai stream stopped: client disconnected", "model", usage.Model)
+		return
+	}
+	if err != nil {
+		logger.L.Error("ai stream failed", "error", err.Error(), "model", usage.Model)
+		status, code := aiErrorStatus(err)
+		writeSSE(w, "error", domain.ErrorDetails{Code: code, Message: aiErrorMessage(err, status)})
+		return
+	}
+	writeSSE(w, "usage", usage)
+}
+
+// writeSSE пишет одно событие Server-Sent Events и сразу отправляет его клиенту
+func writeSSE(w gin.ResponseWriter, event string, data any) error {
+	payload, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	if _, err := fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event, payload); err != nil {
+		return err
+	}
+	w.Flush()
+	return nil
+}
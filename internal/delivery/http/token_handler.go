@@ -0,0 +1,98 @@
+package http
+
+import (
+	"geminiBackend/internal/delivery/http/middleware"
+	"geminiBackend/internal/domain"
+	"geminiBackend/pkg/utils"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+// @Summary Выпустить персональный токен
+// @Description Создаёт токен для OpenAI-совместимого API (/v1). Значение токена возвращается только в этом ответе.
+// @Tags tokens
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param payload body domain.CreateAPITokenRequest true "Название токена (до 100 символов)"
+// @Success 200 {object} domain.CreateAPITokenSuccessResponse
+// @Failure 400 {object} domain.ErrorResponse
+// @Failure 401 {object} domain.ErrorResponse
+// @Router /user/tokens [post]
+func (h *Handler) CreateAPIToken(c *gin.Context) {
+	claims, ok := middleware.ClaimsFromContext(c)
+	if !ok {
+		utils.Error(c.Writer, http.StatusUnauthorized, "unauthorized", "no claims")
+		return
+	}
+	var req domain.CreateAPITokenRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		utils.Error(c.Writer, http.StatusBadRequest, "bad_request", "invalid body")
+		return
+	}
+	resp, err := h.auth.CreateAPIToken(claims.TgID, req.Name)
+	if err != nil {
+		if err == domain.ErrInvalidInput {
+			utils.Error(c.Writer, http.StatusBadRequest, "validation_error", "name is too long")
+			return
+		}
+		utils.Error(c.Writer, http.StatusInternalServerError, "db_error", err.Error())
+		return
+	}
+	utils.Success(c.Writer, resp)
+}
+
+// @Summary Список персональных токенов
+// @Description Токены пользователя без значений: название, начало токена, время создания и последнего использования
+// @Tags tokens
+// @Produce json
+// @Security BearerAuth
+// @Success 200 {object} domain.APITokensSuccessResponse
+// @Failure 401 {object} domain.ErrorResponse
+// @Router /user/tokens [get]
+func (h *Handler) ListAPITokens(c *gin.Context) {
+	claims, ok := middleware.ClaimsFromContext(c)
+	if !ok {
+		utils.Error(c.Writer, http.StatusUnauthorized, "unauthorized", "no claims")
+		return
+	}
+	tokens, err := h.auth.ListAPITokens(claims.TgID)
+	if err != nil {
+		utils.Error(c.Writer, http.StatusInternalServerError, "db_error", err.Error())
+		return
+	}
+	utils.Success(c.Writer, map[string]interface{}{"tokens": tokens})
+}
+
+// @Summary Отозвать персональный токен
+// @Tags tokens
+// @Produce json
+// @Security BearerAuth
+// @Param id path int true "ID токена"
+// @Success 200 {object} domain.OptionsSuccessResponse
+// @Failure 400 {object} domain.ErrorResponse
+// @Failure 404 {object} domain.ErrorResponse
+// @Router /user/tokens/{id} [delete]
+func (h *Handler) DeleteAPIToken(c *gin.Context) {
+	claims, ok := middleware.ClaimsFromContext(c)
+	if !ok {
+		utils.Error(c.Writer, http.StatusUnauthorized, "unauthorized", "no claims")
+		return
+	}
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil || id <= 0 {
+		utils.Error(c.Writer, http.StatusBadRequest, "validation_error", "invalid token id")
+		return
+	}
+	if err := h.auth.DeleteAPIToken(claims.TgID, id); err != nil {
+		if err == domain.ErrAPITokenNotFound {
+			utils.Error(c.Writer, http.StatusNotFound, "token_not_found", err.Error())
+			return
+		}
+		utils.Error(c.Writer, http.StatusInternalServerError, "db_error", err.Error())
+		return
+	}
+	utils.Success(c.Writer, map[string]string{"status": "ok"})
+}
@@ -0,0 +1,105 @@
+package http
+
+import (
+	"errors"
+	"geminiBackend/internal/delivery/http/middleware"
+	"geminiBackend/internal/domain"
+	"geminiBackend/internal/provider/db"
+	"geminiBackend/pkg/utils"
+	"io"
+	"mime/multipart"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// @Summary Анализ изображений
+// @Description Принимает одно или несколько изображений (JPEG, PNG, WebP, HEIC) и необязательный вопрос.
+// @Description Тип определяется по содержимому файла. Без ключа Gemini и без явной модели используется
+// @Description локальная vision-модель Ollama из LOCAL_VISION_MODEL, если она задана; HEIC локальные модели не поддерживают.
+// @Tags ai
+// @Accept multipart/form-data
+// @Produce json
+// @Security BearerAuth
+// @Param images formData file true "Изображения (поле можно повторять)"
+// @Param prompt formData string false "Вопрос к изображениям"
+// @Param model formData string false "Модель (по умолчанию gemini-2.5-flash или локальная vision-модель)"
+// @Success 200 {object} domain.AITextSuccessResponse
+// @Failure 400 {object} domain.ErrorResponse
+// @Failure 401 {object} domain.ErrorResponse
+// @Failure 402 {object} domain.ErrorResponse
+// @Failure 403 {object} domain.ErrorResponse
+// @Failure 404 {object} domain.ErrorResponse
+// @Failure 413 {object} domain.ErrorResponse
+// @Failure 429 {object} domain.ErrorResponse
+// @Failure 500 {object} domain.ErrorResponse
+// @Failure 502 {object} domain.ErrorResponse
+// @Failure 503 {object} domain.ErrorResponse
+// @Router /user/ai/vision [post]
+func (h *Handler) AIVision(c *gin.Context) {
+	claims, ok := middleware.ClaimsFromContext(c)
+	if !ok {
+		utils.Error(c.Writer, http.StatusUnauthorized, "unauthorized", "no claims")
+		return
+	}
+
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, h.ai.VisionUploadLimit())
+	form, err := c.MultipartForm()
+	if err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			utils.Error(c.Writer, http.StatusRequestEntityTooLarge, "request_too_large", "upload exceeds size limit")
+			return
+		}
+		utils.Error(c.Writer, http.StatusBadRequest, "bad_request", "multipart/form-data expected")
+		return
+	}
+	images, err := readImages(form.File["images"])
+	if err != nil {
+		utils.Error(c.Writer, http.StatusBadRequest, "bad_request", "failed to read image")
+		return
+	}
+
+	users := db.NewUsersProvider(h.db, h.keys)
+	user, err := users.GetUserByTelegramID(claims.TgID)
+	if err != nil {
+		utils.Error(c.Writer, http.StatusUnauthorized, "unauthorized", "user not found")
+		return
+	}
+	apiKey := user.GeminiAPIKey.String
+	model := h.ai.VisionModel(c.PostForm("model"), apiKey != "")
+	needsKey, err := h.ai.RequiresAPIKey(model)
+	if err != nil {
+		writeAIError(c, err)
+		return
+	}
+	if needsKey && apiKey == "" {
+		utils.Error(c.Writer, http.StatusBadRequest, "missing_api_key", "set your Gemini API key first")
+		return
+	}
+
+	text, err := h.ai.AnalyzeImages(c.Request.Context(), model, apiKey, c.PostForm("prompt"), images)
+	if err != nil {
+		writeAIError(c, err)
+		return
+	}
+	utils.Success(c.Writer, domain.AITextResponse{Text: text})
+}
+
+// readImages читает загруженные файлы целиком; размер уже ограничен MaxBytesReader
+func readImages(files []*multipart.FileHeader) ([]domain.Attachment, error) {
+	images := make([]domain.Attachment, 0, len(files))
+	for _, fh := range files {
+		f, err := fh.Open()
+		if err != nil {
+			return nil, err
+		}
+		data, err := io.ReadAll(f)
+		f.Close()
+		if err != nil {
+			return nil, err
+		}
+		images = append(images, domain.Attachment{Data: data})
+	}
+	return images, nil
+}
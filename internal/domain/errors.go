@@ -1,11 +1,68 @@
 package domain
 
-import "errors"
+import (
+	"errors"
+	"fmt"
+)
 
 var (
-	ErrInvalidCredentials = errors.New("invalid credentials")
-	ErrUnauthorized       = errors.New("unauthorized")
-	ErrForbidden          = errors.New("forbidden")
-	ErrUserExists         = errors.New("user already exists")
-	ErrInvalidInput       = errors.New("invalid input")
+	ErrInvalidCredentials   = errors.New("invalid credentials")
+	ErrUnauthorized         = errors.New("unauthorized")
+	ErrForbidden            = errors.New("forbidden")
+	ErrUserExists           = errors.New("user already exists")
+	ErrInvalidInput         = errors.New("invalid input")
+	ErrInvalidSignature     = errors.New("invalid telegram signature")
+	ErrAuthDataExpired      = errors.New("telegram auth data expired")
+	ErrUnsignedLogin        = errors.New("signed telegram auth data required")
+	ErrTelegramAuthDisabled = errors.New("telegram auth is not configured")
+	ErrInvalidRefresh       = errors.New("invalid or expired refresh token")
+	ErrSessionRevoked       = errors.New("session revoked")
+	ErrUserNotFound         = errors.New("user not found")
+	ErrSelfModification     = errors.New("admins cannot change their own role, status or account")
+	ErrConversationNotFound = errors.New("conversation not found")
+	ErrMessageTooLong       = errors.New("message exceeds model input limit")
+	ErrNoProvider           = errors.New("no LLM provider configured for model")
+	ErrAPITokenNotFound     = errors.New("api token not found")
+	ErrMissingAPIKey        = errors.New("set your Gemini API key first")
+	ErrKBDocumentNotFound   = errors.New("knowledge base document not found")
+	ErrKBEmpty              = errors.New("knowledge base has no indexed documents")
 )
+
+// Типовые ошибки поставщиков моделей; приходят обёрнутыми в *ProviderError
+var (
+	ErrInvalidAPIKey       = errors.New("invalid API key")
+	ErrBillingRequired     = errors.New("billing is not enabled for this API key")
+	ErrPermissionDenied    = errors.New("API key has no access to this resource")
+	ErrModelNotFound       = errors.New("model not found")
+	ErrQuotaExceeded       = errors.New("quota exceeded")
+	ErrContentBlocked      = errors.New("content blocked by safety filters")
+	ErrProviderRejected    = errors.New("request rejected by provider")
+	ErrProviderError       = errors.New("provider error")
+	ErrProviderUnavailable = errors.New("provider unavailable")
+)
+
+// ProviderError ошибка поставщика модели, приведённая к типовой (Kind — одна из
+// ошибок выше). Message можно показывать клиенту: в нём нет адресов и внутренних
+// деталей. Исходная ошибка доступна через errors.As/errors.Is.
+type ProviderError struct {
+	Kind     error
+	Provider string
+	Message  string
+	Err      error
+}
+
+func (e *ProviderError) Error() string {
+	return fmt.Sprintf("%s: %s", e.Provider, e.Message)
+}
+
+func (e *ProviderError) Unwrap() []error { return []error{e.Kind, e.Err} }
+
+// ParamError недопустимое значение параметра запроса (например, выходит за лимиты модели)
+type ParamError struct {
+	Field  string
+	Reason string
+}
+
+func (e *ParamError) Error() string {
+	return fmt.Sprintf("%s: %s", e.Field, e.Reason)
+}
@@ -2,22 +2,48 @@ package domain
 
 import (
 	"database/sql"
+	"time"
 
 	"github.com/golang-jwt/jwt/v5"
 )
 
+// TelegramAuthData данные, которые возвращает Telegram Login Widget
+type TelegramAuthData struct {
+	ID        int64  `json:"id"`
+	FirstName string `json:"first_name,omitempty"`
+	LastName  string `json:"last_name,omitempty"`
+	Username  string `json:"username,omitempty"`
+	PhotoURL  string `json:"photo_url,omitempty"`
+	AuthDate  int64  `json:"auth_date"`
+	Hash      string `json:"hash"`
+}
+
+// LoginRequest запрос на вход. Нужно передать либо init_data (Mini App),
+// либо widget (Login Widget). Голый tg_id принимается только при ALLOW_UNSIGNED_LOGIN.
 type LoginRequest struct {
-	Username string `json:"username"`
-	TgID     int    `json:"tg_id"`
+	Username string            `json:"username"`
+	TgID     int               `json:"tg_id"`
+	InitData string            `json:"init_data,omitempty"` // строка initData из Telegram Mini App
+	Widget   *TelegramAuthData `json:"widget,omitempty"`    // данные Telegram Login Widget
 }
 
+// RegisterRequest запрос на регистрацию, подписывается так же, как LoginRequest
 type RegisterRequest struct {
-	Username string `json:"username"`
-	TgID     int    `json:"tg_id"`
+	Username string            `json:"username"`
+	TgID     int               `json:"tg_id"`
+	InitData string            `json:"init_data,omitempty"`
+	Widget   *TelegramAuthData `json:"widget,omitempty"`
 }
 
 type LoginResponse struct {
-	Token string `json:"token"`
+	Token        string `json:"token"`
+	RefreshToken string `json:"refresh_token"`
+	ExpiresIn    int64  `json:"expires_in"` // время жизни access токена в секундах
+}
+
+// RefreshRequest запрос на обновление пары токенов
+type RefreshRequest struct {
+	RefreshToken string `json:"refresh_token"`
 }
 
 type RegisterResponse struct {
@@ -31,6 +57,17 @@ type Claims struct {
 	jwt.RegisteredClaims
 }
 
+// Session сессия пользователя; id совпадает с jti access токенов, выданных в её рамках
+type Session struct {
+	ID          string
+	TgID        int
+	RefreshHash string
+	ExpiresAt   time.Time
+	RevokedAt   sql.NullTime
+	LastUsedAt  sql.NullTime
+	CreatedAt   time.Time
+}
+
 type User struct {
 	Username string
 	Role     string
@@ -48,6 +85,54 @@ type UserDB struct {
 	UpdatedAt    sql.NullTime
 }
 
+// UserSummary пользователь в ответах админского API (без самого ключа)
+type UserSummary struct {
+	TgID      int        `json:"tg_id"`
+	Username  string     `json:"username"`
+	IsAdmin   bool       `json:"is_admin"`
+	IsActive  bool       `json:"is_active"`
+	HasKey    bool       `json:"has_key"`
+	LastLogin *time.Time `json:"last_login,omitempty"`
+	CreatedAt time.Time  `json:"created_at"`
+	UpdatedAt time.Time  `json:"updated_at"`
+}
+
+// UserFilter фильтр и пагинация списка пользователей; nil-поля не фильтруют
+type UserFilter struct {
+	Query    string // подстрока username
+	IsAdmin  *bool
+	IsActive *bool
+	HasKey   *bool
+	Limit    int
+	Offset   int
+}
+
+// AuditEntry запись журнала действий администраторов
+type AuditEntry struct {
+	ID         int64     `json:"id"`
+	ActorTgID  int       `json:"actor_tg_id"`
+	Action     string    `json:"action"`
+	TargetTgID int       `json:"target_tg_id,omitempty"`
+	Details    string    `json:"details,omitempty"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+// Действия администратора для журнала
+const (
+	AuditCreateUser = "user.create"
+	AuditSetAdmin   = "user.set_admin"
+	AuditSetActive  = "user.set_active"
+	AuditClearKey   = "user.clear_key"
+	AuditDeleteUser = "user.delete"
+)
+
+// RekeyStats результат перешифрования ключей пользователей
+type RekeyStats struct {
+	Migrated  int // legacy ключи, зашифрованные впервые
+	Rewrapped int // ключи данных, перешифрованные активным мастер-ключом
+	Unchanged int // уже зашифрованы активной версией
+}
+
 type SetKeyRequest struct {
 	APIKey string `json:"api_key"`
 }
@@ -56,14 +141,156 @@ type KeyStatusResponse struct {
 	HasKey bool `json:"has_key"`
 }
 
-// ModelInfo информация о модели Gemini
+// ModelInfo информация о модели
 type ModelInfo struct {
-	Name             string   `json:"name"`               // Имя модели (например, "gemini-2.5-flash")
-	DisplayName      string   `json:"display_name"`       // Отображаемое имя
-	Description      string   `json:"description"`        // Описание модели
-	SupportedActions []string `json:"supported_actions"`  // Поддерживаемые действия (generateContent, etc)
-	InputTokenLimit  int32    `json:"input_token_limit"`  // Лимит входных токенов
-	OutputTokenLimit int32    `json:"output_token_limit"` // Лимит выходных токенов
-	Category         string   `json:"category"`           // Категория: text, multimodal, embedding, etc
-	IsAvailable      bool     `json:"is_available"`       // Доступна ли модель сейчас
+	Name             string   `json:"name"`                      // Имя модели (например, "gemini-2.5-flash")
+	DisplayName      string   `json:"display_name"`              // Отображаемое имя
+	Description      string   `json:"description"`               // Описание модели
+	SupportedActions []string `json:"supported_actions"`         // Поддерживаемые действия (generateContent, etc)
+	InputTokenLimit  int32    `json:"input_token_limit"`         // Лимит входных токенов
+	OutputTokenLimit int32    `json:"output_token_limit"`        // Лимит выходных токенов
+	Category         string   `json:"category"`                  // Категория: text, multimodal, embedding, etc
+	IsAvailable      bool     `json:"is_available"`              // Доступна ли модель сейчас
+	MaxTemperature   float32  `json:"max_temperature,omitempty"` // Максимальная temperature (если известна)
+	MaxTopK          int32    `json:"max_top_k,omitempty"`       // Максимальный top_k (если известен)
+	Provider         string   `json:"provider"`                  // Поставщик модели: gemini, ollama
+}
+
+// GenerationParams необязательные параметры генерации; nil/пустое значение — настройка модели по умолчанию
+type GenerationParams struct {
+	SystemInstruction string   `json:"system_instruction,omitempty"`
+	Temperature       *float32 `json:"temperature,omitempty"`
+	TopP              *float32 `json:"top_p,omitempty"`
+	TopK              *int     `json:"top_k,omitempty"`
+	MaxOutputTokens   *int     `json:"max_output_tokens,omitempty"`
+	StopSequences     []string `json:"stop_sequences,omitempty"`
+	Seed              *int     `json:"seed,omitempty"`
+	CandidateCount    *int     `json:"candidate_count,omitempty"`
+}
+
+// AIUsage расход токенов на один запрос генерации
+type AIUsage struct {
+	Model            string `json:"model"`
+	PromptTokens     int    `json:"prompt_tokens"`
+	CompletionTokens int    `json:"completion_tokens"`
+	TotalTokens      int    `json:"total_tokens"`
+}
+
+// Роли сообщений в диалоге
+const (
+	ChatRoleUser      = "user"
+	ChatRoleAssistant = "assistant"
+)
+
+// Conversation сохранённый диалог пользователя с моделью
+type Conversation struct {
+	ID           int64     `json:"id"`
+	TgID         int       `json:"-"`
+	Title        string    `json:"title"`
+	Model        string    `json:"model"`
+	MessageCount int       `json:"message_count"`
+	CreatedAt    time.Time `json:"created_at"`
+	UpdatedAt    time.Time `json:"updated_at"`
+}
+
+// ChatMessage сообщение диалога; Model — модель, которая дала ответ (для assistant)
+type ChatMessage struct {
+	ID             int64     `json:"id"`
+	ConversationID int64     `json:"conversation_id"`
+	Role           string    `json:"role"`
+	Content        string    `json:"content"`
+	Model          string    `json:"model,omitempty"`
+	CreatedAt      time.Time `json:"created_at"`
+}
+
+// KBDocument документ базы знаний пользователя; его текст разбит на фрагменты,
+// у каждого фрагмента — эмбеддинг модели Model
+type KBDocument struct {
+	ID         int64     `json:"id"`
+	TgID       int       `json:"-"`
+	Filename   string    `json:"filename"`
+	Format     string    `json:"format"`
+	Pages      int       `json:"pages"`
+	Chunks     int       `json:"chunks"`
+	Model      string    `json:"model"` // модель эмбеддингов
+	Dimensions int       `json:"dimensions"`
+	CreatedAt  time.Time `json:"created_at"`
+	UpdatedAt  time.Time `json:"updated_at"` // время последней индексации
+}
+
+// KBChunk фрагмент документа базы знаний с вектором; Filename и Model — из документа
+type KBChunk struct {
+	ID         int64
+	DocumentID int64
+	Index      int // номер фрагмента в документе, с 0
+	Page       int // страница, с которой взят фрагмент, с 1
+	Text       string
+	Vector     []float32
+	Filename   string
+	Model      string
+}
+
+// Attachment вложение мультимодального запроса (изображение, PDF); MIMEType определяется по содержимому
+type Attachment struct {
+	MIMEType string
+	Data     []byte
+}
+
+// Задачи обработки документа
+const (
+	DocTaskSummarize  = "summarize"
+	DocTaskQuestion   = "question"
+	DocTaskExtract    = "extract"
+	DocTaskOCRCorrect = "ocr_correct"
+)
+
+// Способ обработки документа
+const (
+	DocModeNativePDF = "native_pdf" // PDF целиком отправлен в Gemini
+	DocModeText      = "text"       // модели отправлен извлечённый текст по частям
+)
+
+// Стратегии обработки документа, который не помещается в один запрос
+const (
+	DocStrategyConcat    = "concat"     // каждая часть обрабатывается заданием, ответы склеиваются (ocr_correct)
+	DocStrategyMapReduce = "map_reduce" // частичные результаты по частям сводятся в один, при необходимости в несколько уровней
+	DocStrategyRefine    = "refine"     // результат уточняется последовательно, часть за частью
+)
+
+// Назначение эмбеддингов (task type Gemini в нижнем регистре)
+const (
+	EmbedTaskRetrievalQuery     = "retrieval_query"     // поисковый запрос
+	EmbedTaskRetrievalDocument  = "retrieval_document"  // документ для поиска
+	EmbedTaskSemanticSimilarity = "semantic_similarity" // сравнение текстов
+	EmbedTaskClassification     = "classification"
+	EmbedTaskClustering         = "clustering"
+	EmbedTaskQuestionAnswering  = "question_answering" // вопрос, ответ на который ищется среди документов
+	EmbedTaskFactVerification   = "fact_verification"
+	EmbedTaskCodeRetrievalQuery = "code_retrieval_query" // запрос на естественном языке для поиска кода
+)
+
+// Строгость исправления текста после OCR
+const (
+	OCRStrictnessLight      = "light"      // только явные ошибки распознавания
+	OCRStrictnessNormal     = "normal"     // ошибки распознавания и опечатки
+	OCRStrictnessAggressive = "aggressive" // также орфография, пунктуация и разорванные строки
+)
+
+// DocumentTask задание для документа: что сделать и с какими параметрами
+type DocumentTask struct {
+	Task     string
+	Question string   // для question
+	Fields   []string // для extract
+	Strategy string   // пусто — map_reduce, для ocr_correct — concat
+	Debug    bool     // вернуть промежуточные результаты
+}
+
+// APIToken персональный токен доступа к OpenAI-совместимому API; в БД хранится только хеш
+type APIToken struct {
+	ID         int64      `json:"id"`
+	TgID       int        `json:"-"`
+	Name       string     `json:"name"`
+	Prefix     string     `json:"prefix"` // начало токена, чтобы отличать токены в списке
+	CreatedAt  time.Time  `json:"created_at"`
+	LastUsedAt *time.Time `json:"last_used_at,omitempty"`
 }
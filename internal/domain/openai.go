@@ -0,0 +1,204 @@
+package domain
+
+import (
+	"encoding/json"
+	"errors"
+)
+
+// Типы OpenAI-совместимого API (/v1). Поля и имена повторяют формат OpenAI, чтобы
+// клиентские SDK и инструменты работали без изменений, кроме base URL.
+
+// OpenAIChatRequest запрос /v1/chat/completions
+type OpenAIChatRequest struct {
+	Model               string               `json:"model"`
+	Messages            []OpenAIMessage      `json:"messages"`
+	Stream              bool                 `json:"stream,omitempty"`
+	StreamOptions       *OpenAIStreamOptions `json:"stream_options,omitempty"`
+	Temperature         *float32             `json:"temperature,omitempty"`
+	TopP                *float32             `json:"top_p,omitempty"`
+	TopK                *int                 `json:"top_k,omitempty"` // расширение vLLM и llama.cpp
+	MaxTokens           *int                 `json:"max_tokens,omitempty"`
+	MaxCompletionTokens *int                 `json:"max_completion_tokens,omitempty"`
+	Stop                OpenAIStringList     `json:"stop,omitempty"`
+	Seed                *int                 `json:"seed,omitempty"`
+	N                   *int                 `json:"n,omitempty"`
+}
+
+// OpenAIStreamOptions stream_options: include_usage добавляет в конец потока чанк с расходом токенов
+type OpenAIStreamOptions struct {
+	IncludeUsage bool `json:"include_usage"`
+}
+
+// OpenAIMessage сообщение запроса. content — строка или массив частей (text, image_url).
+type OpenAIMessage struct {
+	Role    string        `json:"role"`
+	Content OpenAIContent `json:"content"`
+}
+
+// OpenAIContent содержимое сообщения: текст и URL изображений (поддерживаются data: URL)
+type OpenAIContent struct {
+	Text      string
+	ImageURLs []string
+}
+
+// openAIContentPart часть содержимого сообщения в формате массива
+type openAIContentPart struct {
+	Type     string          `json:"type"`
+	Text     string          `json:"text,omitempty"`
+	ImageURL *openAIImageURL `json:"image_url,omitempty"`
+}
+
+type openAIImageURL struct {
+	URL string `json:"url"`
+}
+
+func (c *OpenAIContent) UnmarshalJSON(data []byte) error {
+	if string(data) == "null" {
+		return nil
+	}
+	var text string
+	if err := json.Unmarshal(data, &text); err == nil {
+		c.Text = text
+		return nil
+	}
+	var parts []openAIContentPart
+	if err := json.Unmarshal(data, &parts); err != nil {
+		return errors.New("content must be a string or an array of parts")
+	}
+	for _, p := range parts {
+		switch p.Type {
+		case "text":
+			if c.Text != "" {
+				c.Text += "\n"
+			}
+			c.Text += p.Text
+		case "image_url":
+			if p.ImageURL == nil {
+				return errors.New("image_url part without url")
+			}
+			c.ImageURLs = append(c.ImageURLs, p.ImageURL.URL)
+		default:
+			return errors.New("unsupported content part type: " + p.Type)
+		}
+	}
+	return nil
+}
+
+// MarshalJSON текст без изображений — строкой, иначе массивом частей
+func (c OpenAIContent) MarshalJSON() ([]byte, error) {
+	if len(c.ImageURLs) == 0 {
+		return json.Marshal(c.Text)
+	}
+	parts := make([]openAIContentPart, 0, len(c.ImageURLs)+1)
+	if c.Text != "" {
+		parts = append(parts, openAIContentPart{Type: "text", Text: c.Text})
+	}
+	for _, url := range c.ImageURLs {
+		parts = append(parts, openAIContentPart{Type: "image_url", ImageURL: &openAIImageURL{URL: url}})
+	}
+	return json.Marshal(parts)
+}
+
+// OpenAIStringList строка или массив строк (stop, input эмбеддингов)
+type OpenAIStringList []string
+
+func (l *OpenAIStringList) UnmarshalJSON(data []byte) error {
+	if string(data) == "null" {
+		return nil
+	}
+	var one string
+	if err := json.Unmarshal(data, &one); err == nil {
+		*l = OpenAIStringList{one}
+		return nil
+	}
+	var many []string
+	if err := json.Unmarshal(data, &many); err != nil {
+		return errors.New("must be a string or an array of strings")
+	}
+	*l = many
+	return nil
+}
+
+// OpenAIChatResponse ответ /v1/chat/completions; при stream=true тот же формат
+// с object=chat.completion.chunk и delta вместо message
+type OpenAIChatResponse struct {
+	ID      string         `json:"id"`
+	Object  string         `json:"object"`
+	Created int64          `json:"created"`
+	Model   string         `json:"model"`
+	Choices []OpenAIChoice `json:"choices"`
+	Usage   *OpenAIUsage   `json:"usage,omitempty"`
+}
+
+// OpenAIChoice вариант ответа; finish_reason — null в промежуточных чанках потока
+type OpenAIChoice struct {
+	Index        int                    `json:"index"`
+	Message      *OpenAIResponseMessage `json:"message,omitempty"`
+	Delta        *OpenAIResponseMessage `json:"delta,omitempty"`
+	FinishReason *string                `json:"finish_reason"`
+}
+
+// OpenAIResponseMessage сообщение модели в ответе
+type OpenAIResponseMessage struct {
+	Role    string `json:"role,omitempty"`
+	Content string `json:"content"`
+}
+
+// OpenAIUsage расход токенов
+type OpenAIUsage struct {
+	PromptTokens     int `json:"prompt_tokens"`
+	CompletionTokens int `json:"completion_tokens"`
+	TotalTokens      int `json:"total_tokens"`
+}
+
+// OpenAIModelList ответ /v1/models
+type OpenAIModelList struct {
+	Object string        `json:"object"`
+	Data   []OpenAIModel `json:"data"`
+}
+
+// OpenAIModel модель в списке /v1/models
+type OpenAIModel struct {
+	ID      string `json:"id"`
+	Object  string `json:"object"`
+	Created int64  `json:"created"`
+	OwnedBy string `json:"owned_by"`
+}
+
+// OpenAIEmbeddingRequest запрос /v1/embeddings
+type OpenAIEmbeddingRequest struct {
+	Model          string           `json:"model"`
+	Input          OpenAIStringList `json:"input"`
+	EncodingFormat string           `json:"encoding_format,omitempty"`
+	Dimensions     *int             `json:"dimensions,omitempty"`
+}
+
+// OpenAIEmbeddingResponse ответ /v1/embeddings
+type OpenAIEmbeddingResponse struct {
+	Object string            `json:"object"`
+	Data   []OpenAIEmbedding `json:"data"`
+	Model  string            `json:"model"`
+	Usage  OpenAIUsage       `json:"usage"`
+}
+
+// OpenAIEmbedding вектор одного входа: массив чисел или, при encoding_format=base64,
+// строка base64 с float32 little-endian
+type OpenAIEmbedding struct {
+	Object    string `json:"object"`
+	Index     int    `json:"index"`
+	Embedding any    `json:"embedding"`
+}
+
+// OpenAIErrorResponse ошибка в формате OpenAI
+type OpenAIErrorResponse struct {
+	Error OpenAIError `json:"error"`
+}
+
+// OpenAIError подробности ошибки: type — класс ошибки (invalid_request_error и др.),
+// param — поле запроса, к которому относится ошибка
+type OpenAIError struct {
+	Message string  `json:"message"`
+	Type    string  `json:"type"`
+	Param   *string `json:"param"`
+	Code    string  `json:"code"`
+}
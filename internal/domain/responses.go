@@ -1,14 +1,74 @@
 package domain
 
-// AITextRequest запрос на генерацию текста
+// AITextRequest запрос на генерацию текста. Policy — имя политики из конфига,
+// Fallback — резервные модели после model; без них используется одна модель.
 type AITextRequest struct {
-	Prompt string `json:"prompt"`
-	Model  string `json:"model"`
+	Prompt   string          `json:"prompt"`
+	Model    string          `json:"model"`
+	Policy   string          `json:"policy,omitempty"`
+	Fallback *FallbackPolicy `json:"fallback,omitempty"`
+	GenerationParams
+}
+
+// FallbackPolicy резервные модели запроса: пробуются по порядку после основной, если
+// она ответила ошибкой из классов On (429, 503, timeout, safety; по умолчанию 429, 503, timeout)
+type FallbackPolicy struct {
+	Models  []string `json:"models"`
+	On      []string `json:"on,omitempty"`
+	DelayMs int      `json:"delay_ms,omitempty"` // пауза перед следующей моделью
 }
 
-// AITextResponse данные успешного ответа генерации текста
+// AITextResponse данные успешного ответа генерации текста.
+// Candidates заполняется, только если запрошено несколько вариантов (candidate_count > 1).
+// Model — модель, которая ответила; Fallbacks — модели, которые не ответили до неё.
 type AITextResponse struct {
-	Text string `json:"text"`
+	Text         string          `json:"text"`
+	Candidates   []string        `json:"candidates,omitempty"`
+	Model        string          `json:"model"`
+	Fallbacks    []FallbackEvent `json:"fallbacks,omitempty"`
+	FailedChunks []ChunkError    `json:"failed_chunks,omitempty"` // части длинного текста, не обработанные после повторов; в text их нет
+}
+
+// ChunkError ошибка обработки одной части длинного текста
+type ChunkError struct {
+	Index  int    `json:"index"`  // номер части с нуля
+	Reason string `json:"reason"` // класс ошибки (429, 503, timeout, safety); пусто — прочая ошибка
+	Error  string `json:"error"`
+}
+
+// ChunkProgress событие progress потоковой генерации по частям (SSE): сколько частей готово
+type ChunkProgress struct {
+	Done        int         `json:"done"`
+	Total       int         `json:"total"`
+	Failed      int         `json:"failed"`
+	FailedChunk *ChunkError `json:"failed_chunk,omitempty"` // часть, которая только что не удалась
+}
+
+// FallbackEvent переход к следующей модели: какая модель не ответила и почему
+type FallbackEvent struct {
+	Model  string `json:"model"`
+	Next   string `json:"next"`
+	Reason string `json:"reason"` // класс ошибки (429, 503, timeout, safety) или missing_api_key
+	Error  string `json:"error,omitempty"`
+}
+
+// AIStats счётчики AI-запросов с момента запуска сервера
+type AIStats struct {
+	Fallbacks         int64            `json:"fallbacks"`
+	FallbacksByReason map[string]int64 `json:"fallbacks_by_reason"`
+	FallbacksByModel  map[string]int64 `json:"fallbacks_by_model"` // "модель -> следующая модель"
+	Retries           int64            `json:"retries"`            // повторы вызовов после 429/5xx
+	RetriesByOp       map[string]int64 `json:"retries_by_operation"`
+	RetriesGaveUp     int64            `json:"retries_gave_up"`  // вызовы, не удавшиеся после повторов или без бюджета на них
+	Embeddings        int64            `json:"embeddings"`       // запросы эмбеддингов
+	EmbeddingInputs   int64            `json:"embedding_inputs"` // тексты во всех запросах эмбеддингов
+	EmbeddingTokens   map[string]int64 `json:"embedding_tokens"` // расход токенов по модели (у Gemini — оценка)
+}
+
+// AIStatsSuccessResponse успешный ответ счётчиков AI (обёртка)
+type AIStatsSuccessResponse struct {
+	Status string  `json:"status"`
+	Data   AIStats `json:"data"`
 }
 
 // AIModelsResponse данные успешного ответа списка моделей AI
@@ -45,3 +105,328 @@ type ErrorResponse struct {
 	Status string       `json:"status"`
 	Error  ErrorDetails `json:"error"`
 }
+
+// AdminSetAdminRequest запрос на выдачу/снятие прав администратора
+type AdminSetAdminRequest struct {
+	IsAdmin *bool `json:"is_admin"`
+}
+
+// AdminSetActiveRequest запрос на активацию/деактивацию пользователя
+type AdminSetActiveRequest struct {
+	IsActive *bool `json:"is_active"`
+}
+
+// AdminUsersResponse страница списка пользователей
+type AdminUsersResponse struct {
+	Users    []UserSummary `json:"users"`
+	Total    int           `json:"total"`
+	Page     int           `json:"page"`
+	PageSize int           `json:"page_size"`
+}
+
+// AdminAuditResponse страница журнала действий администраторов
+type AdminAuditResponse struct {
+	Entries  []AuditEntry `json:"entries"`
+	Total    int          `json:"total"`
+	Page     int          `json:"page"`
+	PageSize int          `json:"page_size"`
+}
+
+// AdminUsersSuccessResponse успешный ответ списка пользователей (обёртка)
+type AdminUsersSuccessResponse struct {
+	Status string             `json:"status"`
+	Data   AdminUsersResponse `json:"data"`
+}
+
+// AdminUserSuccessResponse успешный ответ с одним пользователем (обёртка)
+type AdminUserSuccessResponse struct {
+	Status string      `json:"status"`
+	Data   UserSummary `json:"data"`
+}
+
+// AdminAuditSuccessResponse успешный ответ журнала (обёртка)
+type AdminAuditSuccessResponse struct {
+	Status string             `json:"status"`
+	Data   AdminAuditResponse `json:"data"`
+}
+
+// AIStreamDelta событие delta потоковой генерации (SSE)
+type AIStreamDelta struct {
+	Text string `json:"text"`
+}
+
+// CreateConversationRequest запрос на создание диалога
+type CreateConversationRequest struct {
+	Title string `json:"title"`
+	Model string `json:"model"`
+}
+
+// RenameConversationRequest запрос на переименование диалога
+type RenameConversationRequest struct {
+	Title string `json:"title"`
+}
+
+// PostMessageRequest новое сообщение в диалоге; Model переопределяет модель диалога для этого ответа
+type PostMessageRequest struct {
+	Content string `json:"content"`
+	Model   string `json:"model,omitempty"`
+}
+
+// ConversationsResponse страница списка диалогов
+type ConversationsResponse struct {
+	Conversations []Conversation `json:"conversations"`
+	Total         int            `json:"total"`
+	Page          int            `json:"page"`
+	PageSize      int            `json:"page_size"`
+}
+
+// ConversationResponse диалог вместе с сообщениями
+type ConversationResponse struct {
+	Conversation
+	Messages []ChatMessage `json:"messages"`
+}
+
+// PostMessageResponse ответ модели на сообщение. HistoryTrimmed — сколько старых
+// сообщений не поместилось в лимит входа модели и не было отправлено
+type PostMessageResponse struct {
+	Message        ChatMessage `json:"message"`
+	Reply          ChatMessage `json:"reply"`
+	HistoryTrimmed int         `json:"history_trimmed"`
+}
+
+// ConversationSuccessResponse успешный ответ с диалогом (обёртка)
+type ConversationSuccessResponse struct {
+	Status string               `json:"status"`
+	Data   ConversationResponse `json:"data"`
+}
+
+// ConversationsSuccessResponse успешный ответ списка диалогов (обёртка)
+type ConversationsSuccessResponse struct {
+	Status string                `json:"status"`
+	Data   ConversationsResponse `json:"data"`
+}
+
+// PostMessageSuccessResponse успешный ответ на сообщение (обёртка)
+type PostMessageSuccessResponse struct {
+	Status string              `json:"status"`
+	Data   PostMessageResponse `json:"data"`
+}
+
+// DocumentChunkResult результат обработки группы страниц
+type DocumentChunkResult struct {
+	FromPage int    `json:"from_page"`
+	ToPage   int    `json:"to_page"`
+	Text     string `json:"text"`
+	Error    string `json:"error,omitempty"` // часть не обработана после повторов; в итоговый текст не вошла
+}
+
+// DocumentStep промежуточный результат обработки документа по частям
+type DocumentStep struct {
+	Stage string `json:"stage"` // map, reduce или refine
+	Level int    `json:"level"` // уровень свёртки (reduce); у map и refine — 0
+	Index int    `json:"index"` // номер части или группы на уровне
+	Text  string `json:"text"`
+}
+
+// DocumentResponse результат обработки документа. Chunks заполняется, если
+// документ обрабатывался по частям (больше одной группы страниц) или часть не удалась;
+// для map_reduce в chunks — частичные результаты групп страниц до свёртки.
+// Steps — все промежуточные результаты, если запрошен debug.
+type DocumentResponse struct {
+	Task     string                `json:"task"`
+	Model    string                `json:"model"`
+	Format   string                `json:"format"`
+	Mode     string                `json:"mode"`
+	Strategy string                `json:"strategy,omitempty"` // для mode=text
+	Pages    int                   `json:"pages"`
+	Text     string                `json:"text"`
+	Chunks   []DocumentChunkResult `json:"chunks,omitempty"`
+	Steps    []DocumentStep        `json:"steps,omitempty"`
+}
+
+// DocumentSuccessResponse успешный ответ обработки документа (обёртка)
+type DocumentSuccessResponse struct {
+	Status string           `json:"status"`
+	Data   DocumentResponse `json:"data"`
+}
+
+// EmbeddingsRequest запрос векторных представлений текстов
+type EmbeddingsRequest struct {
+	Texts      []string `json:"texts"`
+	Model      string   `json:"model,omitempty"`      // пусто — gemini-embedding-001, без ключа Gemini — LOCAL_EMBEDDING_MODEL
+	TaskType   string   `json:"task_type,omitempty"`  // retrieval_query, retrieval_document, semantic_similarity, classification, clustering, question_answering, fact_verification, code_retrieval_query
+	Dimensions int      `json:"dimensions,omitempty"` // размерность вектора; 0 — по умолчанию модели
+}
+
+// Embedding вектор одного текста
+type Embedding struct {
+	Index      int       `json:"index"` // номер текста в запросе
+	Dimensions int       `json:"dimensions"`
+	Vector     []float32 `json:"vector"`
+}
+
+// EmbeddingsResponse векторы в порядке текстов запроса
+type EmbeddingsResponse struct {
+	Model      string      `json:"model"`
+	Dimensions int         `json:"dimensions"` // размерность векторов модели
+	Embeddings []Embedding `json:"embeddings"`
+	Usage      AIUsage     `json:"usage"` // у Gemini — оценка по длине текста
+}
+
+// EmbeddingsSuccessResponse успешный ответ эмбеддингов (обёртка)
+type EmbeddingsSuccessResponse struct {
+	Status string             `json:"status"`
+	Data   EmbeddingsResponse `json:"data"`
+}
+
+// CountTokensRequest запрос подсчёта токенов промпта
+type CountTokensRequest struct {
+	Prompt          string `json:"prompt"`
+	Model           string `json:"model,omitempty"`             // пусто — модель /ai/text по умолчанию (gemini-2.0-flash-exp)
+	MaxOutputTokens int    `json:"max_output_tokens,omitempty"` // ожидаемая длина ответа для оценки стоимости; 0 — только вход
+}
+
+// CostEstimate оценка стоимости запроса по таблице цен LLM_PRICES
+type CostEstimate struct {
+	Currency         string  `json:"currency"`           // USD
+	InputPerMillion  float64 `json:"input_per_million"`  // цена 1M входных токенов
+	OutputPerMillion float64 `json:"output_per_million"` // цена 1M выходных токенов
+	InputCost        float64 `json:"input_cost"`
+	OutputCost       float64 `json:"output_cost"` // по max_output_tokens
+	TotalCost        float64 `json:"total_cost"`
+}
+
+// CountTokensResponse число токенов промпта и запас до лимитов модели
+type CountTokensResponse struct {
+	Model            string        `json:"model"`
+	Provider         string        `json:"provider"`
+	Tokens           int           `json:"tokens"`
+	Estimated        bool          `json:"estimated"`                    // число токенов оценено приблизительным токенизатором
+	InputTokenLimit  int           `json:"input_token_limit,omitempty"`  // 0 — лимит неизвестен
+	OutputTokenLimit int           `json:"output_token_limit,omitempty"` // 0 — лимит неизвестен
+	Headroom         *int          `json:"headroom,omitempty"`           // input_token_limit − tokens; отрицательный — промпт не помещается
+	Chars            int           `json:"chars"`
+	MaxInputChars    int           `json:"max_input_chars,omitempty"` // входной лимит в символах, по которому /ai/text делит промпт
+	Chunked          bool          `json:"chunked"`                   // /ai/text обработает промпт по частям
+	Chunks           int           `json:"chunks"`                    // сколько частей получится
+	Cost             *CostEstimate `json:"cost,omitempty"`            // нет, если модели нет в таблице цен
+}
+
+// CountTokensSuccessResponse успешный ответ подсчёта токенов (обёртка)
+type CountTokensSuccessResponse struct {
+	Status string              `json:"status"`
+	Data   CountTokensResponse `json:"data"`
+}
+
+// OCRCorrectRequest запрос на исправление текста после OCR
+type OCRCorrectRequest struct {
+	Text       string `json:"text"`
+	Language   string `json:"language,omitempty"`   // язык текста (ru, en, «русский»); пусто — модель определяет сама
+	Strictness string `json:"strictness,omitempty"` // light, normal (по умолчанию), aggressive
+	Model      string `json:"model,omitempty"`      // пусто — OCR_MODEL
+}
+
+// TextEdit фрагмент сравнения исходного и исправленного текста по словам
+type TextEdit struct {
+	Op   string `json:"op"` // equal, delete (только в исходном), insert (только в исправленном)
+	Text string `json:"text"`
+}
+
+// OCRCorrectResponse исправленный текст и правки относительно исходного
+type OCRCorrectResponse struct {
+	Model        string       `json:"model"`
+	Text         string       `json:"text"`
+	Diff         []TextEdit   `json:"diff"`
+	ChangeRatio  float64      `json:"change_ratio"`            // доля изменённых слов: 0 — без изменений, 1 — ни одного общего слова
+	FailedChunks []ChunkError `json:"failed_chunks,omitempty"` // части, оставленные без исправления
+}
+
+// OCRCorrectSuccessResponse успешный ответ исправления OCR (обёртка)
+type OCRCorrectSuccessResponse struct {
+	Status string             `json:"status"`
+	Data   OCRCorrectResponse `json:"data"`
+}
+
+// KBDocumentsResponse страница списка документов базы знаний
+type KBDocumentsResponse struct {
+	Documents []KBDocument `json:"documents"`
+	Total     int          `json:"total"`
+	Page      int          `json:"page"`
+	PageSize  int          `json:"page_size"`
+}
+
+// KBDocumentSuccessResponse успешный ответ с документом базы знаний (обёртка)
+type KBDocumentSuccessResponse struct {
+	Status string     `json:"status"`
+	Data   KBDocument `json:"data"`
+}
+
+// KBDocumentsSuccessResponse успешный ответ списка документов базы знаний (обёртка)
+type KBDocumentsSuccessResponse struct {
+	Status string              `json:"status"`
+	Data   KBDocumentsResponse `json:"data"`
+}
+
+// ReindexKBDocumentRequest запрос на переиндексацию документа
+type ReindexKBDocumentRequest struct {
+	Model string `json:"model,omitempty"` // модель эмбеддингов; пусто — прежняя модель документа
+}
+
+// AskRequest вопрос к базе знаний
+type AskRequest struct {
+	Question    string  `json:"question"`
+	TopK        int     `json:"top_k,omitempty"`        // сколько фрагментов отдать модели; 0 — KB_TOP_K
+	DocumentIDs []int64 `json:"document_ids,omitempty"` // искать только в этих документах; пусто — во всех
+	Model       string  `json:"model,omitempty"`        // модель ответа; пусто — gemini-2.5-flash, без ключа Gemini — LOCAL_ASK_MODEL
+}
+
+// Citation фрагмент базы знаний, переданный модели как источник [Source]
+type Citation struct {
+	Source     int     `json:"source"` // номер источника в промпте и в ссылках ответа
+	DocumentID int64   `json:"document_id"`
+	Filename   string  `json:"filename"`
+	Chunk      int     `json:"chunk"` // номер фрагмента в документе, с 0
+	Page       int     `json:"page"`
+	Score      float64 `json:"score"` // косинусная близость фрагмента к вопросу
+	Cited      bool    `json:"cited"` // модель сослалась на источник в ответе
+	Text       string  `json:"text"`
+}
+
+// AskResponse ответ на вопрос по базе знаний со ссылками на фрагменты документов
+type AskResponse struct {
+	Model     string     `json:"model"`
+	Answer    string     `json:"answer"`
+	Citations []Citation `json:"citations"`
+	Usage     AIUsage    `json:"usage"`
+}
+
+// AskSuccessResponse успешный ответ на вопрос по базе знаний (обёртка)
+type AskSuccessResponse struct {
+	Status string      `json:"status"`
+	Data   AskResponse `json:"data"`
+}
+
+// CreateAPITokenRequest запрос на выпуск персонального токена
+type CreateAPITokenRequest struct {
+	Name string `json:"name"`
+}
+
+// CreateAPITokenResponse выпущенный токен; значение token показывается только один раз
+type CreateAPITokenResponse struct {
+	APIToken
+	Token string `json:"token"`
+}
+
+// CreateAPITokenSuccessResponse успешный ответ выпуска токена (обёртка)
+type CreateAPITokenSuccessResponse struct {
+	Status string                 `json:"status"`
+	Data   CreateAPITokenResponse `json:"data"`
+}
+
+// APITokensSuccessResponse успешный ответ списка токенов (обёртка)
+type APITokensSuccessResponse struct {
+	Status string `json:"status"`
+	Data   struct {
+		Tokens []APIToken `json:"tokens"`
+	} `json:"data"`
+}
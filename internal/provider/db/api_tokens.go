@@ -0,0 +1,97 @@
+package db
+
+import (
+	"database/sql"
+	"geminiBackend/internal/domain"
+)
+
+type APITokensProvider struct {
+	db *sql.DB
+}
+
+func NewAPITokensProvider(db *sql.DB) *APITokensProvider {
+	return &APITokensProvider{db: db}
+}
+
+const apiTokenColumns = `id, tg_id, name, prefix, created_at, last_used_at`
+
+// CreateToken сохраняет хеш нового токена и возвращает запись
+func (p *APITokensProvider) CreateToken(tgID int, name, prefix, hash string) (*domain.APIToken, error) {
+	now := dbNow()
+	res, err := p.db.Exec(`
+		INSERT INTO api_tokens (tg_id, name, prefix, token_hash, created_at)
+		VALUES (?, ?, ?, ?, ?)
+	`, tgID, name, prefix, hash, now)
+	if err != nil {
+		return nil, err
+	}
+	id, err := res.LastInsertId()
+	if err != nil {
+		return nil, err
+	}
+	return &domain.APIToken{ID: id, TgID: tgID, Name: name, Prefix: prefix, CreatedAt: now}, nil
+}
+
+// ListTokens возвращает токены пользователя, новые сначала
+func (p *APITokensProvider) ListTokens(tgID int) ([]domain.APIToken, error) {
+	rows, err := p.db.Query(`SELECT `+apiTokenColumns+` FROM api_tokens WHERE tg_id = ? ORDER BY id DESC`, tgID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	tokens := make([]domain.APIToken, 0)
+	for rows.Next() {
+		t, err := scanAPIToken(rows)
+		if err != nil {
+			return nil, err
+		}
+		tokens = append(tokens, *t)
+	}
+	return tokens, rows.Err()
+}
+
+// GetTokenByHash ищет токен по хешу; неизвестный — ErrAPITokenNotFound
+func (p *APITokensProvider) GetTokenByHash(hash string) (*domain.APIToken, error) {
+	t, err := scanAPIToken(p.db.QueryRow(`SELECT `+apiTokenColumns+` FROM api_tokens WHERE token_hash = ?`, hash))
+	if err == sql.ErrNoRows {
+		return nil, domain.ErrAPITokenNotFound
+	}
+	return t, err
+}
+
+// TouchToken отмечает время последнего использования токена
+func (p *APITokensProvider) TouchToken(id int64) error {
+	_, err := p.db.Exec(`UPDATE api_tokens SET last_used_at = ? WHERE id = ?`, dbNow(), id)
+	return err
+}
+
+// DeleteToken удаляет токен пользователя; чужой или несуществующий — ErrAPITokenNotFound
+func (p *APITokensProvider) DeleteToken(id int64, tgID int) error {
+	res, err := p.db.Exec(`DELETE FROM api_tokens WHERE id = ? AND tg_id = ?`, id, tgID)
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return domain.ErrAPITokenNotFound
+	}
+	return nil
+}
+
+func scanAPIToken(row rowScanner) (*domain.APIToken, error) {
+	var (
+		t        domain.APIToken
+		lastUsed sql.NullTime
+	)
+	if err := row.Scan(&t.ID, &t.TgID, &t.Name, &t.Prefix, &t.CreatedAt, &lastUsed); err != nil {
+		return nil, err
+	}
+	if lastUsed.Valid {
+		t.LastUsedAt = &lastUsed.Time
+	}
+	return &t, nil
+}
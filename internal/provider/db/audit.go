@@ -0,0 +1,119 @@
+package db
+
+import (
+	"database/sql"
+	"geminiBackend/internal/domain"
+	"strings"
+)
+
+type AuditProvider struct {
+	db *sql.DB
+}
+
+func NewAuditProvider(db *sql.DB) *AuditProvider {
+	return &AuditProvider{db: db}
+}
+
+// Record записывает действие администратора в журнал
+func (p *AuditProvider) Record(entry domain.AuditEntry) error {
+	return recordAudit(p.db, entry)
+}
+
+// Apply выполняет изменение fn и записывает entry в журнал в одной транзакции:
+// действие без записи в журнале (и наоборот) не сохраняется
+func (p *AuditProvider) Apply(entry domain.AuditEntry, fn func(tx AdminTx) error) error {
+	tx, err := p.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if err := fn(AdminTx{tx: tx}); err != nil {
+		return err
+	}
+	if err := recordAudit(tx, entry); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// AdminTx изменения пользователей внутри транзакции AuditProvider.Apply
+type AdminTx struct {
+	tx *sql.Tx
+}
+
+func (a AdminTx) UpsertTelegramUser(tgID int, username string) error {
+	return upsertTelegramUser(a.tx, tgID, username)
+}
+
+func (a AdminTx) SetAdmin(tgID int, isAdmin bool) error { return setAdmin(a.tx, tgID, isAdmin) }
+
+func (a AdminTx) SetActive(tgID int, isActive bool) error { return setActive(a.tx, tgID, isActive) }
+
+func (a AdminTx) ClearGeminiAPIKey(tgID int) error { return clearGeminiAPIKey(a.tx, tgID) }
+
+func (a AdminTx) RevokeUserSessions(tgID int) error { return revokeUserSessions(a.tx, tgID) }
+
+// DeleteUser удаляет пользователя вместе с его данными (см. UsersProvider.DeleteUser)
+func (a AdminTx) DeleteUser(tgID int) error { return deleteUser(a.tx, tgID) }
+
+func recordAudit(ex execer, entry domain.AuditEntry) error {
+	var target sql.NullInt64
+	if entry.TargetTgID != 0 {
+		target = sql.NullInt64{Int64: int64(entry.TargetTgID), Valid: true}
+	}
+	_, err := ex.Exec(`
+		INSERT INTO admin_audit (actor_tg_id, action, target_tg_id, details)
+		VALUES (?, ?, ?, ?)
+	`, entry.ActorTgID, entry.Action, target, entry.Details)
+	return err
+}
+
+// List возвращает записи журнала (новые сначала), опционально по актору и/или цели
+func (p *AuditProvider) List(actorTgID, targetTgID, limit, offset int) ([]domain.AuditEntry, int, error) {
+	var (
+		where []string
+		args  []any
+	)
+	if actorTgID != 0 {
+		where = append(where, "actor_tg_id = ?")
+		args = append(args, actorTgID)
+	}
+	if targetTgID != 0 {
+		where = append(where, "target_tg_id = ?")
+		args = append(args, targetTgID)
+	}
+	cond := ""
+	if len(where) > 0 {
+		cond = "WHERE " + strings.Join(where, " AND ")
+	}
+
+	var total int
+	if err := p.db.QueryRow(`SELECT COUNT(*) FROM admin_audit `+cond, args...).Scan(&total); err != nil {
+		return nil, 0, err
+	}
+	rows, err := p.db.Query(`
+		SELECT id, actor_tg_id, action, target_tg_id, details, created_at
+		FROM admin_audit `+cond+`
+		ORDER BY id DESC LIMIT ? OFFSET ?
+	`, append(args, limit, offset)...)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	entries := make([]domain.AuditEntry, 0)
+	for rows.Next() {
+		var (
+			e       domain.AuditEntry
+			target  sql.NullInt64
+			details sql.NullString
+		)
+		if err := rows.Scan(&e.ID, &e.ActorTgID, &e.Action, &target, &details, &e.CreatedAt); err != nil {
+			return nil, 0, err
+		}
+		e.TargetTgID = int(target.Int64)
+		e.Details = details.String
+		entries = append(entries, e)
+	}
+	return entries, total, rows.Err()
+}
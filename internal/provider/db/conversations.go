@@ -0,0 +1,180 @@
+package db
+
+import (
+	"database/sql"
+	"geminiBackend/internal/domain"
+)
+
+type ConversationsProvider struct {
+	db *sql.DB
+}
+
+func NewConversationsProvider(db *sql.DB) *ConversationsProvider {
+	return &ConversationsProvider{db: db}
+}
+
+const conversationColumns = `c.id, c.tg_id, c.title, c.model, c.created_at, c.updated_at,
+	(SELECT COUNT(*) FROM chat_messages m WHERE m.conversation_id = c.id)`
+
+// CreateConversation создаёт диалог и возвращает его id
+func (p *ConversationsProvider) CreateConversation(tgID int, title, model string) (int64, error) {
+	now := dbNow()
+	res, err := p.db.Exec(`
+		INSERT INTO conversations (tg_id, title, model, created_at, updated_at)
+		VALUES (?, ?, ?, ?, ?)
+	`, tgID, title, model, now, now)
+	if err != nil {
+		return 0, err
+	}
+	return res.LastInsertId()
+}
+
+// GetConversation возвращает диалог пользователя; чужой или несуществующий — ErrConversationNotFound
+func (p *ConversationsProvider) GetConversation(id int64, tgID int) (*domain.Conversation, error) {
+	row := p.db.QueryRow(`SELECT `+conversationColumns+` FROM conversations c WHERE c.id = ? AND c.tg_id = ?`, id, tgID)
+	conv, err := scanConversation(row)
+	if err == sql.ErrNoRows {
+		return nil, domain.ErrConversationNotFound
+	}
+	return conv, err
+}
+
+// ListConversations возвращает диалоги пользователя, последние активные сначала
+func (p *ConversationsProvider) ListConversations(tgID, limit, offset int) ([]domain.Conversation, int, error) {
+	var total int
+	if err := p.db.QueryRow(`SELECT COUNT(*) FROM conversations WHERE tg_id = ?`, tgID).Scan(&total); err != nil {
+		return nil, 0, err
+	}
+	rows, err := p.db.Query(`
+		SELECT `+conversationColumns+`
+		FROM conversations c WHERE c.tg_id = ?
+		ORDER BY c.updated_at DESC, c.id DESC LIMIT ? OFFSET ?
+	`, tgID, limit, offset)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	convs := make([]domain.Conversation, 0)
+	for rows.Next() {
+		conv, err := scanConversation(rows)
+		if err != nil {
+			return nil, 0, err
+		}
+		convs = append(convs, *conv)
+	}
+	return convs, total, rows.Err()
+}
+
+// RenameConversation меняет заголовок диалога
+func (p *ConversationsProvider) RenameConversation(id int64, tgID int, title string) error {
+	res, err := p.db.Exec(`
+		UPDATE conversations SET title = ?, updated_at = ? WHERE id = ? AND tg_id = ?
+	`, title, dbNow(), id, tgID)
+	return affectedOrNotFound(res, err, domain.ErrConversationNotFound)
+}
+
+// DeleteConversation удаляет диалог вместе с сообщениями
+func (p *ConversationsProvider) DeleteConversation(id int64, tgID int) error {
+	tx, err := p.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	res, err := tx.Exec(`DELETE FROM conversations WHERE id = ? AND tg_id = ?`, id, tgID)
+	if err := affectedOrNotFound(res, err, domain.ErrConversationNotFound); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`DELETE FROM chat_messages WHERE conversation_id = ?`, id); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// ListMessages возвращает все сообщения диалога в хронологическом порядке
+func (p *ConversationsProvider) ListMessages(conversationID int64) ([]domain.ChatMessage, error) {
+	rows, err := p.db.Query(`
+		SELECT id, conversation_id, role, content, model, created_at
+		FROM chat_messages WHERE conversation_id = ?
+		ORDER BY id
+	`, conversationID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	messages := make([]domain.ChatMessage, 0)
+	for rows.Next() {
+		var (
+			m     domain.ChatMessage
+			model sql.NullString
+		)
+		if err := rows.Scan(&m.ID, &m.ConversationID, &m.Role, &m.Content, &model, &m.CreatedAt); err != nil {
+			return nil, err
+		}
+		m.Model = model.String
+		messages = append(messages, m)
+	}
+	return messages, rows.Err()
+}
+
+// AppendMessages сохраняет сообщения в одной транзакции (вопрос и ответ модели),
+// заполняет их id и время и обновляет updated_at диалога. Пустой title заменяется на newTitle.
+func (p *ConversationsProvider) AppendMessages(conversationID int64, newTitle string, messages ...*domain.ChatMessage) error {
+	tx, err := p.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	now := dbNow()
+	for _, m := range messages {
+		var model sql.NullString
+		if m.Model != "" {
+			model = sql.NullString{String: m.Model, Valid: true}
+		}
+		res, err := tx.Exec(`
+			INSERT INTO chat_messages (conversation_id, role, content, model, created_at)
+			VALUES (?, ?, ?, ?, ?)
+		`, conversationID, m.Role, m.Content, model, now)
+		if err != nil {
+			return err
+		}
+		if m.ID, err = res.LastInsertId(); err != nil {
+			return err
+		}
+		m.ConversationID = conversationID
+		m.CreatedAt = now
+	}
+	if _, err := tx.Exec(`
+		UPDATE conversations
+		SET updated_at = ?, title = CASE WHEN title = '' THEN ? ELSE title END
+		WHERE id = ?
+	`, now, newTitle, conversationID); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func scanConversation(row rowScanner) (*domain.Conversation, error) {
+	var c domain.Conversation
+	if err := row.Scan(&c.ID, &c.TgID, &c.Title, &c.Model, &c.CreatedAt, &c.UpdatedAt, &c.MessageCount); err != nil {
+		return nil, err
+	}
+	return &c, nil
+}
+
+// affectedOrNotFound превращает UPDATE/DELETE без затронутых строк в notFound
+func affectedOrNotFound(res sql.Result, err, notFound error) error {
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return notFound
+	}
+	return nil
+}
@@ -19,6 +19,8 @@ func InitDBLite(dataSourceName string) (*sql.DB, error) {
 	  tg_id            INTEGER NOT NULL UNIQUE,
 	  username         TEXT    NOT NULL,
 	  gemini_api_key   TEXT,
+	  gemini_key_dek   TEXT,
+	  gemini_key_version INTEGER NOT NULL DEFAULT 0,
 	  is_admin         INTEGER NOT NULL DEFAULT 0,
 	  is_active        INTEGER NOT NULL DEFAULT 1,
 	  last_login       DATETIME,
@@ -26,10 +28,133 @@ func InitDBLite(dataSourceName string) (*sql.DB, error) {
 	  updated_at       DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP
 	);
 	CREATE INDEX IF NOT EXISTS idx_users_username ON users(username);
+
+	CREATE TABLE IF NOT EXISTS sessions (
+	  id                 TEXT     PRIMARY KEY,
+	  tg_id              INTEGER  NOT NULL,
+	  refresh_hash       TEXT     NOT NULL UNIQUE,
+	  prev_refresh_hash  TEXT,
+	  expires_at         DATETIME NOT NULL,
+	  revoked_at         DATETIME,
+	  last_used_at       DATETIME,
+	  created_at         DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP
+	);
+	CREATE INDEX IF NOT EXISTS idx_sessions_tg_id ON sessions(tg_id);
+	CREATE INDEX IF NOT EXISTS idx_sessions_prev_refresh_hash ON sessions(prev_refresh_hash);
+
+	CREATE TABLE IF NOT EXISTS admin_audit (
+	  id            INTEGER  PRIMARY KEY AUTOINCREMENT,
+	  actor_tg_id   INTEGER  NOT NULL,
+	  action        TEXT     NOT NULL,
+	  target_tg_id  INTEGER,
+	  details       TEXT,
+	  created_at    DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP
+	);
+	CREATE INDEX IF NOT EXISTS idx_admin_audit_target ON admin_audit(target_tg_id);
+	CREATE INDEX IF NOT EXISTS idx_admin_audit_actor ON admin_audit(actor_tg_id);
+
+	CREATE TABLE IF NOT EXISTS conversations (
+	  id          INTEGER  PRIMARY KEY AUTOINCREMENT,
+	  tg_id       INTEGER  NOT NULL,
+	  title       TEXT     NOT NULL DEFAULT '',
+	  model       TEXT     NOT NULL,
+	  created_at  DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	  updated_at  DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP
+	);
+	CREATE INDEX IF NOT EXISTS idx_conversations_tg_id ON conversations(tg_id, updated_at);
+
+	CREATE TABLE IF NOT EXISTS chat_messages (
+	  id               INTEGER  PRIMARY KEY AUTOINCREMENT,
+	  conversation_id  INTEGER  NOT NULL,
+	  role             TEXT     NOT NULL,
+	  content          TEXT     NOT NULL,
+	  model            TEXT,
+	  created_at       DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP
+	);
+	CREATE INDEX IF NOT EXISTS idx_chat_messages_conversation ON chat_messages(conversation_id, id);
+
+	CREATE TABLE IF NOT EXISTS api_tokens (
+	  id            INTEGER  PRIMARY KEY AUTOINCREMENT,
+	  tg_id         INTEGER  NOT NULL,
+	  name          TEXT     NOT NULL DEFAULT '',
+	  prefix        TEXT     NOT NULL,
+	  token_hash    TEXT     NOT NULL UNIQUE,
+	  created_at    DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	  last_used_at  DATETIME
+	);
+	CREATE INDEX IF NOT EXISTS idx_api_tokens_tg_id ON api_tokens(tg_id);
+
+	CREATE TABLE IF NOT EXISTS kb_documents (
+	  id          INTEGER  PRIMARY KEY AUTOINCREMENT,
+	  tg_id       INTEGER  NOT NULL,
+	  filename    TEXT     NOT NULL,
+	  format      TEXT     NOT NULL,
+	  pages       INTEGER  NOT NULL,
+	  content     TEXT     NOT NULL,
+	  model       TEXT     NOT NULL,
+	  dimensions  INTEGER  NOT NULL,
+	  created_at  DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	  updated_at  DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP
+	);
+	CREATE INDEX IF NOT EXISTS idx_kb_documents_tg_id ON kb_documents(tg_id, id);
+
+	CREATE TABLE IF NOT EXISTS kb_chunks (
+	  id           INTEGER  PRIMARY KEY AUTOINCREMENT,
+	  document_id  INTEGER  NOT NULL,
+	  idx          INTEGER  NOT NULL,
+	  page         INTEGER  NOT NULL,
+	  text         TEXT     NOT NULL,
+	  vector       BLOB     NOT NULL
+	);
+	CREATE INDEX IF NOT EXISTS idx_kb_chunks_document ON kb_chunks(document_id, idx);
 	`
 	if _, err := sqlDB.Exec(schema); err != nil {
 		sqlDB.Close()
 		return nil, fmt.Errorf("apply schema: %w", err)
 	}
+	for _, m := range columnMigrations {
+		if err := ensureColumn(sqlDB, m.table, m.column, m.definition); err != nil {
+			sqlDB.Close()
+			return nil, fmt.Errorf("migrate %s.%s: %w", m.table, m.column, err)
+		}
+	}
 	return sqlDB, nil
 }
+
+// columnMigrations колонки, добавленные после создания таблиц; для старых БД добавляются через ALTER TABLE
+var columnMigrations = []struct {
+	table, column, definition string
+}{
+	{"users", "gemini_key_dek", "TEXT"},
+	{"users", "gemini_key_version", "INTEGER NOT NULL DEFAULT 0"},
+}
+
+// ensureColumn добавляет колонку, если её ещё нет в таблице
+func ensureColumn(sqlDB *sql.DB, table, column, definition string) error {
+	rows, err := sqlDB.Query(fmt.Sprintf("PRAGMA table_info(%s)", table))
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var (
+			cid        int
+			name, typ  string
+			notNull    int
+			defaultVal sql.NullString
+			pk         int
+		)
+		if err := rows.Scan(&cid, &name, &typ, &notNull, &defaultVal, &pk); err != nil {
+			return err
+		}
+		if name == column {
+			return nil
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	rows.Close()
+	_, err = sqlDB.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", table, column, definition))
+	return err
+}
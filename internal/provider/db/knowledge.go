@@ -0,0 +1,224 @@
+package db
+
+import (
+	"database/sql"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"geminiBackend/internal/domain"
+	"math"
+	"strings"
+)
+
+type KnowledgeProvider struct {
+	db *sql.DB
+}
+
+func NewKnowledgeProvider(db *sql.DB) *KnowledgeProvider {
+	return &KnowledgeProvider{db: db}
+}
+
+const kbDocumentColumns = `d.id, d.tg_id, d.filename, d.format, d.pages, d.model, d.dimensions, d.created_at, d.updated_at,
+	(SELECT COUNT(*) FROM kb_chunks k WHERE k.document_id = d.id)`
+
+// CreateDocument сохраняет документ вместе со страницами текста (для переиндексации)
+// и фрагментами в одной транзакции и заполняет id и время документа
+func (p *KnowledgeProvider) CreateDocument(doc *domain.KBDocument, pages []string, chunks []domain.KBChunk) error {
+	content, err := json.Marshal(pages)
+	if err != nil {
+		return err
+	}
+	tx, err := p.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	now := dbNow()
+	res, err := tx.Exec(`
+		INSERT INTO kb_documents (tg_id, filename, format, pages, content, model, dimensions, created_at, updated_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`, doc.TgID, doc.Filename, doc.Format, len(pages), string(content), doc.Model, doc.Dimensions, now, now)
+	if err != nil {
+		return err
+	}
+	id, err := res.LastInsertId()
+	if err != nil {
+		return err
+	}
+	if err := insertChunks(tx, id, chunks); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	doc.ID, doc.Pages, doc.Chunks = id, len(pages), len(chunks)
+	doc.CreatedAt, doc.UpdatedAt = now, now
+	return nil
+}
+
+// GetDocument возвращает документ пользователя; чужой или несуществующий — ErrKBDocumentNotFound
+func (p *KnowledgeProvider) GetDocument(id int64, tgID int) (*domain.KBDocument, error) {
+	doc, err := scanKBDocument(p.db.QueryRow(`SELECT `+kbDocumentColumns+` FROM kb_documents d WHERE d.id = ? AND d.tg_id = ?`, id, tgID))
+	if err == sql.ErrNoRows {
+		return nil, domain.ErrKBDocumentNotFound
+	}
+	return doc, err
+}
+
+// DocumentPages возвращает сохранённый текст документа по страницам
+func (p *KnowledgeProvider) DocumentPages(id int64, tgID int) ([]string, error) {
+	var content string
+	err := p.db.QueryRow(`SELECT content FROM kb_documents WHERE id = ? AND tg_id = ?`, id, tgID).Scan(&content)
+	if err == sql.ErrNoRows {
+		return nil, domain.ErrKBDocumentNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	var pages []string
+	if err := json.Unmarshal([]byte(content), &pages); err != nil {
+		return nil, fmt.Errorf("decode pages of document %d: %w", id, err)
+	}
+	return pages, nil
+}
+
+// ListDocuments возвращает документы пользователя, новые сначала
+func (p *KnowledgeProvider) ListDocuments(tgID, limit, offset int) ([]domain.KBDocument, int, error) {
+	var total int
+	if err := p.db.QueryRow(`SELECT COUNT(*) FROM kb_documents WHERE tg_id = ?`, tgID).Scan(&total); err != nil {
+		return nil, 0, err
+	}
+	rows, err := p.db.Query(`
+		SELECT `+kbDocumentColumns+`
+		FROM kb_documents d WHERE d.tg_id = ?
+		ORDER BY d.id DESC LIMIT ? OFFSET ?
+	`, tgID, limit, offset)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	docs := make([]domain.KBDocument, 0)
+	for rows.Next() {
+		doc, err := scanKBDocument(rows)
+		if err != nil {
+			return nil, 0, err
+		}
+		docs = append(docs, *doc)
+	}
+	return docs, total, rows.Err()
+}
+
+// ReplaceChunks заменяет фрагменты документа новыми, построенными моделью model
+func (p *KnowledgeProvider) ReplaceChunks(id int64, tgID int, model string, dimensions int, chunks []domain.KBChunk) error {
+	tx, err := p.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	res, err := tx.Exec(`
+		UPDATE kb_documents SET model = ?, dimensions = ?, updated_at = ? WHERE id = ? AND tg_id = ?
+	`, model, dimensions, dbNow(), id, tgID)
+	if err := affectedOrNotFound(res, err, domain.ErrKBDocumentNotFound); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`DELETE FROM kb_chunks WHERE document_id = ?`, id); err != nil {
+		return err
+	}
+	if err := insertChunks(tx, id, chunks); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// DeleteDocument удаляет документ вместе с фрагментами
+func (p *KnowledgeProvider) DeleteDocument(id int64, tgID int) error {
+	tx, err := p.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	res, err := tx.Exec(`DELETE FROM kb_documents WHERE id = ? AND tg_id = ?`, id, tgID)
+	if err := affectedOrNotFound(res, err, domain.ErrKBDocumentNotFound); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`DELETE FROM kb_chunks WHERE document_id = ?`, id); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// ListChunks возвращает все фрагменты документов пользователя вместе с векторами;
+// непустой documentIDs ограничивает выборку этими документами
+func (p *KnowledgeProvider) ListChunks(tgID int, documentIDs []int64) ([]domain.KBChunk, error) {
+	query := `
+		SELECT k.id, k.document_id, k.idx, k.page, k.text, k.vector, d.filename, d.model
+		FROM kb_chunks k JOIN kb_documents d ON d.id = k.document_id
+		WHERE d.tg_id = ?`
+	args := []any{tgID}
+	if len(documentIDs) > 0 {
+		query += ` AND d.id IN (?` + strings.Repeat(`, ?`, len(documentIDs)-1) + `)`
+		for _, id := range documentIDs {
+			args = append(args, id)
+		}
+	}
+	rows, err := p.db.Query(query+` ORDER BY k.document_id, k.idx`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	chunks := make([]domain.KBChunk, 0)
+	for rows.Next() {
+		var (
+			c      domain.KBChunk
+			vector []byte
+		)
+		if err := rows.Scan(&c.ID, &c.DocumentID, &c.Index, &c.Page, &c.Text, &vector, &c.Filename, &c.Model); err != nil {
+			return nil, err
+		}
+		c.Vector = decodeVector(vector)
+		chunks = append(chunks, c)
+	}
+	return chunks, rows.Err()
+}
+
+func insertChunks(tx *sql.Tx, documentID int64, chunks []domain.KBChunk) error {
+	stmt, err := tx.Prepare(`INSERT INTO kb_chunks (document_id, idx, page, text, vector) VALUES (?, ?, ?, ?, ?)`)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+	for _, c := range chunks {
+		if _, err := stmt.Exec(documentID, c.Index, c.Page, c.Text, encodeVector(c.Vector)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// encodeVector вектор в BLOB: float32 подряд, little-endian
+func encodeVector(v []float32) []byte {
+	buf := make([]byte, 4*len(v))
+	for i, f := range v {
+		binary.LittleEndian.PutUint32(buf[4*i:], math.Float32bits(f))
+	}
+	return buf
+}
+
+func decodeVector(buf []byte) []float32 {
+	v := make([]float32, len(buf)/4)
+	for i := range v {
+		v[i] = math.Float32frombits(binary.LittleEndian.Uint32(buf[4*i:]))
+	}
+	return v
+}
+
+func scanKBDocument(row rowScanner) (*domain.KBDocument, error) {
+	var d domain.KBDocument
+	if err := row.Scan(&d.ID, &d.TgID, &d.Filename, &d.Format, &d.Pages, &d.Model, &d.Dimensions, &d.CreatedAt, &d.UpdatedAt, &d.Chunks); err != nil {
+		return nil, err
+	}
+	return &d, nil
+}
@@ -0,0 +1,97 @@
+package db
+
+import (
+	"database/sql"
+	"geminiBackend/internal/domain"
+	"time"
+)
+
+type SessionsProvider struct {
+	db *sql.DB
+}
+
+func NewSessionsProvider(db *sql.DB) *SessionsProvider {
+	return &SessionsProvider{db: db}
+}
+
+const sessionColumns = `id, tg_id, refresh_hash, expires_at, revoked_at, last_used_at, created_at`
+
+// CreateSession сохраняет новую сессию с хешем refresh токена
+func (p *SessionsProvider) CreateSession(s domain.Session) error {
+	_, err := p.db.Exec(`
+		INSERT INTO sessions (id, tg_id, refresh_hash, expires_at)
+		VALUES (?, ?, ?, ?)
+	`, s.ID, s.TgID, s.RefreshHash, s.ExpiresAt)
+	return err
+}
+
+// GetSession возвращает сессию по id (jti)
+func (p *SessionsProvider) GetSession(id string) (*domain.Session, error) {
+	return p.scanSession(p.db.QueryRow(`SELECT `+sessionColumns+` FROM sessions WHERE id = ?`, id))
+}
+
+// GetSessionByRefreshHash возвращает сессию по хешу текущего refresh токена
+func (p *SessionsProvider) GetSessionByRefreshHash(hash string) (*domain.Session, error) {
+	return p.scanSession(p.db.QueryRow(`SELECT `+sessionColumns+` FROM sessions WHERE refresh_hash = ?`, hash))
+}
+
+// GetSessionByPrevRefreshHash ищет сессию по уже использованному refresh токену (для обнаружения повторного использования)
+func (p *SessionsProvider) GetSessionByPrevRefreshHash(hash string) (*domain.Session, error) {
+	return p.scanSession(p.db.QueryRow(`SELECT `+sessionColumns+` FROM sessions WHERE prev_refresh_hash = ?`, hash))
+}
+
+// RotateRefreshToken заменяет хеш refresh токена, запоминая предыдущий.
+// Обновление выполняется только если текущий хеш совпадает, чтобы два параллельных refresh не прошли оба.
+func (p *SessionsProvider) RotateRefreshToken(id, oldHash, newHash string, expiresAt time.Time) (bool, error) {
+	res, err := p.db.Exec(`
+		UPDATE sessions
+		SET refresh_hash = ?, prev_refresh_hash = ?, expires_at = ?, last_used_at = ?
+		WHERE id = ? AND refresh_hash = ? AND revoked_at IS NULL
+	`, newHash, oldHash, expiresAt, dbNow(), id, oldHash)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n == 1, err
+}
+
+// RevokeSession отзывает одну сессию
+func (p *SessionsProvider) RevokeSession(id string) error {
+	_, err := p.db.Exec(`
+		UPDATE sessions SET revoked_at = ? WHERE id = ? AND revoked_at IS NULL
+	`, dbNow(), id)
+	return err
+}
+
+// RevokeUserSessions отзывает все сессии пользователя по tg_id
+func (p *SessionsProvider) RevokeUserSessions(tgID int) error {
+	return revokeUserSessions(p.db, tgID)
+}
+
+func revokeUserSessions(ex execer, tgID int) error {
+	_, err := ex.Exec(`
+		UPDATE sessions SET revoked_at = ? WHERE tg_id = ? AND revoked_at IS NULL
+	`, dbNow(), tgID)
+	return err
+}
+
+// DeleteExpiredSessions удаляет истёкшие и отозванные сессии пользователя
+func (p *SessionsProvider) DeleteExpiredSessions(tgID int) error {
+	_, err := p.db.Exec(`
+		DELETE FROM sessions WHERE tg_id = ? AND (expires_at < ? OR revoked_at IS NOT NULL)
+	`, tgID, dbNow())
+	return err
+}
+
+func (p *SessionsProvider) scanSession(row *sql.Row) (*domain.Session, error) {
+	var s domain.Session
+	if err := row.Scan(&s.ID, &s.TgID, &s.RefreshHash, &s.ExpiresAt, &s.RevokedAt, &s.LastUsedAt, &s.CreatedAt); err != nil {
+		return nil, err
+	}
+	return &s, nil
+}
+
+// dbNow текущее время в UTC с точностью до секунды, чтобы строки DATETIME сравнивались корректно
+func dbNow() time.Time {
+	return time.Now().UTC().Truncate(time.Second)
+}
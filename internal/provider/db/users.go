@@ -2,22 +2,34 @@ package db
 
 import (
 	"database/sql"
+	"errors"
 	"geminiBackend/internal/domain"
+	"geminiBackend/pkg/keyring"
+	"strconv"
+	"strings"
 	"time"
 )
 
+// ErrNoMasterKey ключ в БД зашифрован, но мастер-ключ не настроен
+var ErrNoMasterKey = errors.New("gemini api key is encrypted but no master key is configured")
+
 type UsersProvider struct {
-	db *sql.DB
+	db   *sql.DB
+	keys *keyring.Keyring // nil — ключи хранятся без шифрования
 }
 
-func NewUsersProvider(db *sql.DB) *UsersProvider {
-	return &UsersProvider{db: db}
+func NewUsersProvider(db *sql.DB, keys *keyring.Keyring) *UsersProvider {
+	return &UsersProvider{db: db, keys: keys}
 }
 
 // Upsert пользователя по tg_id (создаёт или обновляет username, last_login)
 func (p *UsersProvider) UpsertTelegramUser(tgID int, username string) error {
+	return upsertTelegramUser(p.db, tgID, username)
+}
+
+func upsertTelegramUser(ex execer, tgID int, username string) error {
 	now := time.Now().Format(time.RFC3339)
-	_, err := p.db.Exec(`
+	_, err := ex.Exec(`
 				INSERT INTO users (tg_id, username, last_login)
         VALUES (?, ?, ?)
         ON CONFLICT(tg_id) DO UPDATE SET
@@ -28,36 +40,59 @@ func (p *UsersProvider) UpsertTelegramUser(tgID int, username string) error {
 	return err
 }
 
-// GetUserByTelegramID возвращает пользователя по tg_id
+// GetUserByTelegramID возвращает пользователя по tg_id; Gemini ключ возвращается расшифрованным
 func (p *UsersProvider) GetUserByTelegramID(tgID int) (*domain.UserDB, error) {
 	row := p.db.QueryRow(`
-		SELECT id, tg_id, username, gemini_api_key, is_admin, is_active, last_login, created_at, updated_at
+		SELECT id, tg_id, username, gemini_api_key, gemini_key_dek, gemini_key_version, is_admin, is_active, last_login, created_at, updated_at
 		FROM users
 		WHERE tg_id = ?
 	`, tgID)
-	var user domain.UserDB
-	err := row.Scan(&user.ID, &user.TgID, &user.Username, &user.GeminiAPIKey, &user.IsAdmin, &user.IsActive, &user.LastLogin, &user.CreatedAt, &user.UpdatedAt)
+	var (
+		user       domain.UserDB
+		wrappedKey sql.NullString
+		keyVersion int
+	)
+	err := row.Scan(&user.ID, &user.TgID, &user.Username, &user.GeminiAPIKey, &wrappedKey, &keyVersion, &user.IsAdmin, &user.IsActive, &user.LastLogin, &user.CreatedAt, &user.UpdatedAt)
 	if err != nil {
 		return nil, err
 	}
+	if user.GeminiAPIKey.Valid && keyVersion > 0 {
+		plain, err := p.openAPIKey(tgID, keyring.Sealed{Ciphertext: user.GeminiAPIKey.String, WrappedKey: wrappedKey.String, Version: keyVersion})
+		if err != nil {
+			return nil, err
+		}
+		user.GeminiAPIKey.String = plain
+	}
 	return &user, nil
 }
 
-// SetGeminiAPIKey устанавливает или обновляет Gemini API ключ для пользователя по tg_id
+// SetGeminiAPIKey устанавливает или обновляет Gemini API ключ для пользователя по tg_id.
+// Если настроен мастер-ключ, ключ сохраняется зашифрованным.
 func (p *UsersProvider) SetGeminiAPIKey(tgID int, apiKey string) error {
-	_, err := p.db.Exec(`
-		UPDATE users
-		SET gemini_api_key = ?, updated_at = CURRENT_TIMESTAMP
-		WHERE tg_id = ?
-	`, apiKey, tgID)
-	return err
+	if p.keys == nil {
+		_, err := p.db.Exec(`
+			UPDATE users
+			SET gemini_api_key = ?, gemini_key_dek = NULL, gemini_key_version = 0, updated_at = CURRENT_TIMESTAMP
+			WHERE tg_id = ?
+		`, apiKey, tgID)
+		return err
+	}
+	sealed, err := p.keys.Seal([]byte(apiKey), apiKeyAAD(tgID))
+	if err != nil {
+		return err
+	}
+	return p.storeSealedKey(p.db, tgID, sealed)
 }
 
 // ClearGeminiAPIKey устанавливает gemini_api_key = NULL для пользователя
 func (p *UsersProvider) ClearGeminiAPIKey(tgID int) error {
-	_, err := p.db.Exec(`
+	return clearGeminiAPIKey(p.db, tgID)
+}
+
+func clearGeminiAPIKey(ex execer, tgID int) error {
+	_, err := ex.Exec(`
 		UPDATE users
-		SET gemini_api_key = NULL, updated_at = CURRENT_TIMESTAMP
+		SET gemini_api_key = NULL, gemini_key_dek = NULL, gemini_key_version = 0, updated_at = CURRENT_TIMESTAMP
 		WHERE tg_id = ?
 	`, tgID)
 	return err
@@ -65,7 +100,11 @@ func (p *UsersProvider) ClearGeminiAPIKey(tgID int) error {
 
 // SetAdmin устанавливает или обновляет статус администратора для пользователя по tg_id
 func (p *UsersProvider) SetAdmin(tgID int, isAdmin bool) error {
-	_, err := p.db.Exec(`
+	return setAdmin(p.db, tgID, isAdmin)
+}
+
+func setAdmin(ex execer, tgID int, isAdmin bool) error {
+	_, err := ex.Exec(`
 		UPDATE users
 		SET is_admin = ?, updated_at = CURRENT_TIMESTAMP
 		WHERE tg_id = ?
@@ -75,10 +114,243 @@ func (p *UsersProvider) SetAdmin(tgID int, isAdmin bool) error {
 
 // SetActive устанавливает или обновляет статус активности для пользователя по tg_id
 func (p *UsersProvider) SetActive(tgID int, isActive bool) error {
-	_, err := p.db.Exec(`
+	return setActive(p.db, tgID, isActive)
+}
+
+func setActive(ex execer, tgID int, isActive bool) error {
+	_, err := ex.Exec(`
 		UPDATE users
 		SET is_active = ?, updated_at = CURRENT_TIMESTAMP
 		WHERE tg_id = ?
 	`, isActive, tgID)
 	return err
 }
+
+const userSummaryColumns = `tg_id, username, is_admin, is_active, gemini_api_key IS NOT NULL, last_login, created_at, updated_at`
+
+// ListUsers возвращает страницу пользователей по фильтру и общее число подходящих записей
+func (p *UsersProvider) ListUsers(f domain.UserFilter) ([]domain.UserSummary, int, error) {
+	var (
+		where []string
+		args  []any
+	)
+	if f.Query != "" {
+		where = append(where, "username LIKE ? ESCAPE '\\'")
+		args = append(args, "%"+escapeLike(f.Query)+"%")
+	}
+	if f.IsAdmin != nil {
+		where = append(where, "is_admin = ?")
+		args = append(args, *f.IsAdmin)
+	}
+	if f.IsActive != nil {
+		where = append(where, "is_active = ?")
+		args = append(args, *f.IsActive)
+	}
+	if f.HasKey != nil {
+		if *f.HasKey {
+			where = append(where, "gemini_api_key IS NOT NULL")
+		} else {
+			where = append(where, "gemini_api_key IS NULL")
+		}
+	}
+	cond := ""
+	if len(where) > 0 {
+		cond = "WHERE " + strings.Join(where, " AND ")
+	}
+
+	var total int
+	if err := p.db.QueryRow(`SELECT COUNT(*) FROM users `+cond, args...).Scan(&total); err != nil {
+		return nil, 0, err
+	}
+
+	rows, err := p.db.Query(`SELECT `+userSummaryColumns+` FROM users `+cond+` ORDER BY id LIMIT ? OFFSET ?`,
+		append(args, f.Limit, f.Offset)...)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+	users := make([]domain.UserSummary, 0)
+	for rows.Next() {
+		u, err := scanUserSummary(rows)
+		if err != nil {
+			return nil, 0, err
+		}
+		users = append(users, *u)
+	}
+	return users, total, rows.Err()
+}
+
+// GetUserSummary возвращает пользователя без расшифровки ключа
+func (p *UsersProvider) GetUserSummary(tgID int) (*domain.UserSummary, error) {
+	return scanUserSummary(p.db.QueryRow(`SELECT `+userSummaryColumns+` FROM users WHERE tg_id = ?`, tgID))
+}
+
+// DeleteUser удаляет пользователя вместе с его сессиями, диалогами и базой знаний
+func (p *UsersProvider) DeleteUser(tgID int) error {
+	tx, err := p.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if err := deleteUser(tx, tgID); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func deleteUser(ex execer, tgID int) error {
+	for _, query := range []string{
+		`DELETE FROM chat_messages WHERE conversation_id IN (SELECT id FROM conversations WHERE tg_id = ?)`,
+		`DELETE FROM conversations WHERE tg_id = ?`,
+		`DELETE FROM sessions WHERE tg_id = ?`,
+		`DELETE FROM api_tokens WHERE tg_id = ?`,
+		`DELETE FROM kb_chunks WHERE document_id IN (SELECT id FROM kb_documents WHERE tg_id = ?)`,
+		`DELETE FROM kb_documents WHERE tg_id = ?`,
+		`DELETE FROM users WHERE tg_id = ?`,
+	} {
+		if _, err := ex.Exec(query, tgID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ReencryptAPIKeys шифрует legacy ключи, хранящиеся открытым текстом, и перешифровывает
+// ключи данных, зашифрованные старыми версиями мастер-ключа. Выполняется в одной транзакции.
+func (p *UsersProvider) ReencryptAPIKeys() (domain.RekeyStats, error) {
+	var stats domain.RekeyStats
+	if p.keys == nil {
+		return stats, ErrNoMasterKey
+	}
+	tx, err := p.db.Begin()
+	if err != nil {
+		return stats, err
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.Query(`
+		SELECT tg_id, gemini_api_key, gemini_key_dek, gemini_key_version
+		FROM users
+		WHERE gemini_api_key IS NOT NULL
+	`)
+	if err != nil {
+		return stats, err
+	}
+	type row struct {
+		tgID    int
+		sealed  keyring.Sealed
+		dek     sql.NullString
+		version int
+	}
+	var pending []row
+	for rows.Next() {
+		var r row
+		if err := rows.Scan(&r.tgID, &r.sealed.Ciphertext, &r.dek, &r.version); err != nil {
+			rows.Close()
+			return stats, err
+		}
+		r.sealed.WrappedKey = r.dek.String
+		r.sealed.Version = r.version
+		pending = append(pending, r)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return stats, err
+	}
+
+	for _, r := range pending {
+		var sealed keyring.Sealed
+		switch {
+		case r.version == 0:
+			sealed, err = p.keys.Seal([]byte(r.sealed.Ciphertext), apiKeyAAD(r.tgID))
+			stats.Migrated++
+		case r.version != p.keys.ActiveVersion():
+			sealed, err = p.keys.Rewrap(r.sealed, apiKeyAAD(r.tgID))
+			stats.Rewrapped++
+		default:
+			stats.Unchanged++
+			continue
+		}
+		if err != nil {
+			return stats, err
+		}
+		if err := p.storeSealedKey(tx, r.tgID, sealed); err != nil {
+			return stats, err
+		}
+	}
+	return stats, tx.Commit()
+}
+
+// KeyVersionsInUse возвращает версии мастер-ключа, которыми зашифрованы сохранённые ключи
+func (p *UsersProvider) KeyVersionsInUse() ([]int, error) {
+	rows, err := p.db.Query(`
+		SELECT DISTINCT gemini_key_version
+		FROM users
+		WHERE gemini_api_key IS NOT NULL AND gemini_key_version > 0
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var versions []int
+	for rows.Next() {
+		var v int
+		if err := rows.Scan(&v); err != nil {
+			return nil, err
+		}
+		versions = append(versions, v)
+	}
+	return versions, rows.Err()
+}
+
+func (p *UsersProvider) openAPIKey(tgID int, sealed keyring.Sealed) (string, error) {
+	if p.keys == nil {
+		return "", ErrNoMasterKey
+	}
+	plain, err := p.keys.Open(sealed, apiKeyAAD(tgID))
+	if err != nil {
+		return "", err
+	}
+	return string(plain), nil
+}
+
+type execer interface {
+	Exec(query string, args ...any) (sql.Result, error)
+}
+
+func (p *UsersProvider) storeSealedKey(ex execer, tgID int, sealed keyring.Sealed) error {
+	_, err := ex.Exec(`
+		UPDATE users
+		SET gemini_api_key = ?, gemini_key_dek = ?, gemini_key_version = ?, updated_at = CURRENT_TIMESTAMP
+		WHERE tg_id = ?
+	`, sealed.Ciphertext, sealed.WrappedKey, sealed.Version, tgID)
+	return err
+}
+
+// apiKeyAAD привязывает шифротекст к пользователю, чтобы его нельзя было перенести в чужую строку
+func apiKeyAAD(tgID int) []byte {
+	return []byte("users.gemini_api_key:" + strconv.Itoa(tgID))
+}
+
+type rowScanner interface {
+	Scan(dest ...any) error
+}
+
+func scanUserSummary(row rowScanner) (*domain.UserSummary, error) {
+	var (
+		u         domain.UserSummary
+		lastLogin sql.NullTime
+	)
+	if err := row.Scan(&u.TgID, &u.Username, &u.IsAdmin, &u.IsActive, &u.HasKey, &lastLogin, &u.CreatedAt, &u.UpdatedAt); err != nil {
+		return nil, err
+	}
+	if lastLogin.Valid {
+		u.LastLogin = &lastLogin.Time
+	}
+	return &u, nil
+}
+
+// escapeLike экранирует спецсимволы LIKE
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s)
+}
@@ -3,17 +3,22 @@ package gemini
 import (
 	"context"
 	"geminiBackend/internal/domain"
+	"geminiBackend/internal/provider/llm"
 	"strings"
 
 	"google.golang.org/genai"
 )
 
 type Client struct {
-	apiKey string
-	model  string
+	apiKey  string
+	model   string
+	retrier *llm.Retrier
+	clients *ClientCache
 }
 
-func NewClient(apiKey, model string) *Client { return &Client{apiKey: apiKey, model: model} }
+func NewClient(apiKey, model string, retrier *llm.Retrier, clients *ClientCache) *Client {
+	return &Client{apiKey: apiKey, model: model, retrier: retrier, clients: clients}
+}
 
 // categorizeModel определяет категорию модели по её имени
 func categorizeModel(name string) string {
@@ -54,45 +59,56 @@ func categorizeModel(name string) string {
 	return "other"
 }
 
-func (c *Client) GetAvailableModels() ([]domain.ModelInfo, error) {
-	ctx := context.Background()
-	models := []domain.ModelInfo{}
+// GetAvailableModels список моделей по ключу клиента; при временной ошибке
+// список запрашивается заново целиком
+func (c *Client) GetAvailableModels(ctx context.Context) ([]domain.ModelInfo, error) {
+	var models []domain.ModelInfo
 
-	client, err := genai.NewClient(ctx, &genai.ClientConfig{
-		APIKey:  c.apiKey,
-		Backend: genai.BackendGeminiAPI,
-	})
+	client, err := c.clients.Get(c.apiKey)
 	if err != nil {
 		return nil, err
 	}
 
-	for model, err := range client.Models.All(ctx) {
-		if err != nil {
-			return nil, err
-		}
-
-		// Проверяем, поддерживает ли модель генерацию контента
-		supportsGeneration := false
-		for _, action := range model.SupportedActions {
-			if action == "generateContent" {
-				supportsGeneration = true
-				break
+	err = c.retrier.Do(ctx, "list_models", func() error {
+		models = []domain.ModelInfo{}
+		for model, err := range client.Models.All(ctx) {
+			if err != nil {
+				return apiError(err)
 			}
-		}
 
-		modelInfo := domain.ModelInfo{
-			Name:             model.Name,
-			DisplayName:      model.DisplayName,
-			Description:      model.Description,
-			SupportedActions: model.SupportedActions,
-			InputTokenLimit:  model.InputTokenLimit,
-			OutputTokenLimit: model.OutputTokenLimit,
-			Category:         categorizeModel(model.Name),
-			IsAvailable:      supportsGeneration, // Считаем доступной, если поддерживает generateContent
+			models = append(models, modelInfo(model))
 		}
-
-		models = append(models, modelInfo)
+		return nil
+	})
+	if err != nil {
+		return nil, err
 	}
 
 	return models, nil
 }
+
+// modelInfo переводит описание модели genai в domain.ModelInfo
+func modelInfo(model *genai.Model) domain.ModelInfo {
+	// Проверяем, поддерживает ли модель генерацию контента
+	supportsGeneration := false
+	for _, action := range model.SupportedActions {
+		if action == "generateContent" {
+			supportsGeneration = true
+			break
+		}
+	}
+
+	return domain.ModelInfo{
+		Name:             model.Name,
+		DisplayName:      model.DisplayName,
+		Description:      model.Description,
+		SupportedActions: model.SupportedActions,
+		InputTokenLimit:  model.InputTokenLimit,
+		OutputTokenLimit: model.OutputTokenLimit,
+		Category:         categorizeModel(model.Name),
+		IsAvailable:      supportsGeneration, // Считаем доступной, если поддерживает generateContent
+		MaxTemperature:   model.MaxTemperature,
+		MaxTopK:          model.TopK,
+		Provider:         ProviderName,
+	}
+}
@@ -0,0 +1,138 @@
+package gemini
+
+import (
+	"container/list"
+	"context"
+	"net/http"
+	"sync"
+	"time"
+
+	"geminiBackend/internal/provider/transport"
+	"geminiBackend/pkg/logger"
+
+	"google.golang.org/genai"
+)
+
+// ClientCache кэш клиентов genai по ключу API. Хранит не больше size клиентов,
+// вытесняя давно не использованные; клиент, простоявший дольше idleTTL, создаётся
+// заново при следующем обращении. Все клиенты работают через общий пул соединений.
+type ClientCache struct {
+	size       int
+	idleTTL    time.Duration
+	httpClient *http.Client
+	baseURL    string // пусто — адрес Gemini API по умолчанию (или GOOGLE_GEMINI_BASE_URL)
+
+	mu      sync.Mutex
+	order   *list.List // от недавно использованных к давно не использованным
+	entries map[string]*list.Element
+}
+
+type cachedClient struct {
+	apiKey   string
+	client   *genai.Client
+	lastUsed time.Time
+}
+
+// NewClientCache создает кэш на size клиентов; size < 1 — клиент создаётся на каждый
+// вызов, idleTTL <= 0 — без вытеснения по простою
+func NewClientCache(size int, idleTTL time.Duration) *ClientCache {
+	return &ClientCache{
+		size:       size,
+		idleTTL:    idleTTL,
+		httpClient: &http.Client{Transport: transport.Pooled},
+		order:      list.New(),
+		entries:    make(map[string]*list.Element),
+	}
+}
+
+// Get клиент genai для ключа: из кэша или новый. У nil — всегда новый.
+// Новый клиент создаётся без блокировки, чтобы не задерживать вызовы с другими ключами;
+// если за это время клиент для ключа уже появился в кэше, возвращается он.
+func (c *ClientCache) Get(apiKey string) (*genai.Client, error) {
+	if c == nil || c.size < 1 {
+		return c.newClient(apiKey)
+	}
+	if client, ok := c.lookup(apiKey); ok {
+		return client, nil
+	}
+
+	client, err := c.newClient(apiKey)
+	if err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if el, ok := c.entries[apiKey]; ok {
+		return c.touch(el, time.Now()), nil
+	}
+	c.entries[apiKey] = c.order.PushFront(&cachedClient{apiKey: apiKey, client: client, lastUsed: time.Now()})
+	for c.order.Len() > c.size {
+		c.remove(c.order.Back())
+	}
+	return client, nil
+}
+
+// lookup клиент для ключа из кэша, если он есть и не простоял дольше idleTTL
+func (c *ClientCache) lookup(apiKey string) (*genai.Client, bool) {
+	now := time.Now()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.evictIdle(now)
+	el, ok := c.entries[apiKey]
+	if !ok {
+		return nil, false
+	}
+	return c.touch(el, now), true
+}
+
+// touch отмечает клиента использованным и переносит его в начало списка
+func (c *ClientCache) touch(el *list.Element, now time.Time) *genai.Client {
+	entry := el.Value.(*cachedClient)
+	entry.lastUsed = now
+	c.order.MoveToFront(el)
+	return entry.client
+}
+
+// Len сколько клиентов сейчас в кэше
+func (c *ClientCache) Len() int {
+	if c == nil {
+		return 0
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.order.Len()
+}
+
+// evictIdle удаляет клиентов, простоявших дольше idleTTL; они в конце списка
+func (c *ClientCache) evictIdle(now time.Time) {
+	if c.idleTTL <= 0 {
+		return
+	}
+	for el := c.order.Back(); el != nil; el = c.order.Back() {
+		if now.Sub(el.Value.(*cachedClient).lastUsed) <= c.idleTTL {
+			return
+		}
+		c.remove(el)
+	}
+}
+
+func (c *ClientCache) remove(el *list.Element) {
+	c.order.Remove(el)
+	delete(c.entries, el.Value.(*cachedClient).apiKey)
+}
+
+func (c *ClientCache) newClient(apiKey string) (*genai.Client, error) {
+	cfg := &genai.ClientConfig{APIKey: apiKey, Backend: genai.BackendGeminiAPI}
+	if c != nil {
+		cfg.HTTPClient = c.httpClient
+		cfg.HTTPOptions.BaseURL = c.baseURL
+	}
+	// Клиент переживает запрос, поэтому создаётся не в его контексте
+	client, err := genai.NewClient(context.Background(), cfg)
+	if err != nil {
+		logger.L.Error("failed to create genai client", "error", err.Error())
+		return nil, err
+	}
+	return client, nil
+}
@@ -0,0 +1,79 @@
+package gemini
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"geminiBackend/internal/domain"
+	"geminiBackend/internal/provider/llm"
+	"geminiBackend/internal/provider/transport"
+)
+
+// BenchmarkGeminiGenerate сравнивает вызов Gemini так, как он шёл до кэша, — новый
+// клиент genai поверх нового http.Client со своим транспортом на каждый запрос, то есть
+// новое TCP/TLS-соединение на каждый вызов, — с клиентом из кэша поверх общего пула
+// соединений. Имитация API работает по TLS, запросы идут параллельно.
+//
+//	go test ./internal/provider/gemini/ -run '^$' -bench . -benchmem
+func BenchmarkGeminiGenerate(b *testing.B) {
+	fake := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"candidates":[{"content":{"role":"model","parts":[{"text":"ok"}]},"finishReason":"STOP"}],"usageMetadata":{"promptTokenCount":1,"candidatesTokenCount":1,"totalTokenCount":2}}`)
+	}))
+	defer fake.Close()
+	trust := fake.Client().Transport.(*http.Transport).TLSClientConfig
+
+	req := llm.Request{
+		Model:    "gemini-2.5-flash",
+		APIKey:   "test_api_key_1234567890",
+		Messages: []domain.ChatMessage{{Role: domain.ChatRoleUser, Content: "hi"}},
+	}
+	ctx := context.Background()
+
+	b.Run("new_client", func(b *testing.B) {
+		b.ReportAllocs()
+		b.SetParallelism(8)
+		b.RunParallel(func(pb *testing.PB) {
+			for pb.Next() {
+				// До кэша: свой http.Client и транспорт на вызов, соединение не переиспользуется
+				httpTransport := withTLS(http.DefaultTransport.(*http.Transport), trust)
+				clients := NewClientCache(0, 0)
+				clients.httpClient = &http.Client{Transport: httpTransport}
+				clients.baseURL = fake.URL
+				_, err := NewProvider(nil, clients).Generate(ctx, req)
+				httpTransport.CloseIdleConnections()
+				if err != nil {
+					b.Error(err)
+					return
+				}
+			}
+		})
+	})
+
+	b.Run("cached", func(b *testing.B) {
+		clients := NewClientCache(16, time.Minute)
+		clients.httpClient = &http.Client{Transport: withTLS(transport.Pooled, trust)}
+		clients.baseURL = fake.URL
+		provider := NewProvider(nil, clients)
+		b.ReportAllocs()
+		b.SetParallelism(8)
+		b.RunParallel(func(pb *testing.PB) {
+			for pb.Next() {
+				if _, err := provider.Generate(ctx, req); err != nil {
+					b.Error(err)
+					return
+				}
+			}
+		})
+	})
+}
+
+func withTLS(base *http.Transport, cfg *tls.Config) *http.Transport {
+	transport := base.Clone()
+	transport.TLSClientConfig = cfg
+	return transport
+}
@@ -1,5 +0,0 @@
-package gemini
-
-func (c *Client) ProcessDocument(data []byte) (string, error) {
-	return "document-stub", nil
-}
@@ -0,0 +1,73 @@
+package gemini
+
+import (
+	"context"
+	"geminiBackend/internal/domain"
+	"strings"
+
+	"google.golang.org/genai"
+)
+
+// generateConfig переводит параметры запроса в конфиг genai; незаданные поля остаются
+// пустыми, и Gemini применяет значения модели по умолчанию
+func generateConfig(p domain.GenerationParams) *genai.GenerateContentConfig {
+	cfg := &genai.GenerateContentConfig{
+		Temperature:   p.Temperature,
+		TopP:          p.TopP,
+		StopSequences: p.StopSequences,
+	}
+	if p.SystemInstruction != "" {
+		cfg.SystemInstruction = genai.NewContentFromText(p.SystemInstruction, genai.RoleUser)
+	}
+	if p.TopK != nil {
+		topK := float32(*p.TopK)
+		cfg.TopK = &topK
+	}
+	if p.MaxOutputTokens != nil {
+		cfg.MaxOutputTokens = int32(*p.MaxOutputTokens)
+	}
+	if p.Seed != nil {
+		seed := int32(*p.Seed)
+		cfg.Seed = &seed
+	}
+	if p.CandidateCount != nil {
+		cfg.CandidateCount = int32(*p.CandidateCount)
+	}
+	return cfg
+}
+
+// candidateTexts собирает текст каждого варианта ответа (без служебных "мыслей" модели)
+func candidateTexts(result *genai.GenerateContentResponse) []string {
+	texts := make([]string, 0, len(result.Candidates))
+	for _, cand := range result.Candidates {
+		if cand.Content == nil {
+			continue
+		}
+		var sb strings.Builder
+		for _, part := range cand.Content.Parts {
+			if part.Text != "" && !part.Thought {
+				sb.WriteString(part.Text)
+			}
+		}
+		texts = append(texts, sb.String())
+	}
+	return texts
+}
+
+// GetModel возвращает описание и лимиты модели клиента
+func (c *Client) GetModel(ctx context.Context) (*domain.ModelInfo, error) {
+	client, err := c.clients.Get(c.apiKey)
+	if err != nil {
+		return nil, err
+	}
+	var model *genai.Model
+	err = c.retrier.Do(ctx, "get_model", func() error {
+		model, err = client.Models.Get(ctx, modelName(c.model), nil)
+		return apiError(err)
+	})
+	if err != nil {
+		return nil, err
+	}
+	info := modelInfo(model)
+	return &info, nil
+}
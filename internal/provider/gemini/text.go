@@ -2,34 +2,339 @@ package gemini
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"geminiBackend/internal/domain"
+	"geminiBackend/internal/provider/llm"
 	"geminiBackend/pkg/logger"
+	"strings"
+	"time"
 
 	"google.golang.org/genai"
 )
 
-func (c *Client) GenerateText(prompt string) (string, error) {
-	ctx := context.Background()
-	client, err := genai.NewClient(ctx, &genai.ClientConfig{APIKey: c.apiKey})
+// defaultModel модель Gemini по умолчанию, если в запросе не указана
+const defaultModel = "gemini-2.5-flash"
+
+// defaultEmbeddingModel модель эмбеддингов Gemini по умолчанию
+const defaultEmbeddingModel = "gemini-embedding-001"
+
+// Лимиты Gemini API, не зависящие от модели
+const (
+	maxTemperature   = 2.0
+	maxCandidates    = 8
+	maxStopSequences = 5
+	maxInlineBytes   = 20 << 20 // суммарный размер inline-данных в запросе
+	maxEmbedBatch    = 100      // входов в одном EmbedContent
+)
+
+// ProviderName имя поставщика Gemini в маршрутах моделей
+const ProviderName = "gemini"
+
+// Provider поставщик моделей Gemini API; ключ передаётся в каждом запросе.
+// Временные ошибки (429, 5xx) повторяются через retrier (nil — без повторов).
+// Клиенты genai берутся из clients (nil — новый клиент на каждый вызов).
+type Provider struct {
+	retrier *llm.Retrier
+	clients *ClientCache
+}
+
+var _ llm.ModelDescriber = (*Provider)(nil)
+var _ llm.Embedder = (*Provider)(nil)
+var _ llm.Provider = (*Provider)(nil)
+
+func NewProvider(retrier *llm.Retrier, clients *ClientCache) *Provider {
+	return &Provider{retrier: retrier, clients: clients}
+}
+
+func (p *Provider) Name() string { return ProviderName }
+
+// Capabilities лимиты Gemini API. PDF принимают только мультимодальные модели Gemini.
+func (p *Provider) Capabilities(model string) llm.Capabilities {
+	types := map[string]bool{
+		"image/jpeg": true,
+		"image/png":  true,
+		"image/webp": true,
+		"image/heic": true,
+	}
+	if supportsPDF(modelName(model)) {
+		types["application/pdf"] = true
+	}
+	return llm.Capabilities{
+		RequiresAPIKey:   true,
+		MaxInlineBytes:   maxInlineBytes,
+		MaxTemperature:   maxTemperature,
+		MaxCandidates:    maxCandidates,
+		MaxStopSequences: maxStopSequences,
+		AttachmentTypes:  types,
+		MaxEmbedBatch:    maxEmbedBatch,
+		ExactTokenCount:  true,
+	}
+}
+
+// Generate генерирует ответ с учётом параметров запроса. Возвращает текст каждого
+// варианта ответа; без candidate_count вариант один.
+func (p *Provider) Generate(ctx context.Context, req llm.Request) (_ *llm.Response, err error) {
+	defer func() { err = classifyError(err) }()
+	client, err := p.clients.Get(req.APIKey)
 	if err != nil {
-		logger.L.Error("failed to create genai client", "error", err.Error())
-		return "", err
+		return nil, err
 	}
 
-	// Используем модель из клиента, если не указана - дефолтная gemini-2.5-flash
-	model := c.model
-	if model == "" {
-		model = "gemini-2.5-flash"
+	model := modelName(req.Model)
+	var result *genai.GenerateContentResponse
+	err = p.retrier.Do(ctx, "generate", func() error {
+		result, err = client.Models.GenerateContent(ctx, model, contents(req), generateConfig(req.Params))
+		return apiError(err)
+	})
+	if err != nil {
+		logger.L.Error("failed to generate content", "error", err.Error(), "model", model, "attachments", len(req.Attachments))
+		return nil, err
 	}
+	if reason := blockReason(result, true); reason != "" {
+		logger.L.Warn("content blocked", "model", model, "reason", reason)
+		return nil, fmt.Errorf("%w: %s", llm.ErrBlocked, reason)
+	}
+	return &llm.Response{Texts: candidateTexts(result), Usage: usage(model, result)}, nil
+}
+
+// Stream генерирует ответ потоково через GenerateContentStream.
+// Возвращает расход токенов из последнего фрагмента. Повтор возможен только
+// до первого фрагмента текста, отданного в onDelta.
+func (p *Provider) Stream(ctx context.Context, req llm.Request, onDelta func(string) error) (_ domain.AIUsage, err error) {
+	defer func() { err = classifyError(err) }()
+	model := modelName(req.Model)
+	total := domain.AIUsage{Model: model}
+
+	client, err := p.clients.Get(req.APIKey)
+	if err != nil {
+		return total, err
+	}
+
+	err = p.retrier.Do(ctx, "stream", func() error {
+		started := false
+		for result, err := range client.Models.GenerateContentStream(ctx, model, contents(req), generateConfig(req.Params)) {
+			if err != nil {
+				logger.L.Error("failed to stream content", "error", err.Error(), "model", model)
+				if started {
+					return llm.NoRetry(apiError(err))
+				}
+				return apiError(err)
+			}
+			if reason := blockReason(result, false); reason != "" {
+				logger.L.Warn("content blocked", "model", model, "reason", reason)
+				return fmt.Errorf("%w: %s", llm.ErrBlocked, reason)
+			}
+			if result.UsageMetadata != nil {
+				total = usage(model, result)
+			}
+			if text := result.Text(); text != "" {
+				started = true
+				if err := onDelta(text); err != nil {
+					return llm.NoRetry(err)
+				}
+			}
+		}
+		return ctx.Err()
+	})
+	return total, err
+}
+
+// CountTokens считает токены запроса через CountTokens API
+func (p *Provider) CountTokens(ctx context.Context, req llm.Request) (_ int, err error) {
+	defer func() { err = classifyError(err) }()
+	client, err := p.clients.Get(req.APIKey)
+	if err != nil {
+		return 0, err
+	}
+	model := modelName(req.Model)
+	var result *genai.CountTokensResponse
+	err = p.retrier.Do(ctx, "count_tokens", func() error {
+		result, err = client.Models.CountTokens(ctx, model, contents(req), nil)
+		return apiError(err)
+	})
+	if err != nil {
+		logger.L.Error("failed to count tokens", "error", err.Error(), "model", model)
+		return 0, err
+	}
+	return int(result.TotalTokens), nil
+}
 
-	result, err := client.Models.GenerateContent(
-		ctx,
-		model,
-		genai.Text(prompt),
-		&genai.GenerateContentConfig{},
-	)
+// Embed строит эмбеддинги через EmbedContent; каждый вход — отдельный Content, назначение
+// передаётся в task_type. Gemini API не возвращает расход токенов для эмбеддингов — он
+// оценивается по длине текста.
+func (p *Provider) Embed(ctx context.Context, req llm.EmbedRequest) (_ *llm.EmbedResponse, err error) {
+	defer func() { err = classifyError(err) }()
+	client, err := p.clients.Get(req.APIKey)
 	if err != nil {
-		logger.L.Error("failed to generate content", "error", err.Error(), "model", model)
-		return "", err
+		return nil, err
+	}
+	model := req.Model
+	if model == "" {
+		model = defaultEmbeddingModel
+	}
+	inputs := make([]*genai.Content, len(req.Inputs))
+	for i, text := range req.Inputs {
+		inputs[i] = genai.NewContentFromText(text, genai.RoleUser)
+	}
+	cfg := &genai.EmbedContentConfig{TaskType: strings.ToUpper(req.TaskType)}
+	if req.Dimensions > 0 {
+		dims := int32(req.Dimensions)
+		cfg.OutputDimensionality = &dims
+	}
+	var result *genai.EmbedContentResponse
+	err = p.retrier.Do(ctx, "embed", func() error {
+		result, err = client.Models.EmbedContent(ctx, model, inputs, cfg)
+		return apiError(err)
+	})
+	if err != nil {
+		logger.L.Error("failed to embed content", "error", err.Error(), "model", model, "inputs", len(req.Inputs))
+		return nil, err
+	}
+	resp := &llm.EmbedResponse{Vectors: make([][]float32, len(result.Embeddings))}
+	for i, e := range result.Embeddings {
+		resp.Vectors[i] = e.Values
+	}
+	tokens := llm.EstimateTextTokens(req.Inputs...)
+	resp.Usage = domain.AIUsage{Model: model, PromptTokens: tokens, TotalTokens: tokens}
+	return resp, nil
+}
+
+// ListModels модели, доступные по ключу пользователя
+func (p *Provider) ListModels(ctx context.Context, apiKey string) ([]domain.ModelInfo, error) {
+	models, err := NewClient(apiKey, "", p.retrier, p.clients).GetAvailableModels(ctx)
+	return models, classifyError(err)
+}
+
+// DescribeModel описание и лимиты модели
+func (p *Provider) DescribeModel(ctx context.Context, apiKey, model string) (*domain.ModelInfo, error) {
+	info, err := NewClient(apiKey, model, p.retrier, p.clients).GetModel(ctx)
+	return info, classifyError(err)
+}
+
+// contents переводит историю запроса в genai.Content с ролями user/model;
+// вложения становятся inline-частями последнего сообщения перед его текстом
+func contents(req llm.Request) []*genai.Content {
+	list := make([]*genai.Content, 0, len(req.Messages))
+	for i, m := range req.Messages {
+		role := genai.Role(genai.RoleUser)
+		if m.Role == domain.ChatRoleAssistant {
+			role = genai.RoleModel
+		}
+		if i == len(req.Messages)-1 && len(req.Attachments) > 0 {
+			parts := make([]*genai.Part, 0, len(req.Attachments)+1)
+			for _, a := range req.Attachments {
+				parts = append(parts, genai.NewPartFromBytes(a.Data, a.MIMEType))
+			}
+			parts = append(parts, genai.NewPartFromText(m.Content))
+			list = append(list, genai.NewContentFromParts(parts, role))
+			continue
+		}
+		list = append(list, genai.NewContentFromText(m.Content, role))
+	}
+	return list
+}
+
+// apiError сохраняет HTTP-статус ошибки Gemini API, чтобы по нему можно было
+// классифицировать ошибку (квота, недоступность), и паузу из google.rpc.RetryInfo
+func apiError(err error) error {
+	var apiErr genai.APIError
+	if errors.As(err, &apiErr) {
+		return &llm.StatusError{Code: apiErr.Code, RetryAfter: retryDelay(apiErr), Message: apiErr.Message, Err: err}
+	}
+	return err
+}
+
+// classifyError приводит ошибку Gemini API к *domain.ProviderError. Неверный ключ
+// Gemini отвечает 400 INVALID_ARGUMENT с причиной API_KEY_INVALID, а недоступный без
+// оплаты тариф — 400 FAILED_PRECONDITION; остальное классифицируется по статусу.
+func classifyError(err error) error {
+	var apiErr genai.APIError
+	if errors.As(err, &apiErr) {
+		switch {
+		case detailReason(apiErr) == "API_KEY_INVALID":
+			return &domain.ProviderError{Kind: domain.ErrInvalidAPIKey, Provider: ProviderName, Message: apiErr.Message, Err: err}
+		case apiErr.Status == "FAILED_PRECONDITION" && strings.Contains(strings.ToLower(apiErr.Message), "billing"):
+			return &domain.ProviderError{Kind: domain.ErrBillingRequired, Provider: ProviderName, Message: apiErr.Message, Err: err}
+		}
+		var statusErr *llm.StatusError
+		if !errors.As(err, &statusErr) {
+			err = apiError(err)
+		}
+	}
+	return llm.Classify(ProviderName, err)
+}
+
+// detailReason причина ошибки из google.rpc.ErrorInfo (например, API_KEY_INVALID)
+func detailReason(apiErr genai.APIError) string {
+	for _, detail := range apiErr.Details {
+		if t, _ := detail["@type"].(string); strings.HasSuffix(t, "google.rpc.ErrorInfo") {
+			reason, _ := detail["reason"].(string)
+			return reason
+		}
+	}
+	return ""
+}
+
+// retryDelay пауза, через которую Gemini API просит повторить запрос
+// (details[].retryDelay вида "23s" у google.rpc.RetryInfo); 0 — не указана
+func retryDelay(apiErr genai.APIError) time.Duration {
+	for _, detail := range apiErr.Details {
+		if t, _ := detail["@type"].(string); !strings.HasSuffix(t, "google.rpc.RetryInfo") {
+			continue
+		}
+		if s, ok := detail["retryDelay"].(string); ok {
+			if d, err := time.ParseDuration(s); err == nil && d > 0 {
+				return d
+			}
+		}
+	}
+	return 0
+}
+
+// blockReason причина блокировки ответа фильтрами безопасности: запрос заблокирован
+// целиком или генерация остановлена по соображениям безопасности. Если whole,
+// ответ считается заблокированным, только когда остановлены все варианты.
+func blockReason(result *genai.GenerateContentResponse, whole bool) string {
+	if result.PromptFeedback != nil && result.PromptFeedback.BlockReason != "" {
+		return string(result.PromptFeedback.BlockReason)
+	}
+	reason := ""
+	for _, cand := range result.Candidates {
+		switch cand.FinishReason {
+		case genai.FinishReasonSafety, genai.FinishReasonProhibitedContent, genai.FinishReasonBlocklist,
+			genai.FinishReasonSPII, genai.FinishReasonImageSafety:
+			reason = string(cand.FinishReason)
+			if !whole {
+				return reason
+			}
+		default:
+			if whole {
+				return ""
+			}
+		}
+	}
+	return reason
+}
+
+func usage(model string, result *genai.GenerateContentResponse) domain.AIUsage {
+	u := domain.AIUsage{Model: model}
+	if result.UsageMetadata != nil {
+		u.PromptTokens = int(result.UsageMetadata.PromptTokenCount)
+		u.CompletionTokens = int(result.UsageMetadata.CandidatesTokenCount)
+		u.TotalTokens = int(result.UsageMetadata.TotalTokenCount)
+	}
+	return u
+}
+
+// supportsPDF может ли модель принимать PDF напрямую (мультимодальные модели Gemini)
+func supportsPDF(model string) bool {
+	return strings.Contains(strings.ToLower(model), "gemini") && categorizeModel(model) == "multimodal"
+}
+
+func modelName(model string) string {
+	if model == "" {
+		return defaultModel
 	}
-	return result.Text(), nil
+	return model
 }
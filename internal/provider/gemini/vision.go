@@ -1,5 +0,0 @@
-package gemini
-
-func (c *Client) AnalyzeImage(data []byte) (string, error) {
-	return "vision-stub", nil
-}
@@ -0,0 +1,101 @@
+package llm
+
+import (
+	"context"
+	"errors"
+	"geminiBackend/internal/domain"
+	"net"
+	"net/http"
+	"time"
+)
+
+// ErrBlocked ответ модели заблокирован фильтрами безопасности
+var ErrBlocked = errors.New("response blocked by safety filters")
+
+// StatusError ошибка HTTP-ответа поставщика; Code — статус ответа,
+// RetryAfter — через сколько сервер просит повторить запрос (0 — не указано),
+// Message — текст ошибки от сервера, который можно показать клиенту (пусто — нет)
+type StatusError struct {
+	Code       int
+	RetryAfter time.Duration
+	Message    string
+	Err        error
+}
+
+func (e *StatusError) Error() string { return e.Err.Error() }
+func (e *StatusError) Unwrap() error { return e.Err }
+
+// Классы ошибок генерации, по которым политика переходит к следующей модели
+const (
+	ErrorClassRateLimit   = "429"     // превышена квота или частота запросов
+	ErrorClassUnavailable = "503"     // поставщик недоступен: ответ 5xx или ошибка соединения
+	ErrorClassTimeout     = "timeout" // истёк таймаут запроса или соединения
+	ErrorClassSafety      = "safety"  // ответ заблокирован фильтрами безопасности
+)
+
+// ErrorClass класс ошибки генерации; пусто — ошибка не относится ни к одному классу
+func ErrorClass(err error) string {
+	var statusErr *StatusError
+	var netErr net.Error
+	switch {
+	case err == nil:
+		return ""
+	case errors.Is(err, ErrBlocked):
+		return ErrorClassSafety
+	case errors.Is(err, context.DeadlineExceeded), errors.As(err, &netErr) && netErr.Timeout():
+		return ErrorClassTimeout
+	case errors.As(err, &statusErr) && statusErr.Code == 429:
+		return ErrorClassRateLimit
+	case errors.As(err, &statusErr) && statusErr.Code >= 500, errors.As(err, &netErr):
+		return ErrorClassUnavailable
+	}
+	return ""
+}
+
+// Classify приводит ошибку поставщика к *domain.ProviderError по статусу ответа,
+// блокировке фильтрами, таймауту или ошибке соединения. Прочие ошибки (отмена
+// запроса, ошибки параметров, ошибки клиента потока) возвращаются как есть.
+func Classify(provider string, err error) error {
+	var providerErr *domain.ProviderError
+	var statusErr *StatusError
+	var netErr net.Error
+	switch {
+	case err == nil, errors.As(err, &providerErr), errors.Is(err, context.Canceled):
+		return err
+	case errors.Is(err, ErrBlocked):
+		return &domain.ProviderError{Kind: domain.ErrContentBlocked, Provider: provider, Message: err.Error(), Err: err}
+	case errors.Is(err, context.DeadlineExceeded), errors.As(err, &netErr) && netErr.Timeout():
+		return &domain.ProviderError{Kind: domain.ErrProviderUnavailable, Provider: provider, Message: "provider did not respond in time", Err: err}
+	case errors.As(err, &statusErr):
+		kind := statusKind(statusErr.Code)
+		msg := statusErr.Message
+		if msg == "" {
+			msg = kind.Error()
+		}
+		return &domain.ProviderError{Kind: kind, Provider: provider, Message: msg, Err: err}
+	case errors.As(err, &netErr):
+		return &domain.ProviderError{Kind: domain.ErrProviderUnavailable, Provider: provider, Message: "provider is unreachable", Err: err}
+	}
+	return err
+}
+
+// statusKind типовая ошибка по HTTP-статусу ответа поставщика
+func statusKind(code int) error {
+	switch code {
+	case http.StatusBadRequest, http.StatusRequestEntityTooLarge, http.StatusUnprocessableEntity:
+		return domain.ErrProviderRejected
+	case http.StatusUnauthorized:
+		return domain.ErrInvalidAPIKey
+	case http.StatusPaymentRequired:
+		return domain.ErrBillingRequired
+	case http.StatusForbidden:
+		return domain.ErrPermissionDenied
+	case http.StatusNotFound:
+		return domain.ErrModelNotFound
+	case http.StatusTooManyRequests:
+		return domain.ErrQuotaExceeded
+	case http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return domain.ErrProviderUnavailable
+	}
+	return domain.ErrProviderError
+}
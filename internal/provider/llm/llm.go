@@ -0,0 +1,132 @@
+// Package llm описывает общий интерфейс поставщиков языковых моделей (Gemini, Ollama)
+// и реестр, выбирающий поставщика по имени модели.
+package llm
+
+import (
+	"context"
+	"geminiBackend/internal/domain"
+	"unicode/utf8"
+)
+
+// Request запрос к модели. Messages — история в хронологическом порядке, последним
+// идёт сообщение пользователя; Attachments прикладываются к последнему сообщению.
+type Request struct {
+	Model       string
+	APIKey      string // ключ пользователя; нужен поставщикам с RequiresAPIKey
+	Messages    []domain.ChatMessage
+	Attachments []domain.Attachment // изображения, PDF — допустимые типы см. Capabilities.AttachmentTypes
+	Params      domain.GenerationParams
+}
+
+// Response ответ модели: текст каждого варианта и расход токенов
+type Response struct {
+	Texts []string
+	Usage domain.AIUsage
+}
+
+// Text текст первого варианта ответа
+func (r *Response) Text() string {
+	if len(r.Texts) == 0 {
+		return ""
+	}
+	return r.Texts[0]
+}
+
+// Capabilities возможности и лимиты поставщика для конкретной модели; 0 — лимит неизвестен или отсутствует
+type Capabilities struct {
+	RequiresAPIKey   bool
+	MaxInputChars    int // в символах Unicode; вход длиннее режется на части на стороне сервиса
+	MaxInlineBytes   int // суммарный размер вложений в одном запросе
+	MaxTemperature   float32
+	MaxOutputTokens  int
+	MaxCandidates    int
+	MaxStopSequences int
+	AttachmentTypes  map[string]bool // MIME-типы вложений, которые понимает модель
+	MaxEmbedBatch    int             // входов в одном запросе эмбеддингов; больше — запрос делится на пачки
+	ExactTokenCount  bool            // CountTokens считает токенизатором модели, а не оценивает
+	// TextDefaults параметры по умолчанию для генерации текста (/ai/text, документы),
+	// применяются к незаданным полям запроса
+	TextDefaults domain.GenerationParams
+}
+
+// Accepts понимает ли модель вложение данного MIME-типа
+func (c Capabilities) Accepts(mimeType string) bool {
+	return c.AttachmentTypes[mimeType]
+}
+
+// Provider поставщик моделей
+type Provider interface {
+	// Name имя поставщика, на которое ссылаются маршруты в конфиге (gemini, ollama)
+	Name() string
+	Capabilities(model string) Capabilities
+	Generate(ctx context.Context, req Request) (*Response, error)
+	// Stream генерирует ответ потоково, передавая фрагменты в onDelta; ошибка из onDelta
+	// или отмена ctx прерывают генерацию
+	Stream(ctx context.Context, req Request, onDelta func(string) error) (domain.AIUsage, error)
+	ListModels(ctx context.Context, apiKey string) ([]domain.ModelInfo, error)
+	// CountTokens число токенов входа запроса; точное, только если Capabilities.ExactTokenCount
+	CountTokens(ctx context.Context, req Request) (int, error)
+}
+
+// ModelDescriber поставщик, умеющий вернуть описание и лимиты отдельной модели
+type ModelDescriber interface {
+	DescribeModel(ctx context.Context, apiKey, model string) (*domain.ModelInfo, error)
+}
+
+// EmbedRequest запрос векторных представлений текстов
+type EmbedRequest struct {
+	Model      string
+	APIKey     string
+	Inputs     []string
+	Dimensions int    // размерность вектора; 0 — по умолчанию модели
+	TaskType   string // назначение векторов (domain.EmbedTask*); пусто — общее
+}
+
+// EmbedResponse векторы в порядке входов и расход токенов
+type EmbedResponse struct {
+	Vectors [][]float32
+	Usage   domain.AIUsage
+}
+
+// Embedder поставщик, умеющий строить эмбеддинги
+type Embedder interface {
+	Embed(ctx context.Context, req EmbedRequest) (*EmbedResponse, error)
+}
+
+// EstimateTokens грубая оценка числа токенов (около 4 символов на токен) для
+// поставщиков без собственного счётчика
+func EstimateTokens(req Request) int {
+	texts := []string{req.Params.SystemInstruction}
+	for _, m := range req.Messages {
+		texts = append(texts, m.Content)
+	}
+	return EstimateTextTokens(texts...)
+}
+
+// EstimateTextTokens оценка числа токенов набора текстов (около 4 символов на токен)
+func EstimateTextTokens(texts ...string) int {
+	chars := 0
+	for _, t := range texts {
+		chars += utf8.RuneCountInString(t)
+	}
+	return (chars + 3) / 4
+}
+
+// InputChars суммарная длина текста сообщений запроса в символах Unicode
+func InputChars(req Request) int {
+	total := 0
+	for _, m := range req.Messages {
+		total += utf8.RuneCountInString(m.Content)
+	}
+	return total
+}
+
+// UserPrompt запрос из одного сообщения пользователя
+func UserPrompt(model, apiKey, prompt string, params domain.GenerationParams) Request {
+	return Request{
+		Model:    model,
+		APIKey:   apiKey,
+		Messages: []domain.ChatMessage{{Role: domain.ChatRoleUser, Content: prompt}},
+		Params:   params,
+	}
+}
@@ -0,0 +1,57 @@
+package llm
+
+import (
+	"fmt"
+	"time"
+)
+
+// DefaultFallbackOn классы ошибок, при которых политика без явных условий переходит к следующей модели
+var DefaultFallbackOn = []string{ErrorClassRateLimit, ErrorClassUnavailable, ErrorClassTimeout}
+
+// Policy политика маршрутизации: модели пробуются по порядку, к следующей модели
+// переходим только при ошибке из классов On, выждав Delay
+type Policy struct {
+	Name   string
+	Models []string
+	On     []string
+	Delay  time.Duration
+}
+
+// Validate проверяет, что в политике есть модели и известны все классы ошибок
+func (p Policy) Validate() error {
+	if len(p.Models) == 0 {
+		return fmt.Errorf("routing policy %q has no models", p.Name)
+	}
+	for _, class := range p.On {
+		if !ValidErrorClass(class) {
+			return fmt.Errorf("routing policy %q: unknown error class %q (allowed: 429, 503, timeout, safety)", p.Name, class)
+		}
+	}
+	if p.Delay < 0 {
+		return fmt.Errorf("routing policy %q: negative delay", p.Name)
+	}
+	return nil
+}
+
+// FallsBackOn переходит ли политика к следующей модели после такой ошибки
+func (p Policy) FallsBackOn(err error) bool {
+	class := ErrorClass(err)
+	if class == "" {
+		return false
+	}
+	for _, c := range p.On {
+		if c == class {
+			return true
+		}
+	}
+	return false
+}
+
+// ValidErrorClass известен ли класс ошибки
+func ValidErrorClass(class string) bool {
+	switch class {
+	case ErrorClassRateLimit, ErrorClassUnavailable, ErrorClassTimeout, ErrorClassSafety:
+		return true
+	}
+	return false
+}
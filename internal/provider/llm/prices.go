@@ -0,0 +1,58 @@
+package llm
+
+import (
+	"fmt"
+	"path"
+	"strconv"
+	"strings"
+)
+
+// Price цены модели в долларах за миллион токенов. Pattern — glob (path.Match)
+// по имени модели без учёта регистра, как у Route.
+type Price struct {
+	Pattern string
+	Input   float64
+	Output  float64
+}
+
+// ParsePrices разбирает таблицу цен из строки вида "gemini-2.5-pro*=1.25/10,gemini-2.5-flash*=0.30/2.50":
+// шаблон модели, цена входа и цена выхода за миллион токенов
+func ParsePrices(s string) ([]Price, error) {
+	var prices []Price
+	for _, item := range strings.Split(s, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		pattern, rates, ok := strings.Cut(item, "=")
+		pattern = strings.ToLower(strings.TrimSpace(pattern))
+		input, output, slash := strings.Cut(rates, "/")
+		if !ok || !slash || pattern == "" {
+			return nil, fmt.Errorf("invalid model price %q: expected pattern=input/output", item)
+		}
+		if _, err := path.Match(pattern, ""); err != nil {
+			return nil, fmt.Errorf("invalid model price pattern %q: %w", pattern, err)
+		}
+		price := Price{Pattern: pattern}
+		var err error
+		if price.Input, err = parseRate(input); err != nil {
+			return nil, fmt.Errorf("invalid input price in %q: %w", item, err)
+		}
+		if price.Output, err = parseRate(output); err != nil {
+			return nil, fmt.Errorf("invalid output price in %q: %w", item, err)
+		}
+		prices = append(prices, price)
+	}
+	return prices, nil
+}
+
+func parseRate(s string) (float64, error) {
+	rate, err := strconv.ParseFloat(strings.TrimSpace(s), 64)
+	if err != nil {
+		return 0, err
+	}
+	if rate < 0 {
+		return 0, fmt.Errorf("negative price %g", rate)
+	}
+	return rate, nil
+}
@@ -0,0 +1,159 @@
+package llm
+
+import (
+	"fmt"
+	"geminiBackend/internal/domain"
+	"path"
+	"strings"
+)
+
+// Route сопоставляет шаблон имени модели поставщику. Шаблон — glob (path.Match),
+// например "gemini-*" или "qwen*"; сравнение без учёта регистра.
+type Route struct {
+	Pattern  string
+	Provider string
+}
+
+// ParseRoutes разбирает маршруты из строки вида "gemma-*=gemini,gemma*=ollama"
+func ParseRoutes(s string) ([]Route, error) {
+	var routes []Route
+	for _, item := range strings.Split(s, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		pattern, provider, ok := strings.Cut(item, "=")
+		pattern = strings.ToLower(strings.TrimSpace(pattern))
+		provider = strings.TrimSpace(provider)
+		if !ok || pattern == "" || provider == "" {
+			return nil, fmt.Errorf("invalid model route %q: expected pattern=provider", item)
+		}
+		if _, err := path.Match(pattern, ""); err != nil {
+			return nil, fmt.Errorf("invalid model route pattern %q: %w", pattern, err)
+		}
+		routes = append(routes, Route{Pattern: pattern, Provider: provider})
+	}
+	return routes, nil
+}
+
+// Registry зарегистрированные поставщики и маршруты моделей к ним.
+// Маршруты проверяются по порядку, побеждает первый совпавший; модель без
+// совпадений уходит поставщику по умолчанию.
+type Registry struct {
+	providers       map[string]Provider
+	order           []string
+	routes          []Route
+	defaultProvider string
+	policies        map[string]Policy
+	retrier         *Retrier
+	prices          []Price
+}
+
+// NewRegistry создаёт реестр. Поставщики из маршрутов должны быть зарегистрированы
+// до первого Resolve; проверить это можно через Validate.
+func NewRegistry(routes []Route, defaultProvider string) *Registry {
+	return &Registry{
+		providers:       make(map[string]Provider),
+		routes:          routes,
+		defaultProvider: defaultProvider,
+		policies:        make(map[string]Policy),
+	}
+}
+
+// Register добавляет поставщика; повторная регистрация имени заменяет прежнего
+func (r *Registry) Register(p Provider) {
+	if _, ok := r.providers[p.Name()]; !ok {
+		r.order = append(r.order, p.Name())
+	}
+	r.providers[p.Name()] = p
+}
+
+// AddPolicy добавляет именованную политику маршрутизации; повторное имя заменяет прежнюю
+func (r *Registry) AddPolicy(p Policy) {
+	r.policies[p.Name] = p
+}
+
+// Policy политика маршрутизации по имени
+func (r *Registry) Policy(name string) (Policy, bool) {
+	p, ok := r.policies[name]
+	return p, ok
+}
+
+// SetRetrier задаёт повторы вызовов, общие для поставщиков реестра; счётчики
+// повторов доступны через Retrier
+func (r *Registry) SetRetrier(retrier *Retrier) {
+	r.retrier = retrier
+}
+
+// Retrier повторы вызовов поставщиков; nil — повторы не настроены
+func (r *Registry) Retrier() *Retrier {
+	return r.retrier
+}
+
+// SetPrices задаёт таблицу цен моделей; шаблоны проверяются по порядку, как маршруты
+func (r *Registry) SetPrices(prices []Price) {
+	r.prices = prices
+}
+
+// Price цены модели по первому совпавшему шаблону; false — модели нет в таблице цен.
+// Префикс "models/" отбрасывается, как в Resolve.
+func (r *Registry) Price(model string) (Price, bool) {
+	name := strings.ToLower(strings.TrimPrefix(model, "models/"))
+	for _, p := range r.prices {
+		if ok, _ := path.Match(p.Pattern, name); ok {
+			return p, true
+		}
+	}
+	return Price{}, false
+}
+
+// Validate проверяет, что все маршруты и поставщик по умолчанию ссылаются на
+// зарегистрированных поставщиков, а у каждой модели политик есть поставщик
+func (r *Registry) Validate() error {
+	for _, route := range r.routes {
+		if _, ok := r.providers[route.Provider]; !ok {
+			return fmt.Errorf("model route %q refers to unknown provider %q", route.Pattern, route.Provider)
+		}
+	}
+	if r.defaultProvider != "" {
+		if _, ok := r.providers[r.defaultProvider]; !ok {
+			return fmt.Errorf("unknown default LLM provider %q", r.defaultProvider)
+		}
+	}
+	for _, p := range r.policies {
+		if err := p.Validate(); err != nil {
+			return err
+		}
+		for _, model := range p.Models {
+			if _, err := r.Resolve(model); err != nil {
+				return fmt.Errorf("routing policy %q: %w", p.Name, err)
+			}
+		}
+	}
+	return nil
+}
+
+// Resolve выбирает поставщика для модели. Префикс "models/" из списков Gemini отбрасывается.
+func (r *Registry) Resolve(model string) (Provider, error) {
+	name := strings.ToLower(strings.TrimPrefix(model, "models/"))
+	for _, route := range r.routes {
+		if ok, _ := path.Match(route.Pattern, name); ok {
+			if p, ok := r.providers[route.Provider]; ok {
+				return p, nil
+			}
+		}
+	}
+	if p, ok := r.providers[r.defaultProvider]; ok {
+		return p, nil
+	}
+	return nil, fmt.Errorf("%w: %s", domain.ErrNoProvider, model)
+}
+
+// Providers зарегистрированные поставщики в порядке регистрации
+func (r *Registry) Providers() []Provider {
+	list := make([]Provider, 0, len(r.order))
+	for _, name := range r.order {
+		list = append(list, r.providers[name])
+	}
+	return list
+}
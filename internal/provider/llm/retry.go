@@ -0,0 +1,168 @@
+package llm
+
+import (
+	"context"
+	"errors"
+	"geminiBackend/pkg/logger"
+	"math/rand/v2"
+	"sync"
+	"time"
+)
+
+// RetryConfig настройки повторов вызовов поставщика
+type RetryConfig struct {
+	MaxAttempts int           // попыток всего, включая первую; 1 — без повторов
+	BaseDelay   time.Duration // пауза перед первым повтором, дальше удваивается
+	MaxDelay    time.Duration // верхняя граница паузы по экспоненте
+	Budget      time.Duration // общее время на все попытки; не дольше дедлайна контекста
+}
+
+// RetryStats счётчики повторов с момента запуска
+type RetryStats struct {
+	Retries     int64            // повторы после ошибок
+	ByOperation map[string]int64 // повторы по операциям (generate, stream, count_tokens, ...)
+	GaveUp      int64            // вызовы, которые не удались после повторов или не уложились в бюджет
+}
+
+// Retrier повторяет вызовы поставщика при временных ошибках (429 и 5xx/недоступность)
+// с экспоненциальной паузой и случайным разбросом. Пауза, указанная сервером
+// (StatusError.RetryAfter), важнее расчётной. Повторы не выходят за бюджет времени
+// и дедлайн контекста запроса.
+type Retrier struct {
+	cfg         RetryConfig
+	mu          sync.Mutex
+	retries     int64
+	byOperation map[string]int64
+	gaveUp      int64
+}
+
+func NewRetrier(cfg RetryConfig) *Retrier {
+	if cfg.MaxAttempts < 1 {
+		cfg.MaxAttempts = 1
+	}
+	return &Retrier{cfg: cfg, byOperation: make(map[string]int64)}
+}
+
+// noRetryError ошибка, после которой повтор небезопасен
+type noRetryError struct{ err error }
+
+func (e *noRetryError) Error() string { return e.err.Error() }
+func (e *noRetryError) Unwrap() error { return e.err }
+
+// NoRetry помечает ошибку как не подлежащую повтору, даже если она временная:
+// например, часть потокового ответа уже отдана клиенту
+func NoRetry(err error) error {
+	if err == nil {
+		return nil
+	}
+	return &noRetryError{err: err}
+}
+
+// Retryable можно ли повторить вызов после ошибки: превышена частота запросов
+// или поставщик временно недоступен. Таймауты не повторяются — время запроса уже
+// израсходовано.
+func Retryable(err error) bool {
+	var noRetry *noRetryError
+	if errors.As(err, &noRetry) {
+		return false
+	}
+	switch ErrorClass(err) {
+	case ErrorClassRateLimit, ErrorClassUnavailable:
+		return true
+	}
+	return false
+}
+
+// Do вызывает fn, повторяя его при временных ошибках. Использовать только для
+// безопасных вызовов: чтение, подсчёт токенов, генерация без отданного клиенту
+// ответа. Возвращает последнюю ошибку fn или ошибку контекста.
+func (r *Retrier) Do(ctx context.Context, op string, fn func() error) error {
+	if r == nil {
+		return unwrapNoRetry(fn())
+	}
+	deadline := time.Now().Add(r.cfg.Budget)
+	if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+		deadline = d
+	}
+	for attempt := 1; ; attempt++ {
+		err := fn()
+		if err == nil || !Retryable(err) || ctx.Err() != nil {
+			return unwrapNoRetry(err)
+		}
+		if attempt >= r.cfg.MaxAttempts {
+			r.giveUp(op, attempt, "attempts exhausted", err)
+			return err
+		}
+		delay := r.backoff(attempt)
+		var statusErr *StatusError
+		if errors.As(err, &statusErr) && statusErr.RetryAfter > 0 {
+			delay = statusErr.RetryAfter
+		}
+		if time.Until(deadline) < delay {
+			r.giveUp(op, attempt, "retry budget exhausted", err)
+			return err
+		}
+		r.retried(op)
+		logger.L.Warn("retrying LLM call", "operation", op, "attempt", attempt+1, "delay", delay, "error", err.Error())
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
+
+// backoff пауза перед повтором attempt: BaseDelay·2^(attempt-1), не больше MaxDelay,
+// случайно в пределах от половины до полного значения
+func (r *Retrier) backoff(attempt int) time.Duration {
+	delay := r.cfg.BaseDelay
+	for i := 1; i < attempt && delay < r.cfg.MaxDelay; i++ {
+		delay *= 2
+	}
+	if r.cfg.MaxDelay > 0 && delay > r.cfg.MaxDelay {
+		delay = r.cfg.MaxDelay
+	}
+	if delay <= 0 {
+		return 0
+	}
+	return delay/2 + rand.N(delay/2+1)
+}
+
+func (r *Retrier) retried(op string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.retries++
+	r.byOperation[op]++
+}
+
+func (r *Retrier) giveUp(op string, attempts int, reason string, err error) {
+	r.mu.Lock()
+	r.gaveUp++
+	r.mu.Unlock()
+	logger.L.Warn("giving up LLM call", "operation", op, "attempts", attempts, "reason", reason, "error", err.Error())
+}
+
+// Stats счётчики повторов; у nil — нулевые
+func (r *Retrier) Stats() RetryStats {
+	stats := RetryStats{ByOperation: make(map[string]int64)}
+	if r == nil {
+		return stats
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	stats.Retries, stats.GaveUp = r.retries, r.gaveUp
+	for k, v := range r.byOperation {
+		stats.ByOperation[k] = v
+	}
+	return stats
+}
+
+func unwrapNoRetry(err error) error {
+	var noRetry *noRetryError
+	if errors.As(err, &noRetry) {
+		return noRetry.err
+	}
+	return err
+}
@@ -0,0 +1,423 @@
+// Package ollama поставщик локальных моделей через HTTP API Ollama (/api/chat, /api/embed, /api/tags).
+package ollama
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"geminiBackend/internal/domain"
+	"geminiBackend/internal/provider/llm"
+	"geminiBackend/internal/provider/transport"
+	"geminiBackend/pkg/logger"
+	"geminiBackend/pkg/tokenizer"
+)
+
+// Message представляет сообщение для Ollama API
+type Message struct {
+	Role    string   `json:"role"`
+	Content string   `json:"content"`
+	Images  []string `json:"images,omitempty"` // base64 изображения для vision-моделей (llava и др.)
+}
+
+// ChatRequest представляет запрос к Ollama API
+type ChatRequest struct {
+	Model    string         `json:"model"`
+	Messages []Message      `json:"messages"`
+	Stream   bool           `json:"stream"`
+	Options  map[string]any `json:"options,omitempty"`
+}
+
+// ChatResponse представляет ответ от Ollama API.
+// При stream=true приходит построчно (NDJSON), счётчики токенов — в последней строке.
+type ChatResponse struct {
+	Message struct {
+		Content string `json:"content"`
+	} `json:"message"`
+	Done            bool   `json:"done"`
+	PromptEvalCount int    `json:"prompt_eval_count,omitempty"`
+	EvalCount       int    `json:"eval_count,omitempty"`
+	Error           string `json:"error,omitempty"`
+}
+
+// TagsResponse ответ /api/tags со списком загруженных моделей
+type TagsResponse struct {
+	Models []struct {
+		Name    string `json:"name"`
+		Details struct {
+			Family        string   `json:"family"`
+			Families      []string `json:"families"`
+			ParameterSize string   `json:"parameter_size"`
+		} `json:"details"`
+	} `json:"models"`
+}
+
+// ProviderName имя поставщика Ollama в маршрутах моделей
+const ProviderName = "ollama"
+
+// defaultModel модель Ollama, если в запросе не указана
+const defaultModel = "qwen2:1.5b"
+
+// Provider поставщик локальных моделей через Ollama
+type Provider struct {
+	endpoint  string
+	maxChars  int
+	maxOutput int // num_predict по умолчанию и верхняя граница max_output_tokens
+	// httpClient без общего таймаута: длительность вызова ограничивает контекст запроса
+	// (дедлайн маршрута, отключение клиента). Соединения берутся из общего пула.
+	httpClient *http.Client
+}
+
+var _ llm.Embedder = (*Provider)(nil)
+var _ llm.Provider = (*Provider)(nil)
+
+// NewProvider создает поставщика для локальной LLM
+func NewProvider(endpoint string, maxChars, maxOutputTokens int) *Provider {
+	return &Provider{
+		endpoint:   endpoint,
+		maxChars:   maxChars,
+		maxOutput:  maxOutputTokens,
+		httpClient: &http.Client{Transport: transport.Pooled},
+	}
+}
+
+func (c *Provider) Name() string { return ProviderName }
+
+// Capabilities лимиты локальной модели. Ollama не умеет возвращать несколько вариантов
+// ответа и не декодирует HEIC.
+func (c *Provider) Capabilities(model string) llm.Capabilities {
+	return llm.Capabilities{
+		MaxInputChars:   c.maxChars,
+		MaxTemperature:  2,
+		MaxOutputTokens: c.maxOutput,
+		MaxCandidates:   1,
+		AttachmentTypes: map[string]bool{"image/jpeg": true, "image/png": true, "image/webp": true},
+		MaxEmbedBatch:   embedBatch,
+	}
+}
+
+// chatRequest собирает запрос к /api/chat. Вложения передаются base64-изображениями
+// последнего сообщения (vision-модели llava и др.).
+func (c *Provider) chatRequest(req llm.Request, stream bool) (ChatRequest, error) {
+	if total := llm.InputChars(req); total > c.maxChars {
+		return ChatRequest{}, &domain.ParamError{Field: "messages", Reason: fmt.Sprintf("too long: %d chars (max %d)", total, c.maxChars)}
+	}
+
+	messages := make([]Message, 0, len(req.Messages)+1)
+	if req.Params.SystemInstruction != "" {
+		messages = append(messages, Message{Role: "system", Content: req.Params.SystemInstruction})
+	}
+	for _, m := range req.Messages {
+		messages = append(messages, Message{Role: m.Role, Content: m.Content})
+	}
+	if len(req.Attachments) > 0 && len(req.Messages) > 0 {
+		last := &messages[len(messages)-1]
+		for _, a := range req.Attachments {
+			last.Images = append(last.Images, base64.StdEncoding.EncodeToString(a.Data))
+		}
+	}
+
+	return ChatRequest{
+		Model:    modelName(req.Model),
+		Stream:   stream,
+		Messages: messages,
+		Options:  c.options(req.Params),
+	}, nil
+}
+
+// options переводит параметры генерации в options Ollama
+func (c *Provider) options(params domain.GenerationParams) map[string]any {
+	opts := map[string]any{
+		"num_predict": c.maxOutput, // макс токенов ответа
+	}
+	if params.Temperature != nil {
+		opts["temperature"] = *params.Temperature
+	}
+	if params.TopP != nil {
+		opts["top_p"] = *params.TopP
+	}
+	if params.TopK != nil {
+		opts["top_k"] = *params.TopK
+	}
+	if params.MaxOutputTokens != nil {
+		opts["num_predict"] = *params.MaxOutputTokens
+	}
+	if len(params.StopSequences) > 0 {
+		opts["stop"] = params.StopSequences
+	}
+	if params.Seed != nil {
+		opts["seed"] = *params.Seed
+	}
+	return opts
+}
+
+// Generate отправляет запрос в /api/chat без стриминга и возвращает ответ модели
+func (c *Provider) Generate(ctx context.Context, req llm.Request) (_ *llm.Response, err error) {
+	defer func() { err = llm.Classify(ProviderName, err) }()
+	chatReq, err := c.chatRequest(req, false)
+	if err != nil {
+		return nil, err
+	}
+	body, err := json.Marshal(chatReq)
+	if err != nil {
+		logger.L.Error("failed to marshal local LLM request", "error", err.Error())
+		return nil, err
+	}
+
+	httpReq, err := http.NewRequestWithContext(ctx, "POST", c.endpoint+"/api/chat", bytes.NewReader(body))
+	if err != nil {
+		logger.L.Error("failed to create local LLM HTTP request", "error", err.Error())
+		return nil, err
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+
+	resp, err := c.httpClient.Do(httpReq)
+	if err != nil {
+		logger.L.Error("failed to call local LLM", "error", err.Error(), "endpoint", c.endpoint)
+		return nil, fmt.Errorf("local LLM unavailable: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, statusError(resp)
+	}
+
+	var ollamaResp ChatResponse
+	if err := json.NewDecoder(resp.Body).Decode(&ollamaResp); err != nil {
+		logger.L.Error("failed to decode local LLM response", "error", err.Error())
+		return nil, err
+	}
+
+	return &llm.Response{
+		Texts: []string{strings.TrimSpace(ollamaResp.Message.Content)},
+		Usage: responseUsage(chatReq.Model, ollamaResp),
+	}, nil
+}
+
+// Stream генерирует текст потоково: Ollama отдаёт NDJSON, каждая строка —
+// очередной фрагмент ответа. Отмена ctx (например, клиент отключился) закрывает соединение.
+func (c *Provider) Stream(ctx context.Context, req llm.Request, onDelta func(string) error) (_ domain.AIUsage, err error) {
+	defer func() { err = llm.Classify(ProviderName, err) }()
+	usage := domain.AIUsage{Model: modelName(req.Model)}
+	chatReq, err := c.chatRequest(req, true)
+	if err != nil {
+		return usage, err
+	}
+	body, err := json.Marshal(chatReq)
+	if err != nil {
+		logger.L.Error("failed to marshal local LLM request", "error", err.Error())
+		return usage, err
+	}
+	httpReq, err := http.NewRequestWithContext(ctx, "POST", c.endpoint+"/api/chat", bytes.NewReader(body))
+	if err != nil {
+		logger.L.Error("failed to create local LLM HTTP request", "error", err.Error())
+		return usage, err
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+
+	resp, err := c.httpClient.Do(httpReq)
+	if err != nil {
+		logger.L.Error("failed to call local LLM", "error", err.Error(), "endpoint", c.endpoint)
+		return usage, fmt.Errorf("local LLM unavailable: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return usage, statusError(resp)
+	}
+
+	scanner := bufio.NewScanner(resp.Body)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+		var chunk ChatResponse
+		if err := json.Unmarshal(line, &chunk); err != nil {
+			logger.L.Error("failed to decode local LLM stream chunk", "error", err.Error())
+			return usage, err
+		}
+		if chunk.Error != "" {
+			return usage, &llm.StatusError{Code: http.StatusInternalServerError, Message: chunk.Error, Err: fmt.Errorf("local LLM error: %s", chunk.Error)}
+		}
+		if chunk.Message.Content != "" {
+			if err := onDelta(chunk.Message.Content); err != nil {
+				return usage, err
+			}
+		}
+		if chunk.Done {
+			return responseUsage(usage.Model, chunk), nil
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return usage, err
+	}
+	if err := ctx.Err(); err != nil {
+		return usage, err
+	}
+	return usage, errors.New("local LLM stream ended without done")
+}
+
+// CountTokens у Ollama нет API подсчёта токенов — возвращается оценка приблизительным
+// токенизатором по системному промпту и сообщениям
+func (c *Provider) CountTokens(ctx context.Context, req llm.Request) (int, error) {
+	total := tokenizer.Count(req.Params.SystemInstruction)
+	for _, m := range req.Messages {
+		total += tokenizer.Count(m.Content)
+	}
+	return total, nil
+}
+
+// EmbedRequest запрос к /api/embed
+type EmbedRequest struct {
+	Model      string   `json:"model"`
+	Input      []string `json:"input"`
+	Dimensions int      `json:"dimensions,omitempty"`
+}
+
+// EmbedResponse ответ /api/embed
+type EmbedResponse struct {
+	Embeddings      [][]float32 `json:"embeddings"`
+	PromptEvalCount int         `json:"prompt_eval_count,omitempty"`
+}
+
+// embedBatch входов в одном запросе /api/embed: Ollama считает их одним пакетом в памяти
+const embedBatch = 64
+
+// nomicTaskPrefixes префиксы назначения, которые ожидают модели nomic-embed-text
+var nomicTaskPrefixes = map[string]string{
+	domain.EmbedTaskRetrievalQuery:    "search_query: ",
+	domain.EmbedTaskRetrievalDocument: "search_document: ",
+	domain.EmbedTaskQuestionAnswering: "search_query: ",
+	domain.EmbedTaskClassification:    "classification: ",
+	domain.EmbedTaskClustering:        "clustering: ",
+}
+
+// Embed строит эмбеддинги через /api/embed (nomic-embed-text и др.). Назначение
+// передаётся только моделям nomic-embed — префиксом каждого входа; остальные его не различают.
+func (c *Provider) Embed(ctx context.Context, req llm.EmbedRequest) (_ *llm.EmbedResponse, err error) {
+	defer func() { err = llm.Classify(ProviderName, err) }()
+	model := modelName(req.Model)
+	inputs := req.Inputs
+	if prefix := nomicTaskPrefixes[req.TaskType]; prefix != "" && strings.HasPrefix(model, "nomic-embed") {
+		inputs = make([]string, len(req.Inputs))
+		for i, text := range req.Inputs {
+			inputs[i] = prefix + text
+		}
+	}
+	body, err := json.Marshal(EmbedRequest{Model: model, Input: inputs, Dimensions: req.Dimensions})
+	if err != nil {
+		return nil, err
+	}
+
+	httpReq, err := http.NewRequestWithContext(ctx, "POST", c.endpoint+"/api/embed", bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+
+	resp, err := c.httpClient.Do(httpReq)
+	if err != nil {
+		logger.L.Error("failed to call local LLM", "error", err.Error(), "endpoint", c.endpoint)
+		return nil, fmt.Errorf("local LLM unavailable: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, statusError(resp)
+	}
+
+	var embedResp EmbedResponse
+	if err := json.NewDecoder(resp.Body).Decode(&embedResp); err != nil {
+		logger.L.Error("failed to decode local LLM response", "error", err.Error())
+		return nil, err
+	}
+	return &llm.EmbedResponse{
+		Vectors: embedResp.Embeddings,
+		Usage:   domain.AIUsage{Model: model, PromptTokens: embedResp.PromptEvalCount, TotalTokens: embedResp.PromptEvalCount},
+	}, nil
+}
+
+// ListModels модели, загруженные в Ollama (/api/tags). Модели с проектором CLIP
+// (llava, moondream) принимают изображения и помечаются как multimodal.
+func (c *Provider) ListModels(ctx context.Context, apiKey string) (_ []domain.ModelInfo, err error) {
+	defer func() { err = llm.Classify(ProviderName, err) }()
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	httpReq, err := http.NewRequestWithContext(ctx, "GET", c.endpoint+"/api/tags", nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := c.httpClient.Do(httpReq)
+	if err != nil {
+		return nil, fmt.Errorf("local LLM unavailable: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, statusError(resp)
+	}
+
+	var tags TagsResponse
+	if err := json.NewDecoder(resp.Body).Decode(&tags); err != nil {
+		return nil, err
+	}
+	models := make([]domain.ModelInfo, 0, len(tags.Models))
+	for _, m := range tags.Models {
+		category := "text"
+		for _, family := range m.Details.Families {
+			if family == "clip" {
+				category = "multimodal"
+			}
+		}
+		description := strings.TrimSpace(m.Details.Family + " " + m.Details.ParameterSize)
+		models = append(models, domain.ModelInfo{
+			Name:             m.Name,
+			DisplayName:      m.Name,
+			Description:      description,
+			SupportedActions: []string{"generateContent"},
+			OutputTokenLimit: int32(c.maxOutput),
+			Category:         category,
+			IsAvailable:      true,
+			Provider:         ProviderName,
+		})
+	}
+	return models, nil
+}
+
+func responseUsage(model string, resp ChatResponse) domain.AIUsage {
+	return domain.AIUsage{
+		Model:            model,
+		PromptTokens:     resp.PromptEvalCount,
+		CompletionTokens: resp.EvalCount,
+		TotalTokens:      resp.PromptEvalCount + resp.EvalCount,
+	}
+}
+
+func modelName(model string) string {
+	if model == "" {
+		return defaultModel
+	}
+	return model
+}
+
+// statusError ошибка ответа Ollama с кодом не 200; текст ошибки Ollama
+// ({"error": "model \"x\" not found, try pulling it first"}) можно показать клиенту
+func statusError(resp *http.Response) error {
+	bodyBytes, _ := io.ReadAll(io.LimitReader(resp.Body, 64*1024))
+	logger.L.Error("local LLM returned error", "status", resp.StatusCode, "body", string(bodyBytes))
+	var errResp struct {
+		Error string `json:"error"`
+	}
+	json.Unmarshal(bodyBytes, &errResp)
+	return &llm.StatusError{Code: resp.StatusCode, Message: errResp.Error, Err: fmt.Errorf("local LLM error: status %d", resp.StatusCode)}
+}
@@ -0,0 +1,62 @@
+package ollama
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"geminiBackend/internal/domain"
+	"geminiBackend/internal/provider/llm"
+)
+
+// BenchmarkOllamaGenerate сравнивает вызов Ollama с новым http.Client и транспортом на
+// каждый запрос (новое TCP-соединение на вызов) и с общим пулом соединений. Запросы
+// идут параллельно, имитация /api/chat отвечает сразу.
+//
+//	go test ./internal/provider/ollama/ -run '^$' -bench . -benchmem
+func BenchmarkOllamaGenerate(b *testing.B) {
+	fake := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"message":{"role":"assistant","content":"ok"},"done":true,"prompt_eval_count":1,"eval_count":1}`)
+	}))
+	defer fake.Close()
+
+	req := llm.Request{
+		Model:    "qwen2.5:7b",
+		Messages: []domain.ChatMessage{{Role: domain.ChatRoleUser, Content: "hi"}},
+	}
+	ctx := context.Background()
+
+	b.Run("new_client", func(b *testing.B) {
+		b.ReportAllocs()
+		b.SetParallelism(8)
+		b.RunParallel(func(pb *testing.PB) {
+			for pb.Next() {
+				httpTransport := http.DefaultTransport.(*http.Transport).Clone()
+				provider := NewProvider(fake.URL, 10000, 256)
+				provider.httpClient = &http.Client{Transport: httpTransport}
+				_, err := provider.Generate(ctx, req)
+				httpTransport.CloseIdleConnections()
+				if err != nil {
+					b.Error(err)
+					return
+				}
+			}
+		})
+	})
+
+	b.Run("pooled", func(b *testing.B) {
+		provider := NewProvider(fake.URL, 10000, 256)
+		b.ReportAllocs()
+		b.SetParallelism(8)
+		b.RunParallel(func(pb *testing.PB) {
+			for pb.Next() {
+				if _, err := provider.Generate(ctx, req); err != nil {
+					b.Error(err)
+					return
+				}
+			}
+		})
+	})
+}
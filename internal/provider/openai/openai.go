@@ -0,0 +1,391 @@
+// Package openai поставщик моделей для серверов с OpenAI-совместимым API
+// (llama.cpp server, vLLM, LM Studio и др.).
+package openai
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"path"
+	"strings"
+	"time"
+
+	"geminiBackend/config"
+	"geminiBackend/internal/domain"
+	"geminiBackend/internal/provider/llm"
+	"geminiBackend/pkg/logger"
+)
+
+// Provider поставщик моделей одного OpenAI-совместимого сервера
+type Provider struct {
+	name       string
+	baseURL    string
+	apiKey     string
+	models     []string // модели сервера из конфига (допускаются glob-шаблоны)
+	maxChars   int
+	maxOutput  int // max_tokens по умолчанию и верхняя граница max_output_tokens
+	httpClient *http.Client
+	// streamClient без общего таймаута: длительность потока ограничивает контекст запроса
+	streamClient *http.Client
+}
+
+var _ llm.Embedder = (*Provider)(nil)
+var _ llm.Provider = (*Provider)(nil)
+
+// NewProvider создаёт поставщика для сервера из конфига
+func NewProvider(upstream config.OpenAIUpstream) *Provider {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.DialContext = (&net.Dialer{Timeout: upstream.ConnectTimeout, KeepAlive: 30 * time.Second}).DialContext
+	return &Provider{
+		name:         upstream.Name,
+		baseURL:      strings.TrimRight(upstream.BaseURL, "/"),
+		apiKey:       upstream.APIKey,
+		models:       upstream.Models,
+		maxChars:     upstream.MaxChars,
+		maxOutput:    upstream.MaxOutputTokens,
+		httpClient:   &http.Client{Transport: transport, Timeout: upstream.Timeout},
+		streamClient: &http.Client{Transport: transport},
+	}
+}
+
+func (p *Provider) Name() string { return p.name }
+
+// Routes маршруты моделей сервера из конфига; их нужно ставить перед LLM_ROUTES
+func (p *Provider) Routes() []llm.Route {
+	routes := make([]llm.Route, 0, len(p.models))
+	for _, m := range p.models {
+		routes = append(routes, llm.Route{Pattern: strings.ToLower(m), Provider: p.name})
+	}
+	return routes
+}
+
+// Capabilities лимиты сервера из конфига. Несколько вариантов ответа поддерживают
+// не все серверы (llama.cpp — нет), поэтому n ограничен одним.
+func (p *Provider) Capabilities(model string) llm.Capabilities {
+	return llm.Capabilities{
+		MaxInputChars:    p.maxChars,
+		MaxTemperature:   2,
+		MaxOutputTokens:  p.maxOutput,
+		MaxCandidates:    1,
+		MaxStopSequences: 4,
+		AttachmentTypes:  map[string]bool{"image/jpeg": true, "image/png": true, "image/webp": true},
+	}
+}
+
+// chatRequest собирает запрос к /chat/completions. Вложения передаются data: URL
+// в последнем сообщении (мультимодальные модели vLLM, llama.cpp с mmproj).
+func (p *Provider) chatRequest(req llm.Request, stream bool) (domain.OpenAIChatRequest, error) {
+	if total := llm.InputChars(req); total > p.maxChars {
+		return domain.OpenAIChatRequest{}, &domain.ParamError{Field: "messages", Reason: fmt.Sprintf("too long: %d chars (max %d)", total, p.maxChars)}
+	}
+
+	messages := make([]domain.OpenAIMessage, 0, len(req.Messages)+1)
+	if req.Params.SystemInstruction != "" {
+		messages = append(messages, domain.OpenAIMessage{Role: "system", Content: domain.OpenAIContent{Text: req.Params.SystemInstruction}})
+	}
+	for _, m := range req.Messages {
+		messages = append(messages, domain.OpenAIMessage{Role: m.Role, Content: domain.OpenAIContent{Text: m.Content}})
+	}
+	if len(req.Attachments) > 0 && len(req.Messages) > 0 {
+		last := &messages[len(messages)-1]
+		for _, a := range req.Attachments {
+			mimeType := a.MIMEType
+			if mimeType == "" {
+				mimeType = http.DetectContentType(a.Data)
+			}
+			last.Content.ImageURLs = append(last.Content.ImageURLs, "data:"+mimeType+";base64,"+base64.StdEncoding.EncodeToString(a.Data))
+		}
+	}
+
+	maxTokens := p.maxOutput
+	if req.Params.MaxOutputTokens != nil {
+		maxTokens = *req.Params.MaxOutputTokens
+	}
+	chatReq := domain.OpenAIChatRequest{
+		Model:       p.modelName(req.Model),
+		Messages:    messages,
+		Stream:      stream,
+		Temperature: req.Params.Temperature,
+		TopP:        req.Params.TopP,
+		TopK:        req.Params.TopK,
+		MaxTokens:   &maxTokens,
+		Stop:        req.Params.StopSequences,
+		Seed:        req.Params.Seed,
+	}
+	if stream {
+		chatReq.StreamOptions = &domain.OpenAIStreamOptions{IncludeUsage: true}
+	}
+	return chatReq, nil
+}
+
+// Generate отправляет запрос в /chat/completions без стриминга
+func (p *Provider) Generate(ctx context.Context, req llm.Request) (_ *llm.Response, err error) {
+	defer func() { err = llm.Classify(p.name, err) }()
+	chatReq, err := p.chatRequest(req, false)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := p.post(ctx, p.httpClient, "/chat/completions", chatReq)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var chatResp domain.OpenAIChatResponse
+	if err := json.NewDecoder(resp.Body).Decode(&chatResp); err != nil {
+		logger.L.Error("failed to decode upstream response", "upstream", p.name, "error", err.Error())
+		return nil, err
+	}
+	if len(chatResp.Choices) == 0 || chatResp.Choices[0].Message == nil {
+		return nil, fmt.Errorf("upstream %s returned no choices", p.name)
+	}
+	if reason := chatResp.Choices[0].FinishReason; reason != nil && *reason == "content_filter" {
+		return nil, fmt.Errorf("%w: content_filter", llm.ErrBlocked)
+	}
+	text := strings.TrimSpace(chatResp.Choices[0].Message.Content)
+	return &llm.Response{
+		Texts: []string{text},
+		Usage: p.usage(chatReq.Model, chatResp.Usage, req, text),
+	}, nil
+}
+
+// Stream генерирует текст потоково: сервер отдаёт SSE с чанками chat.completion.chunk
+// и завершающим "data: [DONE]". Отмена ctx закрывает соединение.
+func (p *Provider) Stream(ctx context.Context, req llm.Request, onDelta func(string) error) (_ domain.AIUsage, err error) {
+	defer func() { err = llm.Classify(p.name, err) }()
+	usage := domain.AIUsage{Model: p.modelName(req.Model)}
+	chatReq, err := p.chatRequest(req, true)
+	if err != nil {
+		return usage, err
+	}
+	resp, err := p.post(ctx, p.streamClient, "/chat/completions", chatReq)
+	if err != nil {
+		return usage, err
+	}
+	defer resp.Body.Close()
+
+	var (
+		text     strings.Builder
+		reported *domain.OpenAIUsage
+	)
+	scanner := bufio.NewScanner(resp.Body)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		data, ok := strings.CutPrefix(strings.TrimSpace(scanner.Text()), "data:")
+		if !ok {
+			continue
+		}
+		data = strings.TrimSpace(data)
+		if data == "[DONE]" {
+			return p.usage(usage.Model, reported, req, text.String()), nil
+		}
+		var chunk struct {
+			domain.OpenAIChatResponse
+			Error *domain.OpenAIError `json:"error"`
+		}
+		if err := json.Unmarshal([]byte(data), &chunk); err != nil {
+			logger.L.Error("failed to decode upstream stream chunk", "upstream", p.name, "error", err.Error())
+			return usage, err
+		}
+		if chunk.Error != nil {
+			return usage, &llm.StatusError{Code: http.StatusInternalServerError, Message: chunk.Error.Message,
+				Err: fmt.Errorf("upstream %s error: %s", p.name, chunk.Error.Message)}
+		}
+		if chunk.Usage != nil {
+			reported = chunk.Usage
+		}
+		for _, choice := range chunk.Choices {
+			if choice.FinishReason != nil && *choice.FinishReason == "content_filter" {
+				return usage, fmt.Errorf("%w: content_filter", llm.ErrBlocked)
+			}
+			if choice.Delta == nil || choice.Delta.Content == "" {
+				continue
+			}
+			text.WriteString(choice.Delta.Content)
+			if err := onDelta(choice.Delta.Content); err != nil {
+				return usage, err
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return usage, err
+	}
+	if err := ctx.Err(); err != nil {
+		return usage, err
+	}
+	return usage, fmt.Errorf("upstream %s stream ended without [DONE]", p.name)
+}
+
+// CountTokens в OpenAI API нет подсчёта токенов — возвращается оценка по длине текста
+func (p *Provider) CountTokens(ctx context.Context, req llm.Request) (int, error) {
+	return llm.EstimateTokens(req), nil
+}
+
+// embeddingResponse ответ /embeddings; вектор запрашивается массивом чисел
+type embeddingResponse struct {
+	Data []struct {
+		Index     int       `json:"index"`
+		Embedding []float32 `json:"embedding"`
+	} `json:"data"`
+	Usage domain.OpenAIUsage `json:"usage"`
+}
+
+// Embed строит эмбеддинги через /embeddings
+func (p *Provider) Embed(ctx context.Context, req llm.EmbedRequest) (_ *llm.EmbedResponse, err error) {
+	defer func() { err = llm.Classify(p.name, err) }()
+	body := domain.OpenAIEmbeddingRequest{Model: p.modelName(req.Model), Input: req.Inputs, EncodingFormat: "float"}
+	if req.Dimensions > 0 {
+		body.Dimensions = &req.Dimensions
+	}
+	resp, err := p.post(ctx, p.httpClient, "/embeddings", body)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var embedResp embeddingResponse
+	if err := json.NewDecoder(resp.Body).Decode(&embedResp); err != nil {
+		logger.L.Error("failed to decode upstream response", "upstream", p.name, "error", err.Error())
+		return nil, err
+	}
+	vectors := make([][]float32, len(req.Inputs))
+	for _, d := range embedResp.Data {
+		if d.Index < 0 || d.Index >= len(vectors) {
+			return nil, fmt.Errorf("upstream %s returned embedding for unknown input %d", p.name, d.Index)
+		}
+		vectors[d.Index] = d.Embedding
+	}
+	promptTokens := embedResp.Usage.PromptTokens
+	if promptTokens == 0 {
+		promptTokens = llm.EstimateTextTokens(req.Inputs...)
+	}
+	return &llm.EmbedResponse{
+		Vectors: vectors,
+		Usage:   domain.AIUsage{Model: body.Model, PromptTokens: promptTokens, TotalTokens: promptTokens},
+	}, nil
+}
+
+// ListModels модели сервера (/models). Если в конфиге перечислены модели,
+// возвращаются только подходящие под них.
+func (p *Provider) ListModels(ctx context.Context, apiKey string) (_ []domain.ModelInfo, err error) {
+	defer func() { err = llm.Classify(p.name, err) }()
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	httpReq, err := http.NewRequestWithContext(ctx, "GET", p.baseURL+"/models", nil)
+	if err != nil {
+		return nil, err
+	}
+	p.authorize(httpReq)
+	resp, err := p.httpClient.Do(httpReq)
+	if err != nil {
+		return nil, fmt.Errorf("upstream %s unavailable: %w", p.name, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("upstream %s error: status %d", p.name, resp.StatusCode)
+	}
+
+	var list domain.OpenAIModelList
+	if err := json.NewDecoder(resp.Body).Decode(&list); err != nil {
+		return nil, err
+	}
+	models := make([]domain.ModelInfo, 0, len(list.Data))
+	for _, m := range list.Data {
+		if len(p.models) > 0 && p.configuredModel(m.ID) == "" {
+			continue
+		}
+		models = append(models, domain.ModelInfo{
+			Name:             m.ID,
+			DisplayName:      m.ID,
+			Description:      strings.TrimSpace(p.name + " " + m.OwnedBy),
+			SupportedActions: []string{"generateContent"},
+			InputTokenLimit:  int32(p.maxChars / 4),
+			OutputTokenLimit: int32(p.maxOutput),
+			Category:         "text",
+			IsAvailable:      true,
+			Provider:         p.name,
+		})
+	}
+	return models, nil
+}
+
+// post отправляет JSON-запрос серверу; ответ со статусом не 200 превращается в ошибку
+// с сообщением сервера
+func (p *Provider) post(ctx context.Context, client *http.Client, endpoint string, payload any) (*http.Response, error) {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		logger.L.Error("failed to marshal upstream request", "upstream", p.name, "error", err.Error())
+		return nil, err
+	}
+	httpReq, err := http.NewRequestWithContext(ctx, "POST", p.baseURL+endpoint, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+	p.authorize(httpReq)
+
+	resp, err := client.Do(httpReq)
+	if err != nil {
+		logger.L.Error("failed to call upstream", "upstream", p.name, "error", err.Error(), "endpoint", p.baseURL)
+		return nil, fmt.Errorf("upstream %s unavailable: %w", p.name, err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		defer resp.Body.Close()
+		bodyBytes, _ := io.ReadAll(io.LimitReader(resp.Body, 64*1024))
+		logger.L.Error("upstream returned error", "upstream", p.name, "status", resp.StatusCode, "body", string(bodyBytes))
+		err := fmt.Errorf("upstream %s error: status %d", p.name, resp.StatusCode)
+		var errResp domain.OpenAIErrorResponse
+		if json.Unmarshal(bodyBytes, &errResp) == nil && errResp.Error.Message != "" {
+			err = fmt.Errorf("upstream %s error: status %d: %s", p.name, resp.StatusCode, errResp.Error.Message)
+		}
+		return nil, &llm.StatusError{Code: resp.StatusCode, Message: errResp.Error.Message, Err: err}
+	}
+	return resp, nil
+}
+
+func (p *Provider) authorize(req *http.Request) {
+	if p.apiKey != "" {
+		req.Header.Set("Authorization", "Bearer "+p.apiKey)
+	}
+}
+
+// usage расход токенов из ответа сервера; если сервер его не вернул — оценка по длине текста
+func (p *Provider) usage(model string, reported *domain.OpenAIUsage, req llm.Request, text string) domain.AIUsage {
+	if reported != nil && reported.TotalTokens > 0 {
+		return domain.AIUsage{
+			Model:            model,
+			PromptTokens:     reported.PromptTokens,
+			CompletionTokens: reported.CompletionTokens,
+			TotalTokens:      reported.TotalTokens,
+		}
+	}
+	prompt, completion := llm.EstimateTokens(req), llm.EstimateTextTokens(text)
+	return domain.AIUsage{Model: model, PromptTokens: prompt, CompletionTokens: completion, TotalTokens: prompt + completion}
+}
+
+// modelName имя модели для сервера: совпавшая без учёта регистра модель из конфига
+// пишется так, как в конфиге (vLLM различает регистр: Qwen/Qwen2.5-7B-Instruct)
+func (p *Provider) modelName(model string) string {
+	if configured := p.configuredModel(model); configured != "" && !strings.ContainsAny(configured, "*?[") {
+		return configured
+	}
+	return model
+}
+
+// configuredModel шаблон из конфига, под который подходит модель; пусто — ни один
+func (p *Provider) configuredModel(model string) string {
+	name := strings.ToLower(model)
+	for _, pattern := range p.models {
+		if ok, _ := path.Match(strings.ToLower(pattern), name); ok {
+			return pattern
+		}
+	}
+	return ""
+}
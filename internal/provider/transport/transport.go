@@ -0,0 +1,22 @@
+// Package transport общий HTTP-транспорт поставщиков моделей.
+package transport
+
+import (
+	"net"
+	"net/http"
+	"time"
+)
+
+// Pooled общий транспорт вызовов Gemini и Ollama: соединения к одному хосту
+// переиспользуются между запросами и пользователями, без повторных TCP/TLS-рукопожатий
+var Pooled = NewPooled()
+
+// NewPooled транспорт с пулом соединений, рассчитанным на параллельные запросы к одному хосту
+func NewPooled() *http.Transport {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.DialContext = (&net.Dialer{Timeout: 10 * time.Second, KeepAlive: 30 * time.Second}).DialContext
+	transport.MaxIdleConns = 256
+	transport.MaxIdleConnsPerHost = 64 // по умолчанию 2 — при параллельных запросах соединения закрываются
+	transport.IdleConnTimeout = 90 * time.Second
+	return transport
+}
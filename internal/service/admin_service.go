@@ -0,0 +1,143 @@
+package service
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"geminiBackend/internal/domain"
+	"geminiBackend/internal/provider/db"
+	"geminiBackend/pkg/keyring"
+	"geminiBackend/pkg/logger"
+)
+
+// AdminService управление пользователями; каждое изменение пишется в журнал admin_audit
+type AdminService struct {
+	db   *sql.DB
+	keys *keyring.Keyring
+}
+
+func NewAdminService(database *sql.DB, keys *keyring.Keyring) *AdminService {
+	return &AdminService{db: database, keys: keys}
+}
+
+// CreateUser создаёт пользователя (используется admin CLI для первичной настройки)
+func (s *AdminService) CreateUser(actorTgID, tgID int, username string, isAdmin bool) (*domain.UserSummary, error) {
+	if tgID <= 0 || username == "" {
+		return nil, domain.ErrInvalidInput
+	}
+	users := db.NewUsersProvider(s.db, s.keys)
+	if _, err := users.GetUserSummary(tgID); err == nil {
+		return nil, domain.ErrUserExists
+	}
+	err := s.audited(actorTgID, domain.AuditCreateUser, tgID, map[string]any{"username": username, "is_admin": isAdmin}, func(tx db.AdminTx) error {
+		if err := tx.UpsertTelegramUser(tgID, username); err != nil {
+			return err
+		}
+		if isAdmin {
+			return tx.SetAdmin(tgID, true)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return s.GetUser(tgID)
+}
+
+func (s *AdminService) ListUsers(filter domain.UserFilter) ([]domain.UserSummary, int, error) {
+	return db.NewUsersProvider(s.db, s.keys).ListUsers(filter)
+}
+
+func (s *AdminService) GetUser(tgID int) (*domain.UserSummary, error) {
+	user, err := db.NewUsersProvider(s.db, s.keys).GetUserSummary(tgID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, domain.ErrUserNotFound
+	}
+	return user, err
+}
+
+// SetAdmin выдаёт или снимает права администратора. Роль в токенах обновляется сразу (см. AuthService.CheckSession).
+func (s *AdminService) SetAdmin(actorTgID, tgID int, isAdmin bool) (*domain.UserSummary, error) {
+	if _, err := s.checkTarget(actorTgID, tgID); err != nil {
+		return nil, err
+	}
+	err := s.audited(actorTgID, domain.AuditSetAdmin, tgID, map[string]any{"is_admin": isAdmin}, func(tx db.AdminTx) error {
+		return tx.SetAdmin(tgID, isAdmin)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return s.GetUser(tgID)
+}
+
+// SetActive активирует или деактивирует пользователя; при деактивации все его сессии отзываются
+func (s *AdminService) SetActive(actorTgID, tgID int, isActive bool) (*domain.UserSummary, error) {
+	if _, err := s.checkTarget(actorTgID, tgID); err != nil {
+		return nil, err
+	}
+	err := s.audited(actorTgID, domain.AuditSetActive, tgID, map[string]any{"is_active": isActive}, func(tx db.AdminTx) error {
+		if err := tx.SetActive(tgID, isActive); err != nil {
+			return err
+		}
+		if !isActive {
+			return tx.RevokeUserSessions(tgID)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return s.GetUser(tgID)
+}
+
+// ClearKey удаляет сохранённый Gemini ключ пользователя
+func (s *AdminService) ClearKey(actorTgID, tgID int) (*domain.UserSummary, error) {
+	if _, err := s.GetUser(tgID); err != nil {
+		return nil, err
+	}
+	if err := s.audited(actorTgID, domain.AuditClearKey, tgID, nil, func(tx db.AdminTx) error {
+		return tx.ClearGeminiAPIKey(tgID)
+	}); err != nil {
+		return nil, err
+	}
+	return s.GetUser(tgID)
+}
+
+// DeleteUser удаляет пользователя и его сессии
+func (s *AdminService) DeleteUser(actorTgID, tgID int) error {
+	user, err := s.checkTarget(actorTgID, tgID)
+	if err != nil {
+		return err
+	}
+	return s.audited(actorTgID, domain.AuditDeleteUser, tgID, map[string]any{"username": user.Username}, func(tx db.AdminTx) error {
+		return tx.DeleteUser(tgID)
+	})
+}
+
+func (s *AdminService) ListAudit(actorTgID, targetTgID, limit, offset int) ([]domain.AuditEntry, int, error) {
+	return db.NewAuditProvider(s.db).List(actorTgID, targetTgID, limit, offset)
+}
+
+// checkTarget проверяет, что пользователь существует и это не сам администратор
+func (s *AdminService) checkTarget(actorTgID, tgID int) (*domain.UserSummary, error) {
+	if actorTgID == tgID {
+		return nil, domain.ErrSelfModification
+	}
+	return s.GetUser(tgID)
+}
+
+// audited выполняет изменение fn и запись в журнал admin_audit в одной транзакции;
+// если журнал записать не удалось, изменение откатывается и возвращается ошибка
+func (s *AdminService) audited(actorTgID int, action string, targetTgID int, details map[string]any, fn func(tx db.AdminTx) error) error {
+	entry := domain.AuditEntry{ActorTgID: actorTgID, Action: action, TargetTgID: targetTgID}
+	if details != nil {
+		b, _ := json.Marshal(details)
+		entry.Details = string(b)
+	}
+	if err := db.NewAuditProvider(s.db).Apply(entry, fn); err != nil {
+		logger.L.Error("admin action failed", "action", action, "actor", actorTgID, "target", targetTgID, "err", err)
+		return err
+	}
+	logger.L.Info("admin action", "action", action, "actor", actorTgID, "target", targetTgID)
+	return nil
+}
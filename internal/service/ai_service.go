@@ -1,49 +1,210 @@
 package service
 
 import (
+	"context"
 	"geminiBackend/config"
 	"geminiBackend/internal/domain"
-	"geminiBackend/internal/provider/gemini"
+	"geminiBackend/internal/provider/llm"
+	"geminiBackend/pkg/logger"
+	"unicode/utf8"
 )
 
 type AIService struct {
-	cfg *config.Config
+	cfg   *config.Config
+	llm   *llm.Registry
+	stats *aiStats
 }
 
-func NewAIService(cfg *config.Config) *AIService {
-	return &AIService{cfg: cfg}
+func NewAIService(cfg *config.Config, registry *llm.Registry) *AIService {
+	return &AIService{cfg: cfg, llm: registry, stats: newAIStats()}
 }
 
-func (s *AIService) AskText(model, apiKey, prompt string) (string, error) {
-	// Определяем, локальная это модель или облачная по названию
-	if gemini.IsLocalModel(model) {
-		localClient := gemini.NewLocalLLMClient(
-			s.cfg.LocalLLMEndpoint,
-			model,
-			s.cfg.LocalLLMMaxChars,
-		)
-		return localClient.GenerateTextChunked(prompt, s.cfg.LocalLLMMaxChars)
+// AskText генерирует текст, перебирая модели политики (см. TextPolicy и runPolicy).
+// Параметры генерации проверяются по лимитам каждой модели; недопустимое для первой
+// модели значение возвращается как *domain.ParamError. В ответе — модель, которая ответила,
+// и части длинного текста, которые не удалось обработать. Генерация прерывается при отмене ctx.
+func (s *AIService) AskText(ctx context.Context, policy llm.Policy, apiKey, prompt string, params domain.GenerationParams) (domain.AITextResponse, error) {
+	var texts []string
+	var failed []domain.ChunkError
+	model, fallbacks, err := s.runPolicy(ctx, policy, apiKey, params, false, nil, func(provider llm.Provider, model string) error {
+		var err error
+		texts, failed, err = s.generateText(ctx, provider, llm.UserPrompt(model, apiKey, prompt, params))
+		return err
+	})
+	if err != nil {
+		return domain.AITextResponse{}, err
+	}
+	resp := domain.AITextResponse{Model: model, Fallbacks: fallbacks, FailedChunks: failed}
+	if len(texts) > 0 {
+		resp.Text = texts[0]
+	}
+	if len(texts) > 1 {
+		resp.Candidates = texts
 	}
+	return resp, nil
+}
 
-	// Иначе используем Gemini
-	client := gemini.NewClient(apiKey, model)
-	return client.GenerateText(prompt)
+// StreamText генерирует текст потоково, передавая фрагменты ответа в onDelta.
+// Генерация прерывается при отмене ctx. К следующей модели политики можно перейти,
+// только пока клиенту не отправлено ни одного фрагмента; переходы передаются в
+// onFallback. Ход обработки длинного текста по частям передаётся в onProgress.
+// Параметры нужно проверить через ValidatePolicy до начала потока, чтобы ошибку
+// валидации можно было вернуть обычным ответом.
+func (s *AIService) StreamText(ctx context.Context, policy llm.Policy, apiKey, prompt string, params domain.GenerationParams,
+	onDelta func(string) error, onFallback func(domain.FallbackEvent), onProgress func(domain.ChunkProgress)) (domain.AIUsage, error) {
+	var usage domain.AIUsage
+	started := false
+	send := func(text string) error {
+		started = true
+		return onDelta(text)
+	}
+	model, _, err := s.runPolicy(ctx, policy, apiKey, params, true, onFallback, func(provider llm.Provider, model string) error {
+		var err error
+		usage, err = s.streamText(ctx, provider, llm.UserPrompt(model, apiKey, prompt, params), send, onProgress)
+		if err != nil && started {
+			return &finalError{err: err}
+		}
+		return err
+	})
+	if usage.Model == "" {
+		usage.Model = model
+	}
+	return usage, err
 }
 
-func (s *AIService) ListModels(apiKey string) ([]domain.ModelInfo, error) {
-	client := gemini.NewClient(apiKey, "")
-	allModels, err := client.GetAvailableModels()
+// streamText потоковая генерация одной моделью. Длинный текст обрабатывается по частям
+// параллельно (runChunks): ответ каждой части отдаётся целиком, по порядку частей,
+// через двойной перенос строки, как в ответе AskText; неудачные части пропускаются.
+func (s *AIService) streamText(ctx context.Context, provider llm.Provider, req llm.Request,
+	onDelta func(string) error, onProgress func(domain.ChunkProgress)) (domain.AIUsage, error) {
+	caps := provider.Capabilities(req.Model)
+	req.Params = withTextDefaults(req.Params, caps.TextDefaults)
+	prompt := req.Messages[0].Content
+	chunks := s.splitPrompt(prompt, caps.MaxInputChars)
+	if len(chunks) == 1 {
+		return provider.Stream(ctx, req, onDelta)
+	}
+	logger.L.Info("streaming text in chunks", "total_chars", utf8.RuneCountInString(prompt), "chunks", len(chunks))
+
+	sent := false
+	out, err := s.runChunks(ctx, provider, req, chunks, chunkHooks{
+		onText: func(_ int, text string) error {
+			if sent {
+				if err := onDelta("\n\n"); err != nil {
+					return err
+				}
+			}
+			sent = true
+			return onDelta(text)
+		},
+		onProgress: onProgress,
+	})
+	return out.usage, err
+}
+
+// generateText генерирует ответ на запрос из одного сообщения с параметрами текста
+// по умолчанию от поставщика. Если сообщение длиннее входного лимита модели, оно
+// обрабатывается по частям параллельно, а ответы склеиваются в один вариант; части,
+// которые не удались, возвращаются отдельно. При отмене ctx оставшиеся части не отправляются.
+func (s *AIService) generateText(ctx context.Context, provider llm.Provider, req llm.Request) ([]string, []domain.ChunkError, error) {
+	caps := provider.Capabilities(req.Model)
+	req.Params = withTextDefaults(req.Params, caps.TextDefaults)
+	prompt := req.Messages[0].Content
+	chunks := s.splitPrompt(prompt, caps.MaxInputChars)
+	if len(chunks) == 1 {
+		resp, err := provider.Generate(ctx, req)
+		if err != nil {
+			return nil, nil, err
+		}
+		return resp.Texts, nil, nil
+	}
+
+	logger.L.Info("processing text in chunks", "total_chars", utf8.RuneCountInString(prompt), "chunks", len(chunks))
+	out, err := s.runChunks(ctx, provider, req, chunks, chunkHooks{})
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
+	return []string{out.joined()}, out.failed, nil
+}
+
+// withTextDefaults дополняет незаданные system_instruction и temperature значениями поставщика
+func withTextDefaults(params, defaults domain.GenerationParams) domain.GenerationParams {
+	if params.SystemInstruction == "" {
+		params.SystemInstruction = defaults.SystemInstruction
+	}
+	if params.Temperature == nil {
+		params.Temperature = defaults.Temperature
+	}
+	return params
+}
 
-	// Фильтруем только активные модели
+// Chat отправляет историю диалога модели и возвращает ответ
+func (s *AIService) Chat(ctx context.Context, model, apiKey string, history []domain.ChatMessage) (string, error) {
+	provider, err := s.llm.Resolve(model)
+	if err != nil {
+		return "", err
+	}
+	resp, err := provider.Generate(ctx, llm.Request{Model: model, APIKey: apiKey, Messages: history})
+	if err != nil {
+		return "", err
+	}
+	return resp.Text(), nil
+}
+
+// HistoryLimit сколько символов истории диалога помещается во вход модели
+func (s *AIService) HistoryLimit(model string) int {
+	if provider, err := s.llm.Resolve(model); err == nil {
+		if limit := provider.Capabilities(model).MaxInputChars; limit > 0 {
+			return limit
+		}
+	}
+	return s.cfg.ChatHistoryMaxChars
+}
+
+// RequiresAPIKey нужен ли для модели ключ Gemini пользователя
+func (s *AIService) RequiresAPIKey(model string) (bool, error) {
+	provider, err := s.llm.Resolve(model)
+	if err != nil {
+		return false, err
+	}
+	return provider.Capabilities(model).RequiresAPIKey, nil
+}
+
+// ListModels объединяет списки моделей всех поставщиков. Поставщики, которым нужен
+// ключ, пропускаются, если ключа нет; недоступный поставщик не мешает остальным.
+// В список попадают только модели, маршрут которых ведёт к их поставщику.
+func (s *AIService) ListModels(ctx context.Context, apiKey string) ([]domain.ModelInfo, error) {
 	activeModels := make([]domain.ModelInfo, 0)
-	for _, model := range allModels {
-		if model.IsAvailable {
+	var firstErr error
+	listed := 0
+	for _, provider := range s.llm.Providers() {
+		if provider.Capabilities("").RequiresAPIKey && apiKey == "" {
+			continue
+		}
+		models, err := provider.ListModels(ctx, apiKey)
+		if err != nil {
+			logger.L.Warn("failed to list models", "provider", provider.Name(), "error", err.Error())
+			if firstErr == nil {
+				firstErr = err
+			}
+			continue
+		}
+		listed++
+
+		// Фильтруем только активные модели
+		for _, model := range models {
+			if !model.IsAvailable {
+				continue
+			}
+			if routed, err := s.llm.Resolve(model.Name); err != nil || routed.Name() != provider.Name() {
+				continue
+			}
 			activeModels = append(activeModels, model)
 		}
 	}
+	if listed == 0 && firstErr != nil {
+		return nil, firstErr
+	}
 
 	return activeModels, nil
 }
@@ -0,0 +1,73 @@
+package service
+
+import (
+	"geminiBackend/internal/domain"
+	"sync"
+)
+
+// aiStats счётчики AI-запросов в памяти процесса; сбрасываются при перезапуске
+type aiStats struct {
+	mu                sync.Mutex
+	fallbacks         int64
+	fallbacksByReason map[string]int64
+	fallbacksByModel  map[string]int64
+
+	embeddings      int64
+	embeddingInputs int64
+	embeddingTokens map[string]int64 // по модели
+}
+
+func newAIStats() *aiStats {
+	return &aiStats{
+		fallbacksByReason: make(map[string]int64),
+		fallbacksByModel:  make(map[string]int64),
+		embeddingTokens:   make(map[string]int64),
+	}
+}
+
+func (s *aiStats) fallback(e domain.FallbackEvent) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.fallbacks++
+	s.fallbacksByReason[e.Reason]++
+	s.fallbacksByModel[e.Model+" -> "+e.Next]++
+}
+
+func (s *aiStats) embedding(inputs int, usage domain.AIUsage) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.embeddings++
+	s.embeddingInputs += int64(inputs)
+	s.embeddingTokens[usage.Model] += int64(usage.TotalTokens)
+}
+
+func (s *aiStats) snapshot() domain.AIStats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	stats := domain.AIStats{
+		Fallbacks:         s.fallbacks,
+		FallbacksByReason: make(map[string]int64, len(s.fallbacksByReason)),
+		FallbacksByModel:  make(map[string]int64, len(s.fallbacksByModel)),
+		Embeddings:        s.embeddings,
+		EmbeddingInputs:   s.embeddingInputs,
+		EmbeddingTokens:   make(map[string]int64, len(s.embeddingTokens)),
+	}
+	for k, v := range s.fallbacksByReason {
+		stats.FallbacksByReason[k] = v
+	}
+	for k, v := range s.fallbacksByModel {
+		stats.FallbacksByModel[k] = v
+	}
+	for k, v := range s.embeddingTokens {
+		stats.EmbeddingTokens[k] = v
+	}
+	return stats
+}
+
+// Stats счётчики AI-запросов с момента запуска: переходы политик, повторы вызовов и эмбеддинги
+func (s *AIService) Stats() domain.AIStats {
+	stats := s.stats.snapshot()
+	retries := s.llm.Retrier().Stats()
+	stats.Retries, stats.RetriesByOp, stats.RetriesGaveUp = retries.Retries, retries.ByOperation, retries.GaveUp
+	return stats
+}
@@ -0,0 +1,68 @@
+package service
+
+import (
+	"geminiBackend/internal/domain"
+	"geminiBackend/internal/provider/db"
+	"geminiBackend/pkg/logger"
+	"strings"
+	"unicode/utf8"
+)
+
+// APITokenPrefix начало персонального токена; по нему токен отличается от JWT в заголовке Authorization
+const APITokenPrefix = "gbk_"
+
+// maxAPITokenName макс. длина названия токена в символах
+const maxAPITokenName = 100
+
+// IsAPIToken похожа ли строка на персональный токен
+func IsAPIToken(token string) bool {
+	return strings.HasPrefix(token, APITokenPrefix)
+}
+
+// CreateAPIToken выпускает персональный токен для OpenAI-совместимого API.
+// Значение токена возвращается только здесь, в БД сохраняется его хеш.
+func (s *AuthService) CreateAPIToken(tgID int, name string) (domain.CreateAPITokenResponse, error) {
+	name = strings.TrimSpace(name)
+	if utf8.RuneCountInString(name) > maxAPITokenName {
+		return domain.CreateAPITokenResponse{}, domain.ErrInvalidInput
+	}
+	secret, err := randomToken(32)
+	if err != nil {
+		return domain.CreateAPITokenResponse{}, err
+	}
+	token := APITokenPrefix + secret
+	prefix := token[:len(APITokenPrefix)+6]
+	record, err := db.NewAPITokensProvider(s.db).CreateToken(tgID, name, prefix, hashToken(token))
+	if err != nil {
+		return domain.CreateAPITokenResponse{}, err
+	}
+	return domain.CreateAPITokenResponse{APIToken: *record, Token: token}, nil
+}
+
+// ListAPITokens токены пользователя (без значений)
+func (s *AuthService) ListAPITokens(tgID int) ([]domain.APIToken, error) {
+	return db.NewAPITokensProvider(s.db).ListTokens(tgID)
+}
+
+// DeleteAPIToken отзывает токен пользователя
+func (s *AuthService) DeleteAPIToken(tgID int, id int64) error {
+	return db.NewAPITokensProvider(s.db).DeleteToken(id, tgID)
+}
+
+// AuthenticateAPIToken проверяет персональный токен и возвращает claims его владельца.
+// У claims нет id сессии: персональный токен действует до удаления, пока пользователь активен.
+func (s *AuthService) AuthenticateAPIToken(token string) (*domain.Claims, error) {
+	tokens := db.NewAPITokensProvider(s.db)
+	record, err := tokens.GetTokenByHash(hashToken(token))
+	if err != nil {
+		return nil, domain.ErrUnauthorized
+	}
+	user, err := db.NewUsersProvider(s.db, s.keys).GetUserByTelegramID(record.TgID)
+	if err != nil || user.IsActive == 0 {
+		return nil, domain.ErrUnauthorized
+	}
+	if err := tokens.TouchToken(record.ID); err != nil {
+		logger.L.Warn("touch api token error", "token", record.ID, "err", err)
+	}
+	return &domain.Claims{Username: user.Username, Role: userRole(user), TgID: user.TgID}, nil
+}
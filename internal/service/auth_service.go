@@ -1,9 +1,15 @@
 package service
 
 import (
+	"crypto/rand"
+	"crypto/sha256"
 	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"geminiBackend/config"
 	"geminiBackend/internal/domain"
 	"geminiBackend/internal/provider/db"
+	"geminiBackend/pkg/keyring"
 	"geminiBackend/pkg/logger"
 	"time"
 
@@ -11,27 +17,60 @@ import (
 )
 
 type AuthService struct {
-	jwtSecret string
-	db        *sql.DB
+	jwtSecret     string
+	botToken      string
+	authMaxAge    time.Duration
+	allowUnsigned bool
+	accessTTL     time.Duration
+	refreshTTL    time.Duration
+	db            *sql.DB
+	keys          *keyring.Keyring
 }
 
-func NewAuthService(secret string, database *sql.DB) *AuthService {
-	return &AuthService{jwtSecret: secret, db: database}
+func NewAuthService(cfg *config.Config, database *sql.DB, keys *keyring.Keyring) *AuthService {
+	s := &AuthService{
+		jwtSecret:     cfg.JWTSecret,
+		botToken:      cfg.TelegramBotToken,
+		authMaxAge:    time.Duration(cfg.TelegramAuthMaxAge) * time.Second,
+		allowUnsigned: cfg.AllowUnsignedLogin,
+		accessTTL:     cfg.AccessTokenTTL,
+		refreshTTL:    cfg.RefreshTokenTTL,
+		db:            database,
+		keys:          keys,
+	}
+	// Без ограничения возраста перехваченные данные Telegram действовали бы вечно
+	if s.authMaxAge <= 0 {
+		s.authMaxAge = 24 * time.Hour
+	}
+	if s.accessTTL <= 0 {
+		s.accessTTL = time.Hour
+	}
+	if s.refreshTTL <= 0 {
+		s.refreshTTL = 30 * 24 * time.Hour
+	}
+	return s
 }
 
 func (s *AuthService) Register(req domain.RegisterRequest) (domain.RegisterResponse, error) {
-	if req.TgID <= 0 || req.Username == "" {
+	identity, err := s.identify(req.TgID, req.Username, req.InitData, req.Widget)
+	if err != nil {
+		return domain.RegisterResponse{}, err
+	}
+	if identity.Username == "" {
+		identity.Username = req.Username
+	}
+	if identity.TgID <= 0 || identity.Username == "" {
 		return domain.RegisterResponse{}, domain.ErrInvalidInput
 	}
-	userDB := db.NewUsersProvider(s.db)
+	userDB := db.NewUsersProvider(s.db, s.keys)
 
-	_, err := userDB.GetUserByTelegramID(req.TgID)
+	_, err = userDB.GetUserByTelegramID(identity.TgID)
 	if err == nil {
 		return domain.RegisterResponse{Message: domain.ErrUserExists.Error()}, domain.ErrUserExists
 	}
 	newUser := domain.UserDB{
-		Username: req.Username,
-		TgID:     req.TgID,
+		Username: identity.Username,
+		TgID:     identity.TgID,
 		IsActive: 1,
 		IsAdmin:  0,
 	}
@@ -42,15 +81,19 @@ func (s *AuthService) Register(req domain.RegisterRequest) (domain.RegisterRespo
 }
 
 func (s *AuthService) Login(req domain.LoginRequest) (domain.LoginResponse, error) {
-	if req.TgID <= 0 {
+	identity, err := s.identify(req.TgID, req.Username, req.InitData, req.Widget)
+	if err != nil {
+		return domain.LoginResponse{}, err
+	}
+	if identity.TgID <= 0 {
 		return domain.LoginResponse{}, domain.ErrInvalidCredentials
 	}
 
-	userDB := db.NewUsersProvider(s.db)
+	userDB := db.NewUsersProvider(s.db, s.keys)
 
-	user, err := userDB.GetUserByTelegramID(req.TgID)
+	user, err := userDB.GetUserByTelegramID(identity.TgID)
 	if err != nil {
-		logger.L.Error("get user error", "tg_id", req.TgID, "err", err)
+		logger.L.Error("get user error", "tg_id", identity.TgID, "err", err)
 		return domain.LoginResponse{}, domain.ErrInvalidCredentials
 	}
 
@@ -58,20 +101,146 @@ func (s *AuthService) Login(req domain.LoginRequest) (domain.LoginResponse, erro
 		return domain.LoginResponse{}, domain.ErrInvalidCredentials
 	}
 
-	var role string
-	if user.IsAdmin == 1 {
-		role = "admin"
-	} else {
-		role = "user"
+	return s.startSession(user, s.refreshTTL, s.accessTTL)
+}
+
+// IssueToken выдаёт короткоживущий access токен без refresh токена (для CLI и автоматизации).
+// Сессия истекает вместе с токеном.
+func (s *AuthService) IssueToken(tgID int, ttl time.Duration) (domain.LoginResponse, error) {
+	user, err := db.NewUsersProvider(s.db, s.keys).GetUserByTelegramID(tgID)
+	if err != nil {
+		return domain.LoginResponse{}, domain.ErrUserNotFound
+	}
+	if user.IsActive == 0 {
+		return domain.LoginResponse{}, domain.ErrInvalidCredentials
+	}
+	resp, err := s.startSession(user, ttl, ttl)
+	if err != nil {
+		return domain.LoginResponse{}, err
+	}
+	resp.RefreshToken = ""
+	return resp, nil
+}
+
+// Refresh обменивает refresh токен на новую пару токенов (ротация).
+// Повторное использование уже обменянного refresh токена отзывает всю сессию.
+func (s *AuthService) Refresh(req domain.RefreshRequest) (domain.LoginResponse, error) {
+	if req.RefreshToken == "" {
+		return domain.LoginResponse{}, domain.ErrInvalidRefresh
+	}
+	sessions := db.NewSessionsProvider(s.db)
+	hash := hashToken(req.RefreshToken)
+
+	session, err := sessions.GetSessionByRefreshHash(hash)
+	if err != nil {
+		if reused, rerr := sessions.GetSessionByPrevRefreshHash(hash); rerr == nil {
+			logger.L.Warn("refresh token reuse detected, revoking session", "tg_id", reused.TgID, "session", reused.ID)
+			if err := sessions.RevokeSession(reused.ID); err != nil {
+				logger.L.Error("revoke session error", "session", reused.ID, "err", err)
+			}
+		}
+		return domain.LoginResponse{}, domain.ErrInvalidRefresh
+	}
+	if session.RevokedAt.Valid || time.Now().After(session.ExpiresAt) {
+		return domain.LoginResponse{}, domain.ErrInvalidRefresh
+	}
+
+	user, err := db.NewUsersProvider(s.db, s.keys).GetUserByTelegramID(session.TgID)
+	if err != nil || user.IsActive == 0 {
+		return domain.LoginResponse{}, domain.ErrInvalidRefresh
+	}
+
+	refreshToken, err := randomToken(32)
+	if err != nil {
+		return domain.LoginResponse{}, err
+	}
+	ok, err := sessions.RotateRefreshToken(session.ID, hash, hashToken(refreshToken), time.Now().UTC().Add(s.refreshTTL).Truncate(time.Second))
+	if err != nil {
+		return domain.LoginResponse{}, err
+	}
+	if !ok {
+		return domain.LoginResponse{}, domain.ErrInvalidRefresh
+	}
+	return s.issueAccessToken(user, session.ID, refreshToken, s.accessTTL)
+}
+
+// Logout отзывает сессию, к которой привязан access токен
+func (s *AuthService) Logout(claims *domain.Claims) error {
+	return db.NewSessionsProvider(s.db).RevokeSession(claims.ID)
+}
+
+// LogoutAll отзывает все сессии пользователя
+func (s *AuthService) LogoutAll(tgID int) error {
+	return db.NewSessionsProvider(s.db).RevokeUserSessions(tgID)
+}
+
+// CheckSession проверяет, что сессия токена не отозвана, а пользователь активен.
+// Роль в claims обновляется из БД, чтобы смена прав действовала сразу.
+func (s *AuthService) CheckSession(claims *domain.Claims) error {
+	if claims.ID == "" {
+		return domain.ErrSessionRevoked
+	}
+	session, err := db.NewSessionsProvider(s.db).GetSession(claims.ID)
+	if err != nil || session.RevokedAt.Valid || session.TgID != claims.TgID || time.Now().After(session.ExpiresAt) {
+		return domain.ErrSessionRevoked
+	}
+	user, err := db.NewUsersProvider(s.db, s.keys).GetUserByTelegramID(claims.TgID)
+	if err != nil || user.IsActive == 0 {
+		return domain.ErrSessionRevoked
+	}
+	claims.Role = userRole(user)
+	return nil
+}
+
+// startSession создаёт новую сессию и выдаёт пару токенов
+func (s *AuthService) startSession(user *domain.UserDB, sessionTTL, accessTTL time.Duration) (domain.LoginResponse, error) {
+	sessions := db.NewSessionsProvider(s.db)
+	if err := sessions.DeleteExpiredSessions(user.TgID); err != nil {
+		logger.L.Warn("cleanup sessions error", "tg_id", user.TgID, "err", err)
+	}
+
+	sessionID, err := randomToken(16)
+	if err != nil {
+		return domain.LoginResponse{}, err
+	}
+	refreshToken, err := randomToken(32)
+	if err != nil {
+		return domain.LoginResponse{}, err
+	}
+	session := domain.Session{
+		ID:          sessionID,
+		TgID:        user.TgID,
+		RefreshHash: hashToken(refreshToken),
+		ExpiresAt:   time.Now().UTC().Add(sessionTTL).Truncate(time.Second),
+	}
+	if err := sessions.CreateSession(session); err != nil {
+		return domain.LoginResponse{}, err
+	}
+	return s.issueAccessToken(user, sessionID, refreshToken, accessTTL)
+}
+
+func (s *AuthService) issueAccessToken(user *domain.UserDB, sessionID, refreshToken string, ttl time.Duration) (domain.LoginResponse, error) {
+	now := time.Now()
+	claims := &domain.Claims{
+		Username: user.Username,
+		Role:     userRole(user),
+		TgID:     user.TgID,
+		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        sessionID,
+			ExpiresAt: jwt.NewNumericDate(now.Add(ttl)),
+			IssuedAt:  jwt.NewNumericDate(now),
+		},
 	}
-	exp := time.Now().Add(1 * time.Hour)
-	claims := &domain.Claims{Username: req.Username, Role: role, TgID: user.TgID, RegisteredClaims: jwt.RegisteredClaims{ExpiresAt: jwt.NewNumericDate(exp), IssuedAt: jwt.NewNumericDate(time.Now())}}
 	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
 	tokenString, err := token.SignedString([]byte(s.jwtSecret))
 	if err != nil {
 		return domain.LoginResponse{}, err
 	}
-	return domain.LoginResponse{Token: tokenString}, nil
+	return domain.LoginResponse{
+		Token:        tokenString,
+		RefreshToken: refreshToken,
+		ExpiresIn:    int64(ttl / time.Second),
+	}, nil
 }
 
 func (s *AuthService) Parse(tokenString string) (*domain.Claims, error) {
@@ -84,3 +253,44 @@ func (s *AuthService) Parse(tokenString string) (*domain.Claims, error) {
 	}
 	return claims, nil
 }
+
+// identify определяет пользователя по подписанным данным Telegram.
+// Приоритет: initData Mini App, затем Login Widget, затем голый tg_id (только в dev-режиме).
+func (s *AuthService) identify(tgID int, username, initData string, widget *domain.TelegramAuthData) (telegramIdentity, error) {
+	if initData != "" || widget != nil {
+		if s.botToken == "" {
+			logger.L.Error("telegram auth data received but TELEGRAM_BOT_TOKEN is not set")
+			return telegramIdentity{}, domain.ErrTelegramAuthDisabled
+		}
+		if initData != "" {
+			return verifyTelegramInitData(s.botToken, initData, s.authMaxAge, time.Now())
+		}
+		return verifyTelegramWidget(s.botToken, widget, s.authMaxAge, time.Now())
+	}
+	if !s.allowUnsigned {
+		return telegramIdentity{}, domain.ErrUnsignedLogin
+	}
+	return telegramIdentity{TgID: tgID, Username: username}, nil
+}
+
+func userRole(user *domain.UserDB) string {
+	if user.IsAdmin == 1 {
+		return "admin"
+	}
+	return "user"
+}
+
+// randomToken возвращает криптостойкую случайную строку из n байт
+func randomToken(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// hashToken хеш refresh токена для хранения в БД
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
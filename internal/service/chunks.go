@@ -0,0 +1,201 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"geminiBackend/internal/domain"
+	"geminiBackend/internal/provider/llm"
+	"geminiBackend/pkg/logger"
+	"geminiBackend/pkg/splitter"
+	"sort"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// chunkHooks обработчики хода обработки частей; оба вызываются из горутины runChunks
+type chunkHooks struct {
+	onText     func(i int, text string) error // текст удачной части, строго по порядку частей
+	onProgress func(domain.ChunkProgress)     // после каждой готовой или неудачной части
+}
+
+// chunkOutcome итог обработки частей: тексты по порядку (у неудачных — пусто),
+// ошибки неудачных частей и суммарный расход токенов
+type chunkOutcome struct {
+	texts  []string
+	errs   []error
+	failed []domain.ChunkError
+	usage  domain.AIUsage
+}
+
+// joined тексты удачных частей через двойной перенос строки
+func (o chunkOutcome) joined() string {
+	text := ""
+	for i, t := range o.texts {
+		if o.errs[i] != nil {
+			continue
+		}
+		if text != "" {
+			text += "\n\n"
+		}
+		text += t
+	}
+	return text
+}
+
+type chunkResult struct {
+	index int
+	resp  *llm.Response
+	err   error
+}
+
+// runChunks генерирует ответ на каждую часть пулом из LLM_CHUNK_WORKERS воркеров.
+// Часть с временной ошибкой повторяется (generateChunk); если она так и не удалась,
+// это отмечается в outcome.failed, а остальные части обрабатываются дальше. Ошибка
+// возвращается, если не удалась ни одна часть, ошибка касается всего запроса (ключ,
+// доступ, модель) или отменён ctx — тогда необработанные части не отправляются.
+func (s *AIService) runChunks(ctx context.Context, provider llm.Provider, req llm.Request, chunks []string, hooks chunkHooks) (chunkOutcome, error) {
+	total := len(chunks)
+	out := chunkOutcome{texts: make([]string, total), errs: make([]error, total), usage: domain.AIUsage{Model: req.Model}}
+	workCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	jobs := make(chan int)
+	go func() {
+		defer close(jobs)
+		for i := range chunks {
+			select {
+			case jobs <- i:
+			case <-workCtx.Done():
+				return
+			}
+		}
+	}()
+	results := make(chan chunkResult)
+	var wg sync.WaitGroup
+	for range min(max(s.cfg.LLMChunkWorkers, 1), total) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				resp, err := s.generateChunk(workCtx, provider, req, i, chunks[i])
+				results <- chunkResult{index: i, resp: resp, err: err}
+			}
+		}()
+	}
+	go func() {
+		wg.Wait()
+		close(results)
+	}()
+
+	ready := make([]bool, total)
+	next, done := 0, 0
+	var abort error
+	for r := range results {
+		if abort != nil {
+			continue // ждём, пока воркеры завершат начатые вызовы
+		}
+		done++
+		ready[r.index] = true
+		progress := domain.ChunkProgress{Total: total}
+		if r.err != nil {
+			if !partialFailure(r.err) {
+				abort = fmt.Errorf("chunk %d failed: %w", r.index, r.err)
+				cancel()
+				continue
+			}
+			logger.L.Error("failed to process chunk", "chunk_index", r.index, "error", r.err.Error())
+			out.errs[r.index] = r.err
+			failed := domain.ChunkError{Index: r.index, Reason: llm.ErrorClass(r.err), Error: r.err.Error()}
+			out.failed = append(out.failed, failed)
+			progress.FailedChunk = &failed
+		} else {
+			out.texts[r.index] = r.resp.Text()
+			addUsage(&out.usage, r.resp.Usage)
+			logger.L.Debug("processed chunk", "chunk_index", r.index, "input_len", utf8.RuneCountInString(chunks[r.index]), "output_len", len(out.texts[r.index]))
+		}
+		progress.Done, progress.Failed = done, len(out.failed)
+		if hooks.onProgress != nil {
+			hooks.onProgress(progress)
+		}
+		for ; next < total && ready[next]; next++ {
+			if hooks.onText == nil || out.errs[next] != nil {
+				continue
+			}
+			if err := hooks.onText(next, out.texts[next]); err != nil {
+				abort = err
+				cancel()
+				break
+			}
+		}
+	}
+
+	sort.Slice(out.failed, func(i, j int) bool { return out.failed[i].Index < out.failed[j].Index })
+	if err := ctx.Err(); err != nil {
+		logger.L.Info("chunk processing canceled, remaining chunks abandoned", "done", done, "chunks", total)
+		return out, err
+	}
+	if abort != nil {
+		return out, abort
+	}
+	if len(out.failed) == total {
+		return out, fmt.Errorf("all %d chunks failed, chunk 0: %w", total, out.errs[0])
+	}
+	return out, nil
+}
+
+// generateChunk генерирует ответ на одну часть, повторяя вызов до LLM_CHUNK_RETRIES раз
+// при превышении квоты, недоступности поставщика или таймауте
+func (s *AIService) generateChunk(ctx context.Context, provider llm.Provider, req llm.Request, index int, chunk string) (*llm.Response, error) {
+	req.Messages = []domain.ChatMessage{{Role: domain.ChatRoleUser, Content: chunk}}
+	for attempt := 1; ; attempt++ {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		resp, err := provider.Generate(ctx, req)
+		if err == nil || attempt > s.cfg.LLMChunkRetries || !chunkRetryable(err) || ctx.Err() != nil {
+			return resp, err
+		}
+		delay := s.cfg.LLMChunkRetryDelay * time.Duration(attempt)
+		logger.L.Warn("retrying chunk", "chunk_index", index, "attempt", attempt+1, "delay", delay, "error", err.Error())
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
+
+func chunkRetryable(err error) bool {
+	switch llm.ErrorClass(err) {
+	case llm.ErrorClassRateLimit, llm.ErrorClassUnavailable, llm.ErrorClassTimeout:
+		return true
+	}
+	return false
+}
+
+// partialFailure можно ли отметить ошибку части в ответе и продолжить с остальными:
+// временная недоступность или квота, блокировка фильтрами, ошибка поставщика на этом
+// тексте. Ошибки ключа, доступа, модели и параметров повторятся на каждой части.
+func partialFailure(err error) bool {
+	return errors.Is(err, domain.ErrProviderUnavailable) || errors.Is(err, domain.ErrQuotaExceeded) ||
+		errors.Is(err, domain.ErrProviderError) || errors.Is(err, domain.ErrContentBlocked)
+}
+
+// splitPrompt части запроса не длиннее входного лимита модели в символах, с повтором
+// LLM_CHUNK_OVERLAP символов конца предыдущей части; короткий запрос — одна часть
+func (s *AIService) splitPrompt(prompt string, maxChars int) []string {
+	return splitter.Texts(splitter.Split(prompt, splitter.Options{MaxSize: maxChars, Overlap: s.cfg.LLMChunkOverlap}))
+}
+
+func addUsage(total *domain.AIUsage, u domain.AIUsage) {
+	if u.Model != "" {
+		total.Model = u.Model
+	}
+	total.PromptTokens += u.PromptTokens
+	total.CompletionTokens += u.CompletionTokens
+	total.TotalTokens += u.TotalTokens
+}
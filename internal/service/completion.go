@@ -0,0 +1,55 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"geminiBackend/internal/domain"
+	"geminiBackend/internal/provider/llm"
+)
+
+// ValidateCompletion проверяет запрос в формате чата (OpenAI-совместимый API):
+// наличие сообщений, параметры генерации, длину истории и вложения по возможностям
+// модели. История не режется, поэтому длиннее входного лимита модели она не принимается.
+// Ошибки проверки — *domain.ParamError.
+func (s *AIService) ValidateCompletion(ctx context.Context, req llm.Request, stream bool) error {
+	if len(req.Messages) == 0 {
+		return &domain.ParamError{Field: "messages", Reason: "at least one user or assistant message required"}
+	}
+	if err := s.ValidateParams(ctx, req.Model, req.APIKey, req.Params, stream); err != nil {
+		return err
+	}
+	provider, err := s.llm.Resolve(req.Model)
+	if err != nil {
+		return err
+	}
+	caps := provider.Capabilities(req.Model)
+	if total := llm.InputChars(req); caps.MaxInputChars > 0 && total > caps.MaxInputChars {
+		return &domain.ParamError{Field: "messages", Reason: fmt.Sprintf("too long: %d chars (max %d)", total, caps.MaxInputChars)}
+	}
+	if len(req.Attachments) == 0 {
+		return nil
+	}
+	return s.validateImages(caps, req.Attachments)
+}
+
+// Complete выполняет запрос в формате чата; история передаётся модели как есть, без обрезки
+func (s *AIService) Complete(ctx context.Context, req llm.Request) (*llm.Response, error) {
+	if err := s.ValidateCompletion(ctx, req, false); err != nil {
+		return nil, err
+	}
+	provider, err := s.llm.Resolve(req.Model)
+	if err != nil {
+		return nil, err
+	}
+	return provider.Generate(ctx, req)
+}
+
+// StreamCompletion потоковый вариант Complete. Запрос нужно проверить через
+// ValidateCompletion до начала потока.
+func (s *AIService) StreamCompletion(ctx context.Context, req llm.Request, onDelta func(string) error) (domain.AIUsage, error) {
+	provider, err := s.llm.Resolve(req.Model)
+	if err != nil {
+		return domain.AIUsage{Model: req.Model}, err
+	}
+	return provider.Stream(ctx, req, onDelta)
+}
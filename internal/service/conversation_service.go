@@ -0,0 +1,127 @@
+package service
+
+import (
+	"context"
+	"database/sql"
+	"geminiBackend/internal/domain"
+	"geminiBackend/internal/provider/db"
+	"strings"
+	"unicode/utf8"
+)
+
+// длина заголовка, который подставляется из первого сообщения
+const conversationTitleRunes = 60
+
+type ConversationService struct {
+	db *sql.DB
+	ai *AIService
+}
+
+func NewConversationService(db *sql.DB, ai *AIService) *ConversationService {
+	return &ConversationService{db: db, ai: ai}
+}
+
+func (s *ConversationService) Create(tgID int, title, model string) (*domain.Conversation, error) {
+	convs := db.NewConversationsProvider(s.db)
+	id, err := convs.CreateConversation(tgID, strings.TrimSpace(title), model)
+	if err != nil {
+		return nil, err
+	}
+	return convs.GetConversation(id, tgID)
+}
+
+func (s *ConversationService) List(tgID, limit, offset int) ([]domain.Conversation, int, error) {
+	return db.NewConversationsProvider(s.db).ListConversations(tgID, limit, offset)
+}
+
+// Find возвращает диалог пользователя без сообщений
+func (s *ConversationService) Find(tgID int, id int64) (*domain.Conversation, error) {
+	return db.NewConversationsProvider(s.db).GetConversation(id, tgID)
+}
+
+// Get возвращает диалог пользователя вместе со всеми сообщениями
+func (s *ConversationService) Get(tgID int, id int64) (*domain.ConversationResponse, error) {
+	convs := db.NewConversationsProvider(s.db)
+	conv, err := convs.GetConversation(id, tgID)
+	if err != nil {
+		return nil, err
+	}
+	messages, err := convs.ListMessages(id)
+	if err != nil {
+		return nil, err
+	}
+	return &domain.ConversationResponse{Conversation: *conv, Messages: messages}, nil
+}
+
+func (s *ConversationService) Rename(tgID int, id int64, title string) (*domain.Conversation, error) {
+	convs := db.NewConversationsProvider(s.db)
+	if err := convs.RenameConversation(id, tgID, strings.TrimSpace(title)); err != nil {
+		return nil, err
+	}
+	return convs.GetConversation(id, tgID)
+}
+
+func (s *ConversationService) Delete(tgID int, id int64) error {
+	return db.NewConversationsProvider(s.db).DeleteConversation(id, tgID)
+}
+
+// PostMessage отправляет модели историю диалога с новым сообщением и сохраняет
+// вопрос и ответ. Если model пустой, используется модель диалога. Сообщения
+// сохраняются только после успешного ответа, чтобы в истории не было вопросов без ответа.
+func (s *ConversationService) PostMessage(ctx context.Context, tgID int, id int64, content, model, apiKey string) (*domain.PostMessageResponse, error) {
+	convs := db.NewConversationsProvider(s.db)
+	conv, err := convs.GetConversation(id, tgID)
+	if err != nil {
+		return nil, err
+	}
+	if model == "" {
+		model = conv.Model
+	}
+
+	limit := s.ai.HistoryLimit(model)
+	if utf8.RuneCountInString(content) > limit {
+		return nil, domain.ErrMessageTooLong
+	}
+	history, err := convs.ListMessages(id)
+	if err != nil {
+		return nil, err
+	}
+	question := domain.ChatMessage{Role: domain.ChatRoleUser, Content: content}
+	history, trimmed := trimHistory(append(history, question), limit)
+
+	text, err := s.ai.Chat(ctx, model, apiKey, history)
+	if err != nil {
+		return nil, err
+	}
+
+	reply := domain.ChatMessage{Role: domain.ChatRoleAssistant, Content: text, Model: model}
+	if err := convs.AppendMessages(id, titleFromMessage(content), &question, &reply); err != nil {
+		return nil, err
+	}
+	return &domain.PostMessageResponse{Message: question, Reply: reply, HistoryTrimmed: trimmed}, nil
+}
+
+// trimHistory оставляет самые свежие сообщения, суммарно не длиннее maxChars символов.
+// Последнее сообщение (новый вопрос) сохраняется всегда; история начинается
+// с реплики пользователя, чтобы не обрывать диалог на полуслове модели.
+func trimHistory(history []domain.ChatMessage, maxChars int) ([]domain.ChatMessage, int) {
+	start := len(history) - 1
+	total := utf8.RuneCountInString(history[start].Content)
+	for start > 0 && total+utf8.RuneCountInString(history[start-1].Content) <= maxChars {
+		start--
+		total += utf8.RuneCountInString(history[start].Content)
+	}
+	for start < len(history)-1 && history[start].Role != domain.ChatRoleUser {
+		start++
+	}
+	return history[start:], start
+}
+
+// titleFromMessage заголовок по умолчанию — начало первого сообщения
+func titleFromMessage(content string) string {
+	title := strings.Join(strings.Fields(content), " ")
+	if utf8.RuneCountInString(title) <= conversationTitleRunes {
+		return title
+	}
+	return string([]rune(title)[:conversationTitleRunes]) + "…"
+}
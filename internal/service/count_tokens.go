@@ -0,0 +1,72 @@
+package service
+
+import (
+	"context"
+	"geminiBackend/internal/domain"
+	"geminiBackend/internal/provider/llm"
+	"unicode/utf8"
+)
+
+// CountTokens считает токены промпта моделью model так, как его отправит /ai/text
+// (с системным промптом поставщика по умолчанию), и сравнивает с лимитами модели.
+// Лимиты Gemini запрашиваются у API; у моделей без описания входной лимит в токенах
+// оценивается по лимиту в символах с той же плотностью токенов, что у промпта.
+// Стоимость считается по таблице цен LLM_PRICES, выход — по max_output_tokens.
+func (s *AIService) CountTokens(ctx context.Context, model, apiKey string, req domain.CountTokensRequest) (*domain.CountTokensResponse, error) {
+	if req.Prompt == "" {
+		return nil, &domain.ParamError{Field: "prompt", Reason: "required"}
+	}
+	if req.MaxOutputTokens < 0 {
+		return nil, &domain.ParamError{Field: "max_output_tokens", Reason: "must not be negative"}
+	}
+	provider, err := s.llm.Resolve(model)
+	if err != nil {
+		return nil, err
+	}
+	caps := provider.Capabilities(model)
+	params := withTextDefaults(domain.GenerationParams{}, caps.TextDefaults)
+	tokens, err := provider.CountTokens(ctx, llm.UserPrompt(model, apiKey, req.Prompt, params))
+	if err != nil {
+		return nil, err
+	}
+
+	chunks := len(s.splitPrompt(req.Prompt, caps.MaxInputChars))
+	resp := &domain.CountTokensResponse{
+		Model:            model,
+		Provider:         provider.Name(),
+		Tokens:           tokens,
+		Estimated:        !caps.ExactTokenCount,
+		OutputTokenLimit: caps.MaxOutputTokens,
+		Chars:            utf8.RuneCountInString(req.Prompt),
+		MaxInputChars:    caps.MaxInputChars,
+		Chunked:          chunks > 1,
+		Chunks:           chunks,
+	}
+	if describer, ok := provider.(llm.ModelDescriber); ok {
+		info, err := describer.DescribeModel(ctx, apiKey, model)
+		if err != nil {
+			return nil, err
+		}
+		resp.InputTokenLimit = int(info.InputTokenLimit)
+		resp.OutputTokenLimit = int(info.OutputTokenLimit)
+	} else if chars := resp.Chars + utf8.RuneCountInString(params.SystemInstruction); caps.MaxInputChars > 0 && chars > 0 {
+		resp.InputTokenLimit = (caps.MaxInputChars*tokens + chars - 1) / chars
+	}
+	if resp.InputTokenLimit > 0 {
+		headroom := resp.InputTokenLimit - tokens
+		resp.Headroom = &headroom
+	}
+
+	if price, ok := s.llm.Price(model); ok {
+		cost := &domain.CostEstimate{
+			Currency:         "USD",
+			InputPerMillion:  price.Input,
+			OutputPerMillion: price.Output,
+			InputCost:        float64(tokens) * price.Input / 1e6,
+			OutputCost:       float64(req.MaxOutputTokens) * price.Output / 1e6,
+		}
+		cost.TotalCost = cost.InputCost + cost.OutputCost
+		resp.Cost = cost
+	}
+	return resp, nil
+}
@@ -0,0 +1,266 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"geminiBackend/internal/domain"
+	"geminiBackend/internal/provider/llm"
+	"geminiBackend/pkg/docextract"
+	"geminiBackend/pkg/logger"
+	"strings"
+	"unicode/utf8"
+)
+
+// defaultModelForDocuments модель Gemini по умолчанию для документов (умеет читать PDF)
+const defaultModelForDocuments = "gemini-2.5-flash"
+
+// pageChunk группа подряд идущих страниц, обрабатываемая одним запросом к модели
+type pageChunk struct {
+	from, to int
+	text     string
+}
+
+// DocumentModel модель для документов: явно указанная или Gemini по умолчанию
+func (s *AIService) DocumentModel(requested string) string {
+	if requested != "" {
+		return requested
+	}
+	return defaultModelForDocuments
+}
+
+// DocumentUploadLimit максимальный размер multipart-запроса к /ai/document
+func (s *AIService) DocumentUploadLimit() int64 {
+	return int64(s.cfg.DocumentMaxMB<<20) + 1<<20
+}
+
+// ProcessDocument выполняет задание над документом. PDF отправляется в Gemini целиком,
+// если модель это поддерживает и файл помещается в inline-лимит; иначе из документа
+// извлекается текст, страницы группируются в части по лимиту модели, и части
+// обрабатываются по стратегии задания: concat — каждая заданием, ответы склеиваются;
+// map_reduce — частичные результаты сводятся в один (reduceResults); refine — результат
+// уточняется часть за частью (refineDocument). Ошибки проверки входных данных —
+// *domain.ParamError. При отмене ctx оставшиеся части не обрабатываются.
+func (s *AIService) ProcessDocument(ctx context.Context, model, apiKey, filename string, data []byte, task domain.DocumentTask) (*domain.DocumentResponse, error) {
+	instruction, err := documentInstruction(task)
+	if err != nil {
+		return nil, err
+	}
+	strategy, err := documentStrategy(task)
+	if err != nil {
+		return nil, err
+	}
+	format, err := s.detectDocument(filename, data)
+	if err != nil {
+		return nil, err
+	}
+	provider, err := s.llm.Resolve(model)
+	if err != nil {
+		return nil, err
+	}
+	caps := provider.Capabilities(model)
+	resp := &domain.DocumentResponse{Task: task.Task, Model: model, Format: format}
+
+	if format == docextract.FormatPDF && caps.Accepts("application/pdf") && (caps.MaxInlineBytes == 0 || len(data) <= caps.MaxInlineBytes) {
+		resp.Mode = domain.DocModeNativePDF
+		resp.Pages, _ = docextract.CountPDFPages(data)
+		req := llm.UserPrompt(model, apiKey, instruction, domain.GenerationParams{})
+		req.Attachments = []domain.Attachment{{MIMEType: "application/pdf", Data: data}}
+		result, err := provider.Generate(ctx, req)
+		if err != nil {
+			return nil, err
+		}
+		resp.Text = result.Text()
+		return resp, nil
+	}
+
+	doc, err := s.extractDocument(format, data)
+	if err != nil {
+		return nil, err
+	}
+
+	limit := s.cfg.DocumentChunkChars
+	if caps.MaxInputChars > 0 {
+		limit = caps.MaxInputChars
+	}
+	chunks := groupPages(doc.Pages, limit)
+	logger.L.Info("processing document", "format", format, "pages", len(doc.Pages), "chunks", len(chunks), "task", task.Task, "strategy", strategy, "model", model)
+
+	resp.Mode = domain.DocModeText
+	resp.Pages = len(doc.Pages)
+	resp.Strategy = strategy
+	params := domain.GenerationParams{SystemInstruction: instruction}
+	if task.Task == domain.DocTaskOCRCorrect {
+		params = ocrParams("", domain.OCRStrictnessNormal)
+	}
+	req := llm.UserPrompt(model, apiKey, "", params)
+	req.Params = withTextDefaults(req.Params, caps.TextDefaults)
+
+	// Страница длиннее входного лимита модели дополнительно режется по абзацам и предложениям
+	var pieces []string
+	var groupOf []int
+	for g, chunk := range chunks {
+		for _, piece := range s.splitPrompt(chunk.text, caps.MaxInputChars) {
+			pieces = append(pieces, piece)
+			groupOf = append(groupOf, g)
+		}
+	}
+	var steps []domain.DocumentStep
+	if len(pieces) > 1 && strategy == domain.DocStrategyRefine {
+		resp.Text, err = s.refineDocument(ctx, provider, req, task, chunks, limit, &steps)
+		if err != nil {
+			return nil, err
+		}
+		if task.Debug {
+			resp.Steps = steps
+		}
+		return resp, nil
+	}
+
+	// Все части всех групп страниц обрабатываются одним пулом; документ в одну часть —
+	// обычным заданием при любой стратегии
+	mapReduce := len(pieces) > 1 && strategy == domain.DocStrategyMapReduce
+	if mapReduce {
+		req.Params.SystemInstruction = mapInstruction(task)
+	}
+	out, err := s.runChunks(ctx, provider, req, pieces, chunkHooks{})
+	if err != nil {
+		return nil, err
+	}
+	for i, text := range out.texts {
+		if out.errs[i] == nil && len(pieces) > 1 {
+			steps = append(steps, domain.DocumentStep{Stage: "map", Index: i, Text: text})
+		}
+	}
+
+	groups := make([]chunkOutcome, len(chunks))
+	for i := range pieces {
+		g := &groups[groupOf[i]]
+		g.texts = append(g.texts, out.texts[i])
+		g.errs = append(g.errs, out.errs[i])
+	}
+	results := make([]string, 0, len(chunks))
+	for g, chunk := range chunks {
+		result := domain.DocumentChunkResult{FromPage: chunk.from, ToPage: chunk.to, Text: groups[g].joined()}
+		for _, err := range groups[g].errs {
+			if err != nil {
+				result.Error = err.Error()
+				break
+			}
+		}
+		if result.Text != "" {
+			results = append(results, result.Text)
+		}
+		if len(chunks) > 1 || len(out.failed) > 0 {
+			resp.Chunks = append(resp.Chunks, result)
+		}
+	}
+	if mapReduce {
+		req.Params.SystemInstruction = reduceInstruction(task)
+		resp.Text, err = s.reduceResults(ctx, provider, req, results, limit, &steps)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		resp.Text = strings.Join(results, "\n\n")
+	}
+	if task.Debug {
+		resp.Steps = steps
+	}
+	return resp, nil
+}
+
+// detectDocument проверяет размер загруженного документа и определяет его формат
+func (s *AIService) detectDocument(filename string, data []byte) (string, error) {
+	if len(data) == 0 {
+		return "", &domain.ParamError{Field: "file", Reason: "file required"}
+	}
+	if len(data) > s.cfg.DocumentMaxMB<<20 {
+		return "", &domain.ParamError{Field: "file", Reason: fmt.Sprintf("file exceeds %d MB", s.cfg.DocumentMaxMB)}
+	}
+	format, err := docextract.Detect(filename, data)
+	if err != nil {
+		return "", &domain.ParamError{Field: "file", Reason: "unsupported format (allowed: PDF, DOCX, TXT, Markdown)"}
+	}
+	return format, nil
+}
+
+// extractDocument извлекает текст документа по страницам; документ без текста
+// или с распакованным содержимым больше DOCUMENT_MAX_EXTRACTED_MB — ошибка
+func (s *AIService) extractDocument(format string, data []byte) (*docextract.Document, error) {
+	doc, err := docextract.Extract(format, data, int64(s.cfg.DocumentMaxExtractedMB)<<20)
+	if err != nil {
+		if errors.Is(err, docextract.ErrEncrypted) {
+			return nil, &domain.ParamError{Field: "file", Reason: "password-protected documents are not supported"}
+		}
+		if errors.Is(err, docextract.ErrTooLarge) {
+			return nil, &domain.ParamError{Field: "file", Reason: fmt.Sprintf("extracted content exceeds %d MB", s.cfg.DocumentMaxExtractedMB)}
+		}
+		return nil, &domain.ParamError{Field: "file", Reason: err.Error()}
+	}
+	if strings.TrimSpace(doc.Text()) == "" {
+		return nil, &domain.ParamError{Field: "file", Reason: "document has no extractable text (scanned PDF?); use a Gemini model to read it directly"}
+	}
+	return doc, nil
+}
+
+// documentInstruction формирует инструкцию модели для задания
+func documentInstruction(task domain.DocumentTask) (string, error) {
+	switch task.Task {
+	case domain.DocTaskSummarize:
+		return "Кратко перескажи содержание документа: основные темы, выводы и важные факты. Отвечай на языке документа.", nil
+	case domain.DocTaskQuestion:
+		if strings.TrimSpace(task.Question) == "" {
+			return "", &domain.ParamError{Field: "question", Reason: "required for task question"}
+		}
+		return "Ответь на вопрос, опираясь только на текст документа. Если ответа в документе нет, так и скажи.\nВопрос: " + task.Question, nil
+	case domain.DocTaskExtract:
+		if len(task.Fields) == 0 {
+			return "", &domain.ParamError{Field: "fields", Reason: "required for task extract"}
+		}
+		return "Извлеки из документа значения полей: " + strings.Join(task.Fields, ", ") +
+			". Ответь только JSON-объектом с этими ключами; если значение не найдено, укажи null.", nil
+	case domain.DocTaskOCRCorrect:
+		return ocrInstruction("", domain.OCRStrictnessNormal), nil
+	}
+	return "", &domain.ParamError{Field: "task", Reason: "must be one of summarize, question, extract, ocr_correct"}
+}
+
+// groupPages объединяет подряд идущие страницы в части не длиннее maxChars символов.
+// Страница никогда не делится между частями: слишком длинная страница становится отдельной частью.
+func groupPages(pages []string, maxChars int) []pageChunk {
+	var (
+		chunks []pageChunk
+		cur    pageChunk
+		sb     strings.Builder
+		size   int // длина sb в символах
+	)
+	flush := func() {
+		if sb.Len() > 0 {
+			cur.text = sb.String()
+			chunks = append(chunks, cur)
+		}
+		sb.Reset()
+		size = 0
+	}
+	for i, page := range pages {
+		if page == "" {
+			continue
+		}
+		pageSize := utf8.RuneCountInString(page)
+		if sb.Len() > 0 && size+pageSize+2 > maxChars {
+			flush()
+		}
+		if sb.Len() == 0 {
+			cur = pageChunk{from: i + 1}
+		} else {
+			sb.WriteString("\n\n")
+			size += 2
+		}
+		sb.WriteString(page)
+		size += pageSize
+		cur.to = i + 1
+	}
+	flush()
+	return chunks
+}
@@ -0,0 +1,97 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"geminiBackend/internal/domain"
+	"geminiBackend/internal/provider/llm"
+	"geminiBackend/pkg/logger"
+)
+
+// defaultModelForEmbeddings модель Gemini по умолчанию для эмбеддингов
+const defaultModelForEmbeddings = "gemini-embedding-001"
+
+// embedTaskTypes допустимые назначения эмбеддингов
+var embedTaskTypes = map[string]bool{
+	domain.EmbedTaskRetrievalQuery:     true,
+	domain.EmbedTaskRetrievalDocument:  true,
+	domain.EmbedTaskSemanticSimilarity: true,
+	domain.EmbedTaskClassification:     true,
+	domain.EmbedTaskClustering:         true,
+	domain.EmbedTaskQuestionAnswering:  true,
+	domain.EmbedTaskFactVerification:   true,
+	domain.EmbedTaskCodeRetrievalQuery: true,
+}
+
+// EmbeddingModel выбирает модель эмбеддингов: явно указанную, Gemini при наличии ключа,
+// иначе локальную (если она настроена)
+func (s *AIService) EmbeddingModel(requested string, hasKey bool) string {
+	if requested != "" {
+		return requested
+	}
+	if !hasKey && s.cfg.LocalEmbeddingModel != "" {
+		return s.cfg.LocalEmbeddingModel
+	}
+	return defaultModelForEmbeddings
+}
+
+// Embed строит эмбеддинги текстов моделью, поставщик которой это умеет. Входы больше
+// лимита поставщика (MaxEmbedBatch) отправляются пачками по порядку; векторы и расход
+// токенов всех пачек объединяются и учитываются в счётчиках AI. Ошибки проверки — *domain.ParamError.
+func (s *AIService) Embed(ctx context.Context, req llm.EmbedRequest) (*llm.EmbedResponse, error) {
+	if len(req.Inputs) == 0 {
+		return nil, &domain.ParamError{Field: "input", Reason: "at least one input required"}
+	}
+	if s.cfg.EmbeddingsMaxTexts > 0 && len(req.Inputs) > s.cfg.EmbeddingsMaxTexts {
+		return nil, &domain.ParamError{Field: "input", Reason: fmt.Sprintf("at most %d inputs allowed", s.cfg.EmbeddingsMaxTexts)}
+	}
+	for _, text := range req.Inputs {
+		if text == "" {
+			return nil, &domain.ParamError{Field: "input", Reason: "inputs must not be empty"}
+		}
+	}
+	if req.Dimensions < 0 {
+		return nil, &domain.ParamError{Field: "dimensions", Reason: "must be positive"}
+	}
+	if req.TaskType != "" && !embedTaskTypes[req.TaskType] {
+		return nil, &domain.ParamError{Field: "task_type", Reason: "unknown task type"}
+	}
+	provider, err := s.llm.Resolve(req.Model)
+	if err != nil {
+		return nil, err
+	}
+	embedder, ok := provider.(llm.Embedder)
+	if !ok {
+		return nil, &domain.ParamError{Field: "model", Reason: "model does not support embeddings"}
+	}
+
+	size := provider.Capabilities(req.Model).MaxEmbedBatch
+	if size < 1 {
+		size = len(req.Inputs)
+	}
+	inputs := req.Inputs
+	out := &llm.EmbedResponse{Vectors: make([][]float32, 0, len(inputs)), Usage: domain.AIUsage{Model: req.Model}}
+	batches := 0
+	for start := 0; start < len(inputs); start += size {
+		batch := inputs[start:min(start+size, len(inputs))]
+		req.Inputs = batch
+		resp, err := embedder.Embed(ctx, req)
+		if err != nil {
+			if batches > 0 {
+				return nil, fmt.Errorf("embedding batch %d failed: %w", batches, err)
+			}
+			return nil, err
+		}
+		if len(resp.Vectors) != len(batch) {
+			return nil, fmt.Errorf("%w: expected %d vectors, got %d", domain.ErrProviderError, len(batch), len(resp.Vectors))
+		}
+		out.Vectors = append(out.Vectors, resp.Vectors...)
+		addUsage(&out.Usage, resp.Usage)
+		batches++
+	}
+	if batches > 1 {
+		logger.L.Info("embedded inputs in batches", "model", req.Model, "inputs", len(inputs), "batches", batches)
+	}
+	s.stats.embedding(len(inputs), out.Usage)
+	return out, nil
+}
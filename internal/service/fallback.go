@@ -0,0 +1,192 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"geminiBackend/internal/domain"
+	"geminiBackend/internal/provider/llm"
+	"geminiBackend/pkg/logger"
+	"time"
+)
+
+// Лимиты резервных моделей в запросе
+const (
+	maxFallbackModels  = 5
+	maxFallbackDelayMs = 10000
+)
+
+// Причины перехода к следующей модели, кроме классов ошибок llm.ErrorClass*
+const (
+	fallbackMissingKey    = "missing_api_key" // модели нужен ключ Gemini, а его нет
+	fallbackInvalidParams = "invalid_params"  // параметры выходят за лимиты резервной модели
+)
+
+// finalError ошибка, после которой к следующей модели переходить нельзя:
+// часть ответа уже отправлена клиенту
+type finalError struct{ err error }
+
+func (e *finalError) Error() string { return e.err.Error() }
+func (e *finalError) Unwrap() error { return e.err }
+
+// DefaultPolicy имя политики для запросов текста без модели (LLM_DEFAULT_POLICY)
+func (s *AIService) DefaultPolicy() string {
+	return s.cfg.LLMDefaultPolicy
+}
+
+// TextPolicy политика перебора моделей для генерации текста: модель запроса, затем
+// модели политики из конфига (name) или резервные модели из запроса (fallback).
+// Без name и fallback — одна модель без переключений.
+func (s *AIService) TextPolicy(model, name string, fallback *domain.FallbackPolicy) (llm.Policy, error) {
+	policy := llm.Policy{Name: "request", On: llm.DefaultFallbackOn}
+	if model != "" {
+		policy.Models = []string{model}
+	}
+	switch {
+	case name != "" && fallback != nil:
+		return policy, &domain.ParamError{Field: "policy", Reason: "policy and fallback cannot be used together"}
+	case name != "":
+		configured, ok := s.llm.Policy(name)
+		if !ok {
+			return policy, &domain.ParamError{Field: "policy", Reason: fmt.Sprintf("unknown policy %q", name)}
+		}
+		policy.Name, policy.On, policy.Delay = configured.Name, configured.On, configured.Delay
+		policy.Models = appendModels(policy.Models, configured.Models...)
+	case fallback != nil:
+		if len(fallback.Models) == 0 || len(fallback.Models) > maxFallbackModels {
+			return policy, &domain.ParamError{Field: "fallback.models", Reason: fmt.Sprintf("from 1 to %d models required", maxFallbackModels)}
+		}
+		for _, m := range fallback.Models {
+			if m == "" {
+				return policy, &domain.ParamError{Field: "fallback.models", Reason: "model name must not be empty"}
+			}
+			if _, err := s.llm.Resolve(m); err != nil {
+				return policy, err
+			}
+		}
+		for _, class := range fallback.On {
+			if !llm.ValidErrorClass(class) {
+				return policy, &domain.ParamError{Field: "fallback.on", Reason: fmt.Sprintf("unknown error class %q (allowed: 429, 503, timeout, safety)", class)}
+			}
+		}
+		if len(fallback.On) > 0 {
+			policy.On = fallback.On
+		}
+		if fallback.DelayMs < 0 || fallback.DelayMs > maxFallbackDelayMs {
+			return policy, &domain.ParamError{Field: "fallback.delay_ms", Reason: fmt.Sprintf("must be between 0 and %d", maxFallbackDelayMs)}
+		}
+		policy.Delay = time.Duration(fallback.DelayMs) * time.Millisecond
+		policy.Models = appendModels(policy.Models, fallback.Models...)
+	}
+	if len(policy.Models) == 0 {
+		return policy, &domain.ParamError{Field: "model", Reason: "required"}
+	}
+	return policy, nil
+}
+
+// ValidatePolicy проверяет параметры генерации по первой модели политики, которую
+// можно вызвать с этим ключом. Ошибка, при которой политика перешла бы к следующей
+// модели (например, Gemini недоступен), не мешает начать генерацию.
+func (s *AIService) ValidatePolicy(ctx context.Context, policy llm.Policy, apiKey string, params domain.GenerationParams, stream bool) error {
+	for i, model := range policy.Models {
+		provider, err := s.llm.Resolve(model)
+		if err != nil {
+			return err
+		}
+		if provider.Capabilities(model).RequiresAPIKey && apiKey == "" {
+			continue
+		}
+		err = s.ValidateParams(ctx, model, apiKey, params, stream)
+		if err != nil && i < len(policy.Models)-1 && policy.FallsBackOn(err) {
+			return nil
+		}
+		return err
+	}
+	return domain.ErrMissingAPIKey
+}
+
+// runPolicy вызывает attempt для моделей политики по очереди, пока одна из них не
+// ответит. К следующей модели переходим при ошибке из классов политики, выждав паузу
+// политики, а также без паузы, если модели нужен ключ, которого нет, или параметры
+// выходят за лимиты резервной модели. Каждый переход пишется в лог, считается в
+// статистике и передаётся в onFallback (может быть nil). Возвращает модель, которая
+// ответила, и переходы до неё.
+func (s *AIService) runPolicy(ctx context.Context, policy llm.Policy, apiKey string, params domain.GenerationParams, stream bool,
+	onFallback func(domain.FallbackEvent), attempt func(provider llm.Provider, model string) error) (string, []domain.FallbackEvent, error) {
+	var (
+		events  []domain.FallbackEvent
+		lastErr error
+	)
+	for i, model := range policy.Models {
+		provider, err := s.llm.Resolve(model)
+		if err != nil {
+			return model, events, err
+		}
+
+		var reason string
+		var paramErr *domain.ParamError
+		var final *finalError
+		switch {
+		case provider.Capabilities(model).RequiresAPIKey && apiKey == "":
+			reason, err = fallbackMissingKey, domain.ErrMissingAPIKey
+		default:
+			err = s.ValidateParams(ctx, model, apiKey, params, stream)
+			if err == nil {
+				err = attempt(provider, model)
+			}
+			switch {
+			case err == nil:
+				return model, events, nil
+			case errors.As(err, &final):
+				return model, events, final.err
+			case errors.As(err, &paramErr) && i > 0:
+				reason = fallbackInvalidParams
+			case policy.FallsBackOn(err):
+				reason = llm.ErrorClass(err)
+			default:
+				return model, events, err
+			}
+		}
+		if lastErr == nil || reason != fallbackMissingKey {
+			lastErr = err
+		}
+		if i == len(policy.Models)-1 {
+			break
+		}
+
+		event := domain.FallbackEvent{Model: model, Next: policy.Models[i+1], Reason: reason, Error: err.Error()}
+		logger.L.Warn("falling back to next model", "policy", policy.Name, "model", model, "next", event.Next, "reason", reason, "error", event.Error)
+		s.stats.fallback(event)
+		events = append(events, event)
+		if onFallback != nil {
+			onFallback(event)
+		}
+		if policy.Delay > 0 && reason != fallbackMissingKey && reason != fallbackInvalidParams {
+			timer := time.NewTimer(policy.Delay)
+			select {
+			case <-ctx.Done():
+				timer.Stop()
+				return model, events, ctx.Err()
+			case <-timer.C:
+			}
+		}
+	}
+	return policy.Models[len(policy.Models)-1], events, lastErr
+}
+
+// appendModels добавляет модели в конец списка, пропуская уже имеющиеся
+func appendModels(models []string, more ...string) []string {
+	for _, m := range more {
+		seen := false
+		for _, existing := range models {
+			if existing == m {
+				seen = true
+				break
+			}
+		}
+		if !seen {
+			models = append(models, m)
+		}
+	}
+	return models
+}
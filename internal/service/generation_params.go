@@ -0,0 +1,92 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"geminiBackend/internal/domain"
+	"geminiBackend/internal/provider/llm"
+	"strings"
+)
+
+// paramLimits допустимые значения параметров генерации для модели; 0 — лимит неизвестен
+type paramLimits struct {
+	MaxTemperature   float32
+	MaxTopK          int
+	MaxOutputTokens  int
+	MaxCandidates    int
+	MaxStopSequences int
+}
+
+// ValidateParams проверяет параметры генерации по лимитам модели. Общие лимиты берутся
+// из возможностей поставщика; лимиты temperature, top_k и max_output_tokens конкретной
+// модели запрашиваются у поставщика (Gemini), только если эти параметры заданы.
+// Потоковая генерация поддерживает один вариант ответа.
+func (s *AIService) ValidateParams(ctx context.Context, model, apiKey string, p domain.GenerationParams, stream bool) error {
+	provider, err := s.llm.Resolve(model)
+	if err != nil {
+		return err
+	}
+	caps := provider.Capabilities(model)
+	limits := paramLimits{
+		MaxTemperature:   caps.MaxTemperature,
+		MaxOutputTokens:  caps.MaxOutputTokens,
+		MaxCandidates:    caps.MaxCandidates,
+		MaxStopSequences: caps.MaxStopSequences,
+	}
+	describer, ok := provider.(llm.ModelDescriber)
+	if ok && (p.Temperature != nil || p.TopK != nil || p.MaxOutputTokens != nil) {
+		info, err := describer.DescribeModel(ctx, apiKey, model)
+		if err != nil {
+			return err
+		}
+		if info.MaxTemperature > 0 {
+			limits.MaxTemperature = info.MaxTemperature
+		}
+		limits.MaxTopK = int(info.MaxTopK)
+		limits.MaxOutputTokens = int(info.OutputTokenLimit)
+	}
+	if stream {
+		limits.MaxCandidates = 1
+	}
+	return validateParams(p, limits)
+}
+
+func validateParams(p domain.GenerationParams, lim paramLimits) error {
+	if p.Temperature != nil && (*p.Temperature < 0 || *p.Temperature > lim.MaxTemperature) {
+		return &domain.ParamError{Field: "temperature", Reason: fmt.Sprintf("must be between 0 and %g for this model", lim.MaxTemperature)}
+	}
+	if p.TopP != nil && (*p.TopP < 0 || *p.TopP > 1) {
+		return &domain.ParamError{Field: "top_p", Reason: "must be between 0 and 1"}
+	}
+	if p.TopK != nil {
+		if *p.TopK < 1 {
+			return &domain.ParamError{Field: "top_k", Reason: "must be at least 1"}
+		}
+		if lim.MaxTopK > 0 && *p.TopK > lim.MaxTopK {
+			return &domain.ParamError{Field: "top_k", Reason: fmt.Sprintf("must not exceed %d for this model", lim.MaxTopK)}
+		}
+	}
+	if p.MaxOutputTokens != nil {
+		if *p.MaxOutputTokens < 1 {
+			return &domain.ParamError{Field: "max_output_tokens", Reason: "must be at least 1"}
+		}
+		if lim.MaxOutputTokens > 0 && *p.MaxOutputTokens > lim.MaxOutputTokens {
+			return &domain.ParamError{Field: "max_output_tokens", Reason: fmt.Sprintf("must not exceed %d for this model", lim.MaxOutputTokens)}
+		}
+	}
+	if lim.MaxStopSequences > 0 && len(p.StopSequences) > lim.MaxStopSequences {
+		return &domain.ParamError{Field: "stop_sequences", Reason: fmt.Sprintf("at most %d sequences allowed", lim.MaxStopSequences)}
+	}
+	for _, stop := range p.StopSequences {
+		if strings.TrimSpace(stop) == "" {
+			return &domain.ParamError{Field: "stop_sequences", Reason: "must not contain empty values"}
+		}
+	}
+	if p.CandidateCount != nil && (*p.CandidateCount < 1 || *p.CandidateCount > lim.MaxCandidates) {
+		if lim.MaxCandidates == 1 {
+			return &domain.ParamError{Field: "candidate_count", Reason: "only 1 candidate is supported here"}
+		}
+		return &domain.ParamError{Field: "candidate_count", Reason: fmt.Sprintf("must be between 1 and %d", lim.MaxCandidates)}
+	}
+	return nil
+}
@@ -0,0 +1,301 @@
+package service
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"geminiBackend/internal/domain"
+	"geminiBackend/internal/provider/db"
+	"geminiBackend/internal/provider/llm"
+	"geminiBackend/pkg/logger"
+	"geminiBackend/pkg/splitter"
+	"math"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// maxKBTopK макс. фрагментов, которые можно запросить для одного ответа
+const maxKBTopK = 20
+
+// askInstruction системный промпт ответа по фрагментам базы знаний
+const askInstruction = "Ответь на вопрос пользователя, опираясь только на пронумерованные фрагменты его документов. " +
+	"После каждого утверждения ставь ссылку на фрагмент, из которого оно взято, в квадратных скобках, например [2]. " +
+	"Если во фрагментах нет ответа, так и скажи и ничего не придумывай. Отвечай на языке вопроса."
+
+// sourceRef ссылка на источник [n] в ответе модели
+var sourceRef = regexp.MustCompile(`\[(\d+)\]`)
+
+type KnowledgeService struct {
+	db *sql.DB
+	ai *AIService
+}
+
+func NewKnowledgeService(db *sql.DB, ai *AIService) *KnowledgeService {
+	return &KnowledgeService{db: db, ai: ai}
+}
+
+// AskModel модель ответа по базе знаний: явно указанная, Gemini при наличии ключа,
+// иначе локальная (если она настроена)
+func (s *KnowledgeService) AskModel(requested string, hasKey bool) string {
+	if requested != "" {
+		return requested
+	}
+	if !hasKey && s.ai.cfg.LocalAskModel != "" {
+		return s.ai.cfg.LocalAskModel
+	}
+	return defaultModelForDocuments
+}
+
+// AddDocument извлекает текст документа, режет его на фрагменты, строит их эмбеддинги
+// моделью model и сохраняет документ в базе знаний пользователя. Ошибки проверки — *domain.ParamError.
+func (s *KnowledgeService) AddDocument(ctx context.Context, tgID int, model, apiKey, filename string, data []byte) (*domain.KBDocument, error) {
+	format, err := s.ai.detectDocument(filename, data)
+	if err != nil {
+		return nil, err
+	}
+	doc, err := s.ai.extractDocument(format, data)
+	if err != nil {
+		return nil, err
+	}
+	chunks, dims, err := s.index(ctx, model, apiKey, doc.Pages)
+	if err != nil {
+		return nil, err
+	}
+	record := &domain.KBDocument{TgID: tgID, Filename: filename, Format: format, Model: model, Dimensions: dims}
+	if err := db.NewKnowledgeProvider(s.db).CreateDocument(record, doc.Pages, chunks); err != nil {
+		return nil, err
+	}
+	logger.L.Info("knowledge base document indexed", "document", record.ID, "pages", record.Pages, "chunks", record.Chunks, "model", model)
+	return record, nil
+}
+
+func (s *KnowledgeService) List(tgID, limit, offset int) ([]domain.KBDocument, int, error) {
+	return db.NewKnowledgeProvider(s.db).ListDocuments(tgID, limit, offset)
+}
+
+// Find возвращает документ пользователя
+func (s *KnowledgeService) Find(tgID int, id int64) (*domain.KBDocument, error) {
+	return db.NewKnowledgeProvider(s.db).GetDocument(id, tgID)
+}
+
+func (s *KnowledgeService) Delete(tgID int, id int64) error {
+	return db.NewKnowledgeProvider(s.db).DeleteDocument(id, tgID)
+}
+
+// Reindex заново режет сохранённый текст документа (по текущим KB_CHUNK_CHARS и
+// KB_CHUNK_OVERLAP) и строит эмбеддинги моделью model; пустой model — прежняя модель документа
+func (s *KnowledgeService) Reindex(ctx context.Context, tgID int, id int64, model, apiKey string) (*domain.KBDocument, error) {
+	kb := db.NewKnowledgeProvider(s.db)
+	doc, err := kb.GetDocument(id, tgID)
+	if err != nil {
+		return nil, err
+	}
+	if model == "" {
+		model = doc.Model
+	}
+	pages, err := kb.DocumentPages(id, tgID)
+	if err != nil {
+		return nil, err
+	}
+	chunks, dims, err := s.index(ctx, model, apiKey, pages)
+	if err != nil {
+		return nil, err
+	}
+	if err := kb.ReplaceChunks(id, tgID, model, dims, chunks); err != nil {
+		return nil, err
+	}
+	logger.L.Info("knowledge base document reindexed", "document", id, "chunks", len(chunks), "model", model)
+	return kb.GetDocument(id, tgID)
+}
+
+// index режет страницы на фрагменты и строит их эмбеддинги; возвращает фрагменты
+// и размерность векторов. Фрагменты отправляются пачками по EMBEDDINGS_MAX_TEXTS.
+func (s *KnowledgeService) index(ctx context.Context, model, apiKey string, pages []string) ([]domain.KBChunk, int, error) {
+	var chunks []domain.KBChunk
+	for i, page := range pages {
+		for _, text := range splitter.Texts(splitter.Split(page, splitter.Options{MaxSize: s.ai.cfg.KBChunkChars, Overlap: s.ai.cfg.KBChunkOverlap})) {
+			if strings.TrimSpace(text) == "" {
+				continue
+			}
+			chunks = append(chunks, domain.KBChunk{Index: len(chunks), Page: i + 1, Text: text})
+		}
+	}
+	if len(chunks) == 0 {
+		return nil, 0, &domain.ParamError{Field: "file", Reason: "document has no text to index"}
+	}
+
+	size := s.ai.cfg.EmbeddingsMaxTexts
+	if size < 1 {
+		size = len(chunks)
+	}
+	for start := 0; start < len(chunks); start += size {
+		batch := chunks[start:min(start+size, len(chunks))]
+		texts := make([]string, len(batch))
+		for i, c := range batch {
+			texts[i] = c.Text
+		}
+		resp, err := s.ai.Embed(ctx, llm.EmbedRequest{Model: model, APIKey: apiKey, Inputs: texts, TaskType: domain.EmbedTaskRetrievalDocument})
+		if err != nil {
+			return nil, 0, err
+		}
+		for i, vec := range resp.Vectors {
+			batch[i].Vector = vec
+		}
+	}
+	return chunks, len(chunks[0].Vector), nil
+}
+
+// Ask ищет фрагменты базы знаний, ближайшие к вопросу, и отвечает на него моделью model
+// по этим фрагментам. Вопрос переводится в вектор каждой моделью эмбеддингов, которой
+// проиндексированы документы пользователя, и сравнивается с фрагментами перебором по
+// косинусной близости (см. search). В промпт попадают top_k лучших фрагментов, сколько
+// поместится во вход модели; все они возвращаются как источники ответа.
+func (s *KnowledgeService) Ask(ctx context.Context, tgID int, model, apiKey string, req domain.AskRequest) (*domain.AskResponse, error) {
+	question := strings.TrimSpace(req.Question)
+	if question == "" {
+		return nil, &domain.ParamError{Field: "question", Reason: "required"}
+	}
+	topK := req.TopK
+	if topK == 0 {
+		topK = s.ai.cfg.KBTopK
+	}
+	if topK < 1 || topK > maxKBTopK {
+		return nil, &domain.ParamError{Field: "top_k", Reason: fmt.Sprintf("must be between 1 and %d", maxKBTopK)}
+	}
+	provider, err := s.ai.llm.Resolve(model)
+	if err != nil {
+		return nil, err
+	}
+
+	kb := db.NewKnowledgeProvider(s.db)
+	for _, id := range req.DocumentIDs {
+		if _, err := kb.GetDocument(id, tgID); err != nil {
+			return nil, err
+		}
+	}
+	chunks, err := kb.ListChunks(tgID, req.DocumentIDs)
+	if err != nil {
+		return nil, err
+	}
+	if len(chunks) == 0 {
+		return nil, domain.ErrKBEmpty
+	}
+	found, err := s.search(ctx, apiKey, question, chunks, topK)
+	if err != nil {
+		return nil, err
+	}
+
+	// Фрагменты сверх входного лимита модели отбрасываются, начиная с наименее близких
+	limit := s.ai.HistoryLimit(model)
+	var sb strings.Builder
+	sb.WriteString("Фрагменты документов:\n\n")
+	size := utf8.RuneCountInString(question) + utf8.RuneCountInString(sb.String()) + 16
+	resp := &domain.AskResponse{Model: model, Citations: make([]domain.Citation, 0, len(found))}
+	for _, f := range found {
+		source := fmt.Sprintf("[%d] %s, стр. %d\n%s\n\n", len(resp.Citations)+1, f.chunk.Filename, f.chunk.Page, f.chunk.Text)
+		n := utf8.RuneCountInString(source)
+		if size+n > limit && len(resp.Citations) > 0 {
+			break
+		}
+		size += n
+		sb.WriteString(source)
+		resp.Citations = append(resp.Citations, domain.Citation{
+			Source:     len(resp.Citations) + 1,
+			DocumentID: f.chunk.DocumentID,
+			Filename:   f.chunk.Filename,
+			Chunk:      f.chunk.Index,
+			Page:       f.chunk.Page,
+			Score:      f.score,
+			Text:       f.chunk.Text,
+		})
+	}
+	sb.WriteString("Вопрос: " + question)
+	logger.L.Info("answering from knowledge base", "chunks", len(chunks), "sources", len(resp.Citations), "model", model)
+
+	result, err := provider.Generate(ctx, llm.UserPrompt(model, apiKey, sb.String(), domain.GenerationParams{SystemInstruction: askInstruction}))
+	if err != nil {
+		return nil, err
+	}
+	resp.Answer = result.Text()
+	resp.Usage = result.Usage
+	for _, m := range sourceRef.FindAllStringSubmatch(resp.Answer, -1) {
+		if n, err := strconv.Atoi(m[1]); err == nil && n >= 1 && n <= len(resp.Citations) {
+			resp.Citations[n-1].Cited = true
+		}
+	}
+	return resp, nil
+}
+
+// scoredChunk фрагмент с близостью к вопросу
+type scoredChunk struct {
+	chunk domain.KBChunk
+	score float64
+}
+
+// search topK фрагментов, ближайших к вопросу. Вопрос переводится в вектор каждой
+// моделью, которой построены векторы фрагментов; фрагменты другой размерности пропускаются.
+// Близость по векторам разных моделей несравнима, поэтому фрагменты ранжируются внутри
+// своей модели, а списки моделей сливаются по местам: сначала первые места всех моделей,
+// затем вторые; внутри одного места — по близости.
+func (s *KnowledgeService) search(ctx context.Context, apiKey, question string, chunks []domain.KBChunk, topK int) ([]scoredChunk, error) {
+	var models []string
+	queries := make(map[string][]float32)
+	ranked := make(map[string][]scoredChunk)
+	for _, c := range chunks {
+		q, ok := queries[c.Model]
+		if !ok {
+			resp, err := s.ai.Embed(ctx, llm.EmbedRequest{Model: c.Model, APIKey: apiKey, Inputs: []string{question}, TaskType: domain.EmbedTaskRetrievalQuery})
+			if err != nil {
+				return nil, err
+			}
+			q = resp.Vectors[0]
+			queries[c.Model] = q
+			models = append(models, c.Model)
+		}
+		if len(q) != len(c.Vector) {
+			continue
+		}
+		ranked[c.Model] = append(ranked[c.Model], scoredChunk{chunk: c, score: cosine(q, c.Vector)})
+	}
+	for _, model := range models {
+		found := ranked[model]
+		sort.SliceStable(found, func(i, j int) bool { return found[i].score > found[j].score })
+	}
+
+	var found []scoredChunk
+	for rank := 0; len(found) < topK; rank++ {
+		var place []scoredChunk
+		for _, model := range models {
+			if rank < len(ranked[model]) {
+				place = append(place, ranked[model][rank])
+			}
+		}
+		if len(place) == 0 {
+			break
+		}
+		sort.SliceStable(place, func(i, j int) bool { return place[i].score > place[j].score })
+		found = append(found, place[:min(topK-len(found), len(place))]...)
+	}
+	if len(found) == 0 {
+		// Модель эмбеддингов теперь возвращает векторы другой размерности
+		return nil, &domain.ParamError{Field: "documents", Reason: "embedding dimensions changed since indexing; reindex the documents"}
+	}
+	return found, nil
+}
+
+// cosine косинусная близость векторов одной длины; для нулевого вектора — 0
+func cosine(a, b []float32) float64 {
+	var dot, na, nb float64
+	for i := range a {
+		dot += float64(a[i]) * float64(b[i])
+		na += float64(a[i]) * float64(a[i])
+		nb += float64(b[i]) * float64(b[i])
+	}
+	if na == 0 || nb == 0 {
+		return 0
+	}
+	return dot / math.Sqrt(na*nb)
+}
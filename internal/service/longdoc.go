@@ -0,0 +1,191 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"geminiBackend/internal/domain"
+	"geminiBackend/internal/provider/llm"
+	"geminiBackend/pkg/logger"
+	"geminiBackend/pkg/splitter"
+	"strings"
+	"unicode/utf8"
+)
+
+// reduceSeparator разделитель частичных результатов во входе свёртки
+const reduceSeparator = "\n\n---\n\n"
+
+// maxReduceLevels сколько уровней свёртки допускается, прежде чем сдаться
+const maxReduceLevels = 5
+
+// documentStrategy стратегия обработки документа по частям; ocr_correct — только concat
+func documentStrategy(task domain.DocumentTask) (string, error) {
+	switch task.Strategy {
+	case "":
+		if task.Task == domain.DocTaskOCRCorrect {
+			return domain.DocStrategyConcat, nil
+		}
+		return domain.DocStrategyMapReduce, nil
+	case domain.DocStrategyConcat:
+		return task.Strategy, nil
+	case domain.DocStrategyMapReduce, domain.DocStrategyRefine:
+		if task.Task == domain.DocTaskOCRCorrect {
+			return "", &domain.ParamError{Field: "strategy", Reason: "ocr_correct supports only concat"}
+		}
+		return task.Strategy, nil
+	}
+	return "", &domain.ParamError{Field: "strategy", Reason: "must be one of concat, map_reduce, refine"}
+}
+
+// mapInstruction задание для одной части большого документа (map) и первой части при refine
+func mapInstruction(task domain.DocumentTask) string {
+	switch task.Task {
+	case domain.DocTaskQuestion:
+		return "Перед тобой фрагмент большого документа. Выпиши из него всё, что помогает ответить на вопрос, сохраняя цифры и формулировки текста. Если во фрагменте ничего такого нет, ответь «нет данных».\nВопрос: " + task.Question
+	case domain.DocTaskExtract:
+		return "Перед тобой фрагмент большого документа. Извлеки из него значения полей: " + strings.Join(task.Fields, ", ") +
+			". Ответь только JSON-объектом с этими ключами; если значение во фрагменте не найдено, укажи null."
+	}
+	return "Перед тобой фрагмент большого документа. Кратко перескажи его: основные темы, выводы и важные факты. Отвечай на языке документа."
+}
+
+// reduceInstruction задание для свёртки частичных результатов, разделённых reduceSeparator
+func reduceInstruction(task domain.DocumentTask) string {
+	switch task.Task {
+	case domain.DocTaskQuestion:
+		return "Ниже по порядку выдержки из частей одного документа, разделённые строкой ---. Ответь на вопрос, опираясь только на них. Если ответа в них нет, так и скажи.\nВопрос: " + task.Question
+	case domain.DocTaskExtract:
+		return "Ниже JSON-объекты, извлечённые из частей одного документа, разделённые строкой ---. Объедини их в один JSON-объект с ключами: " +
+			strings.Join(task.Fields, ", ") + ". Для каждого поля возьми найденное значение (не null); если значения расходятся, выбери наиболее полное. Ответь только JSON-объектом."
+	}
+	return "Ниже по порядку пересказы частей одного документа, разделённые строкой ---. Объедини их в один связный краткий пересказ всего документа без повторов. Отвечай на языке документа."
+}
+
+// refineInstruction задание для уточнения текущего результата следующей частью документа
+func refineInstruction(task domain.DocumentTask) string {
+	switch task.Task {
+	case domain.DocTaskQuestion:
+		return "Ниже текущий ответ на вопрос по началу документа и следующий фрагмент. Уточни или дополни ответ сведениями из фрагмента; если в нём нет ничего по вопросу, верни ответ без изменений. Ответь только обновлённым ответом.\nВопрос: " + task.Question
+	case domain.DocTaskExtract:
+		return "Ниже JSON-объект с полями, извлечёнными из начала документа, и следующий фрагмент. Заполни поля со значением null значениями из фрагмента, найденные значения сохрани. Ответь только JSON-объектом с ключами: " +
+			strings.Join(task.Fields, ", ") + "."
+	}
+	return "Ниже текущий пересказ начала документа и следующий фрагмент. Дополни пересказ сведениями из фрагмента, сохранив важное из текущего. Ответь только обновлённым пересказом на языке документа."
+}
+
+// reduceResults сворачивает частичные результаты в один. Пока они не помещаются в один
+// запрос (limit символов), они сворачиваются группами параллельно, уровень за уровнем;
+// группа из одного результата переходит на следующий уровень без запроса. Если ни одну
+// группу собрать не удалось (каждый результат занимает почти весь лимит), результаты
+// склеиваются, как при concat. Результаты каждого уровня добавляются в steps.
+func (s *AIService) reduceResults(ctx context.Context, provider llm.Provider, req llm.Request, partials []string, limit int, steps *[]domain.DocumentStep) (string, error) {
+	for level := 1; level <= maxReduceLevels; level++ {
+		batches := packResults(partials, limit)
+		var merge []string
+		var mergeAt []int
+		for i, batch := range batches {
+			if batch.count > 1 {
+				merge = append(merge, batch.text)
+				mergeAt = append(mergeAt, i)
+			}
+		}
+		if len(merge) == 0 {
+			if len(batches) == 1 {
+				return batches[0].text, nil
+			}
+			logger.L.Warn("partial results do not fit into one request, concatenating", "level", level, "results", len(partials))
+			return strings.Join(partials, "\n\n"), nil
+		}
+		logger.L.Info("reducing partial results", "level", level, "results", len(partials), "batches", len(batches))
+		out, err := s.runChunks(ctx, provider, req, merge, chunkHooks{})
+		if err != nil {
+			return "", fmt.Errorf("reduce level %d: %w", level, err)
+		}
+		for i, at := range mergeAt {
+			if out.errs[i] != nil {
+				continue // несвёрнутая группа уходит на следующий уровень как есть
+			}
+			batches[at].text = out.texts[i]
+			*steps = append(*steps, domain.DocumentStep{Stage: "reduce", Level: level, Index: at, Text: out.texts[i]})
+		}
+		if len(batches) == 1 {
+			return batches[0].text, nil
+		}
+		partials = make([]string, len(batches))
+		for i, batch := range batches {
+			partials[i] = batch.text
+		}
+	}
+	return "", fmt.Errorf("partial results do not fit into one request after %d reduce levels", maxReduceLevels)
+}
+
+// resultBatch группа частичных результатов, собранных через reduceSeparator
+type resultBatch struct {
+	text  string
+	count int
+}
+
+// packResults собирает результаты через reduceSeparator в группы не длиннее limit символов;
+// слишком длинный результат предварительно режется. limit < 1 — одна группа.
+func packResults(results []string, limit int) []resultBatch {
+	if limit < 1 {
+		return []resultBatch{{text: strings.Join(results, reduceSeparator), count: len(results)}}
+	}
+	sepSize := utf8.RuneCountInString(reduceSeparator)
+	var batches []resultBatch
+	var cur []string
+	size := 0
+	flush := func() {
+		batches = append(batches, resultBatch{text: strings.Join(cur, reduceSeparator), count: len(cur)})
+		cur, size = nil, 0
+	}
+	for _, result := range results {
+		for _, piece := range splitter.Texts(splitter.Split(result, splitter.Options{MaxSize: limit})) {
+			n := utf8.RuneCountInString(piece)
+			if len(cur) > 0 && size+sepSize+n > limit {
+				flush()
+			}
+			if len(cur) > 0 {
+				size += sepSize
+			}
+			cur = append(cur, piece)
+			size += n
+		}
+	}
+	if len(cur) > 0 || len(batches) == 0 {
+		flush()
+	}
+	return batches
+}
+
+// refineDocument уточняет результат последовательно: первая часть обрабатывается
+// map-заданием, каждая следующая — вместе с текущим результатом. Части режутся по
+// половине лимита, чтобы рядом с ними поместился текущий результат.
+func (s *AIService) refineDocument(ctx context.Context, provider llm.Provider, req llm.Request, task domain.DocumentTask,
+	chunks []pageChunk, limit int, steps *[]domain.DocumentStep) (string, error) {
+	var pieces []string
+	for _, chunk := range chunks {
+		pieces = append(pieces, s.splitPrompt(chunk.text, limit/2)...)
+	}
+	logger.L.Info("refining document", "chunks", len(pieces))
+
+	result := ""
+	for i, piece := range pieces {
+		if err := ctx.Err(); err != nil {
+			logger.L.Info("document refine canceled, remaining chunks abandoned", "done", i, "chunks", len(pieces))
+			return "", err
+		}
+		content := piece
+		req.Params.SystemInstruction = mapInstruction(task)
+		if i > 0 {
+			req.Params.SystemInstruction = refineInstruction(task)
+			content = "Текущий результат:\n" + result + "\n\nСледующий фрагмент:\n" + piece
+		}
+		resp, err := s.generateChunk(ctx, provider, req, i, content)
+		if err != nil {
+			return "", fmt.Errorf("chunk %d failed: %w", i, err)
+		}
+		result = resp.Text()
+		*steps = append(*steps, domain.DocumentStep{Stage: "refine", Index: i, Text: result})
+	}
+	return result, nil
+}
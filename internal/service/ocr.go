@@ -0,0 +1,111 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"geminiBackend/internal/domain"
+	"geminiBackend/internal/provider/llm"
+	"geminiBackend/pkg/logger"
+	"geminiBackend/pkg/splitter"
+	"geminiBackend/pkg/textdiff"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// ocrTemperature низкая температура: исправление, а не пересказ
+const ocrTemperature = float32(0.1)
+
+// maxOCRLanguage макс. длина подсказки языка в символах
+const maxOCRLanguage = 32
+
+// OCRModel модель для исправления OCR: явно указанная или OCR_MODEL
+func (s *AIService) OCRModel(requested string) string {
+	if requested != "" {
+		return requested
+	}
+	return s.cfg.OCRModel
+}
+
+// ocrInstruction системный промпт исправления OCR с учётом языка и строгости
+func ocrInstruction(language, strictness string) string {
+	var sb strings.Builder
+	sb.WriteString("Ты корректируешь текст после OCR-распознавания. ")
+	switch strictness {
+	case domain.OCRStrictnessLight:
+		sb.WriteString("Исправляй только явные ошибки распознавания: перепутанные похожие символы (0 и О, 1 и l, rn и m), разорванные и слипшиеся слова, переносы внутри слов. Орфографию, пунктуацию и стиль автора не трогай.")
+	case domain.OCRStrictnessAggressive:
+		sb.WriteString("Исправляй ошибки распознавания, опечатки, орфографию и пунктуацию, склеивай строки, разорванные посреди предложения, сохраняй абзацы. Смысл и стиль текста не меняй.")
+	default:
+		sb.WriteString("Исправляй опечатки и ошибки распознавания, сохраняй форматирование и абзацы.")
+	}
+	sb.WriteString(" Не добавляй новое содержание, только исправляй существующий текст.")
+	if language != "" {
+		sb.WriteString(" Язык текста: " + language + ".")
+	}
+	return sb.String()
+}
+
+// ocrParams параметры генерации для исправления OCR
+func ocrParams(language, strictness string) domain.GenerationParams {
+	temperature := ocrTemperature
+	return domain.GenerationParams{SystemInstruction: ocrInstruction(language, strictness), Temperature: &temperature}
+}
+
+// CorrectOCR исправляет текст после OCR и сравнивает результат с исходным по словам.
+// Длинный текст исправляется по частям без повтора (LLM_CHUNK_OVERLAP не применяется);
+// часть, которую не удалось обработать, остаётся как есть и попадает в FailedChunks.
+// Ошибки проверки входных данных — *domain.ParamError.
+func (s *AIService) CorrectOCR(ctx context.Context, model, apiKey string, req domain.OCRCorrectRequest) (domain.OCRCorrectResponse, error) {
+	if strings.TrimSpace(req.Text) == "" {
+		return domain.OCRCorrectResponse{}, &domain.ParamError{Field: "text", Reason: "required"}
+	}
+	switch req.Strictness {
+	case "":
+		req.Strictness = domain.OCRStrictnessNormal
+	case domain.OCRStrictnessLight, domain.OCRStrictnessNormal, domain.OCRStrictnessAggressive:
+	default:
+		return domain.OCRCorrectResponse{}, &domain.ParamError{Field: "strictness", Reason: "must be one of light, normal, aggressive"}
+	}
+	req.Language = strings.TrimSpace(req.Language)
+	if utf8.RuneCountInString(req.Language) > maxOCRLanguage || strings.ContainsAny(req.Language, "\r\n") {
+		return domain.OCRCorrectResponse{}, &domain.ParamError{Field: "language", Reason: fmt.Sprintf("must be a single line of at most %d characters", maxOCRLanguage)}
+	}
+	provider, err := s.llm.Resolve(model)
+	if err != nil {
+		return domain.OCRCorrectResponse{}, err
+	}
+
+	chunks := splitter.Texts(splitter.Split(req.Text, splitter.Options{MaxSize: provider.Capabilities(model).MaxInputChars}))
+	if len(chunks) > 1 {
+		logger.L.Info("correcting OCR text in chunks", "total_chars", utf8.RuneCountInString(req.Text), "chunks", len(chunks))
+	}
+	out, err := s.runChunks(ctx, provider, llm.UserPrompt(model, apiKey, "", ocrParams(req.Language, req.Strictness)), chunks, chunkHooks{})
+	if err != nil {
+		return domain.OCRCorrectResponse{}, err
+	}
+
+	// Ответ модели без пробелов по краям; пробелы и переносы на границах частей берутся из исходного текста.
+	// Части сравниваются по отдельности: правки не выходят за границы частей, а сравнение
+	// длинного текста не растёт квадратично с числом правок во всём тексте.
+	var sb strings.Builder
+	parts := make([][]textdiff.Edit, len(chunks))
+	for i, chunk := range chunks {
+		corrected := chunk
+		start := len(chunk) - len(strings.TrimLeftFunc(chunk, unicode.IsSpace))
+		end := len(strings.TrimRightFunc(chunk, unicode.IsSpace))
+		if out.errs[i] == nil && start < len(chunk) {
+			corrected = chunk[:start] + strings.TrimSpace(out.texts[i]) + chunk[end:]
+		}
+		sb.WriteString(corrected)
+		parts[i] = textdiff.Words(chunk, corrected)
+	}
+	text := sb.String()
+
+	edits := textdiff.Join(parts...)
+	resp := domain.OCRCorrectResponse{Model: model, Text: text, Diff: make([]domain.TextEdit, len(edits)), ChangeRatio: textdiff.Ratio(edits), FailedChunks: out.failed}
+	for i, e := range edits {
+		resp.Diff[i] = domain.TextEdit{Op: e.Op, Text: e.Text}
+	}
+	return resp, nil
+}
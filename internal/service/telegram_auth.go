@@ -0,0 +1,141 @@
+package service
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"geminiBackend/internal/domain"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// допустимое расхождение часов, если auth_date немного в будущем
+const telegramClockSkew = time.Minute
+
+// telegramIdentity проверенные данные пользователя Telegram
+type telegramIdentity struct {
+	TgID     int
+	Username string
+}
+
+// verifyTelegramWidget проверяет подпись Telegram Login Widget.
+// Секрет — SHA256(bot_token), подпись — HMAC-SHA256 от data-check-string.
+func verifyTelegramWidget(botToken string, data *domain.TelegramAuthData, maxAge time.Duration, now time.Time) (telegramIdentity, error) {
+	if data.ID <= 0 || data.Hash == "" {
+		return telegramIdentity{}, domain.ErrInvalidSignature
+	}
+	fields := map[string]string{
+		"id":        strconv.FormatInt(data.ID, 10),
+		"auth_date": strconv.FormatInt(data.AuthDate, 10),
+	}
+	if data.FirstName != "" {
+		fields["first_name"] = data.FirstName
+	}
+	if data.LastName != "" {
+		fields["last_name"] = data.LastName
+	}
+	if data.Username != "" {
+		fields["username"] = data.Username
+	}
+	if data.PhotoURL != "" {
+		fields["photo_url"] = data.PhotoURL
+	}
+
+	secret := sha256.Sum256([]byte(botToken))
+	if !checkTelegramHash(secret[:], fields, data.Hash) {
+		return telegramIdentity{}, domain.ErrInvalidSignature
+	}
+	if err := checkAuthDate(data.AuthDate, maxAge, now); err != nil {
+		return telegramIdentity{}, err
+	}
+	return telegramIdentity{TgID: int(data.ID), Username: firstNonEmpty(data.Username, data.FirstName)}, nil
+}
+
+// verifyTelegramInitData проверяет initData из Telegram Mini App.
+// Секрет — HMAC-SHA256(key="WebAppData", bot_token).
+func verifyTelegramInitData(botToken, initData string, maxAge time.Duration, now time.Time) (telegramIdentity, error) {
+	values, err := url.ParseQuery(initData)
+	if err != nil {
+		return telegramIdentity{}, domain.ErrInvalidSignature
+	}
+	hash := values.Get("hash")
+	if hash == "" {
+		return telegramIdentity{}, domain.ErrInvalidSignature
+	}
+	fields := make(map[string]string, len(values))
+	for k := range values {
+		if k != "hash" {
+			fields[k] = values.Get(k)
+		}
+	}
+
+	mac := hmac.New(sha256.New, []byte("WebAppData"))
+	mac.Write([]byte(botToken))
+	if !checkTelegramHash(mac.Sum(nil), fields, hash) {
+		return telegramIdentity{}, domain.ErrInvalidSignature
+	}
+
+	authDate, err := strconv.ParseInt(fields["auth_date"], 10, 64)
+	if err != nil {
+		return telegramIdentity{}, domain.ErrInvalidSignature
+	}
+	if err := checkAuthDate(authDate, maxAge, now); err != nil {
+		return telegramIdentity{}, err
+	}
+
+	var user struct {
+		ID        int64  `json:"id"`
+		Username  string `json:"username"`
+		FirstName string `json:"first_name"`
+	}
+	if err := json.Unmarshal([]byte(fields["user"]), &user); err != nil || user.ID <= 0 {
+		return telegramIdentity{}, domain.ErrInvalidSignature
+	}
+	return telegramIdentity{TgID: int(user.ID), Username: firstNonEmpty(user.Username, user.FirstName)}, nil
+}
+
+// checkTelegramHash собирает data-check-string (key=value, отсортированные по ключу,
+// через \n) и сравнивает HMAC с переданным hash за константное время
+func checkTelegramHash(secret []byte, fields map[string]string, hash string) bool {
+	keys := make([]string, 0, len(fields))
+	for k := range fields {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	lines := make([]string, len(keys))
+	for i, k := range keys {
+		lines[i] = k + "=" + fields[k]
+	}
+
+	expected, err := hex.DecodeString(hash)
+	if err != nil {
+		return false
+	}
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(strings.Join(lines, "\n")))
+	return hmac.Equal(mac.Sum(nil), expected)
+}
+
+func checkAuthDate(authDate int64, maxAge time.Duration, now time.Time) error {
+	issued := time.Unix(authDate, 0)
+	if issued.After(now.Add(telegramClockSkew)) {
+		return domain.ErrInvalidSignature
+	}
+	if now.Sub(issued) > maxAge {
+		return domain.ErrAuthDataExpired
+	}
+	return nil
+}
+
+func firstNonEmpty(values ...string) string {
+	for _, v := range values {
+		if v != "" {
+			return v
+		}
+	}
+	return ""
+}
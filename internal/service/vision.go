@@ -0,0 +1,107 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"geminiBackend/internal/domain"
+	"geminiBackend/internal/provider/llm"
+	"net/http"
+)
+
+const (
+	// defaultVisionPrompt вопрос к изображениям, если пользователь его не задал
+	defaultVisionPrompt = "Опиши, что изображено."
+	// defaultModelForVision модель Gemini по умолчанию для изображений
+	defaultModelForVision = "gemini-2.5-flash"
+)
+
+// MIME-типы изображений, которые принимает /ai/vision
+var visionImageTypes = map[string]bool{
+	"image/jpeg": true,
+	"image/png":  true,
+	"image/webp": true,
+	"image/heic": true,
+}
+
+// DetectImageType определяет MIME-тип изображения по содержимому, а не по заголовку
+// клиента. HEIC стандартная библиотека не распознаёт — проверяем brand в ftyp-боксе.
+func DetectImageType(data []byte) string {
+	if len(data) >= 12 && bytes.Equal(data[4:8], []byte("ftyp")) {
+		switch string(data[8:12]) {
+		case "heic", "heix", "heim", "heis", "hevc", "hevx", "mif1", "msf1":
+			return "image/heic"
+		}
+	}
+	return http.DetectContentType(data)
+}
+
+// VisionModel выбирает модель для анализа изображений: явно указанную, Gemini при
+// наличии ключа, иначе локальную vision-модель (если она настроена)
+func (s *AIService) VisionModel(requested string, hasKey bool) string {
+	if requested != "" {
+		return requested
+	}
+	if !hasKey && s.cfg.LocalVisionModel != "" {
+		return s.cfg.LocalVisionModel
+	}
+	return defaultModelForVision
+}
+
+// AnalyzeImages проверяет изображения (тип по содержимому, размер, количество) и
+// отправляет их модели вместе с prompt. Ошибки проверки — *domain.ParamError.
+func (s *AIService) AnalyzeImages(ctx context.Context, model, apiKey, prompt string, images []domain.Attachment) (string, error) {
+	provider, err := s.llm.Resolve(model)
+	if err != nil {
+		return "", err
+	}
+	if err := s.validateImages(provider.Capabilities(model), images); err != nil {
+		return "", err
+	}
+	if prompt == "" {
+		prompt = defaultVisionPrompt
+	}
+
+	req := llm.UserPrompt(model, apiKey, prompt, domain.GenerationParams{})
+	req.Attachments = images
+	resp, err := provider.Generate(ctx, req)
+	if err != nil {
+		return "", err
+	}
+	return resp.Text(), nil
+}
+
+func (s *AIService) validateImages(caps llm.Capabilities, images []domain.Attachment) error {
+	if len(images) == 0 {
+		return &domain.ParamError{Field: "images", Reason: "at least one image required"}
+	}
+	if len(images) > s.cfg.VisionMaxImages {
+		return &domain.ParamError{Field: "images", Reason: fmt.Sprintf("at most %d images allowed", s.cfg.VisionMaxImages)}
+	}
+	maxSize := s.cfg.VisionMaxImageMB << 20
+	total := 0
+	for i := range images {
+		img := &images[i]
+		if len(img.Data) > maxSize {
+			return &domain.ParamError{Field: "images", Reason: fmt.Sprintf("image %d exceeds %d MB", i+1, s.cfg.VisionMaxImageMB)}
+		}
+		img.MIMEType = DetectImageType(img.Data)
+		if !visionImageTypes[img.MIMEType] {
+			return &domain.ParamError{Field: "images", Reason: fmt.Sprintf("image %d has unsupported type %s (allowed: JPEG, PNG, WebP, HEIC)", i+1, img.MIMEType)}
+		}
+		// Поставщик может понимать не все типы: Ollama, например, не декодирует HEIC
+		if !caps.Accepts(img.MIMEType) {
+			return &domain.ParamError{Field: "images", Reason: fmt.Sprintf("image %d has type %s not supported by this model", i+1, img.MIMEType)}
+		}
+		total += len(img.Data)
+	}
+	if caps.MaxInlineBytes > 0 && total > caps.MaxInlineBytes {
+		return &domain.ParamError{Field: "images", Reason: fmt.Sprintf("total size exceeds model inline limit of %d MB", caps.MaxInlineBytes>>20)}
+	}
+	return nil
+}
+
+// VisionUploadLimit максимальный размер multipart-запроса к /ai/vision (изображения + поля формы)
+func (s *AIService) VisionUploadLimit() int64 {
+	return int64(s.cfg.VisionMaxImages)*int64(s.cfg.VisionMaxImageMB<<20) + 1<<20
+}
@@ -0,0 +1,267 @@
+// Package docextract извлекает текст из загруженных документов (PDF, DOCX, TXT, Markdown)
+// с разбиением по страницам.
+package docextract
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/ledongthuc/pdf"
+)
+
+// Форматы документов
+const (
+	FormatPDF      = "pdf"
+	FormatDOCX     = "docx"
+	FormatText     = "txt"
+	FormatMarkdown = "md"
+)
+
+var (
+	ErrUnsupportedFormat = errors.New("unsupported document format")
+	ErrEncrypted         = errors.New("document is password protected")
+	ErrTooLarge          = errors.New("extracted document exceeds size limit")
+)
+
+// Document извлечённый текст документа по страницам. Для TXT/MD страницы разделяются
+// символом \f, для DOCX — явными разрывами страниц; без разрывов страница одна.
+type Document struct {
+	Format string
+	Pages  []string
+}
+
+// Text весь текст документа, страницы разделены пустой строкой
+func (d *Document) Text() string {
+	return strings.Join(d.Pages, "\n\n")
+}
+
+// Detect определяет формат по содержимому; для текстовых файлов различает
+// Markdown и обычный текст по расширению имени файла
+func Detect(filename string, data []byte) (string, error) {
+	switch {
+	case bytes.HasPrefix(data, []byte("%PDF-")):
+		return FormatPDF, nil
+	case bytes.HasPrefix(data, []byte("PK\x03\x04")):
+		if zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data))); err == nil {
+			for _, f := range zr.File {
+				if f.Name == "word/document.xml" {
+					return FormatDOCX, nil
+				}
+			}
+		}
+		return "", ErrUnsupportedFormat
+	case utf8.Valid(data) && !bytes.ContainsRune(data, 0):
+		switch strings.ToLower(filepath.Ext(filename)) {
+		case ".md", ".markdown":
+			return FormatMarkdown, nil
+		}
+		return FormatText, nil
+	}
+	return "", ErrUnsupportedFormat
+}
+
+// Extract извлекает текст документа указанного формата. maxSize ограничивает в байтах
+// распакованное содержимое DOCX и извлечённый текст PDF (сжатый файл может разворачиваться
+// в гигабайты); при превышении — ErrTooLarge. maxSize < 1 — без ограничения.
+func Extract(format string, data []byte, maxSize int64) (*Document, error) {
+	var (
+		pages []string
+		err   error
+	)
+	switch format {
+	case FormatPDF:
+		pages, err = extractPDF(data, maxSize)
+	case FormatDOCX:
+		pages, err = extractDOCX(data, maxSize)
+	case FormatText, FormatMarkdown:
+		text := strings.ReplaceAll(string(data), "\r\n", "\n")
+		pages = strings.Split(text, "\f")
+	default:
+		return nil, ErrUnsupportedFormat
+	}
+	if err != nil {
+		return nil, err
+	}
+	for i := range pages {
+		pages[i] = strings.TrimSpace(pages[i])
+	}
+	return &Document{Format: format, Pages: pages}, nil
+}
+
+// CountPDFPages число страниц PDF без извлечения текста
+func CountPDFPages(data []byte) (n int, err error) {
+	defer recoverPDF(&err)
+	r, err := pdf.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return 0, pdfError(err)
+	}
+	return r.NumPage(), nil
+}
+
+func extractPDF(data []byte, maxSize int64) (pages []string, err error) {
+	// Разбор повреждённых PDF может паниковать внутри библиотеки
+	defer recoverPDF(&err)
+	r, err := pdf.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return nil, pdfError(err)
+	}
+	fonts := map[string]*pdf.Font{}
+	pages = make([]string, 0, r.NumPage())
+	var size int64
+	for i := 1; i <= r.NumPage(); i++ {
+		page := r.Page(i)
+		if page.V.IsNull() {
+			pages = append(pages, "")
+			continue
+		}
+		for _, name := range page.Fonts() {
+			if _, ok := fonts[name]; !ok {
+				f := page.Font(name)
+				fonts[name] = &f
+			}
+		}
+		text, err := page.GetPlainText(fonts)
+		if err != nil {
+			return nil, fmt.Errorf("pdf page %d: %w", i, err)
+		}
+		if size += int64(len(text)); maxSize > 0 && size > maxSize {
+			return nil, ErrTooLarge
+		}
+		pages = append(pages, text)
+	}
+	return pages, nil
+}
+
+func pdfError(err error) error {
+	if errors.Is(err, pdf.ErrInvalidPassword) {
+		return ErrEncrypted
+	}
+	return fmt.Errorf("invalid pdf: %w", err)
+}
+
+func recoverPDF(err *error) {
+	if r := recover(); r != nil {
+		*err = fmt.Errorf("invalid pdf: %v", r)
+	}
+}
+
+// extractDOCX читает word/document.xml: текст из w:t, абзацы — w:p,
+// разрывы страниц — w:br w:type="page" и w:lastRenderedPageBreak
+func extractDOCX(data []byte, maxSize int64) ([]string, error) {
+	zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return nil, fmt.Errorf("invalid docx: %w", err)
+	}
+	var body io.ReadCloser
+	for _, f := range zr.File {
+		if f.Name == "word/document.xml" {
+			if body, err = f.Open(); err != nil {
+				return nil, fmt.Errorf("invalid docx: %w", err)
+			}
+			break
+		}
+	}
+	if body == nil {
+		return nil, ErrUnsupportedFormat
+	}
+	defer body.Close()
+
+	var (
+		pages  []string
+		page   strings.Builder
+		inText bool
+	)
+	newPage := func() {
+		pages = append(pages, page.String())
+		page.Reset()
+	}
+	var src io.Reader = body
+	if maxSize > 0 {
+		src = &limitedReader{r: body, n: maxSize}
+	}
+	dec := xml.NewDecoder(src)
+	for {
+		tok, err := dec.Token()
+		if err == io.EOF {
+			break
+		}
+		if errors.Is(err, ErrTooLarge) {
+			return nil, ErrTooLarge
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid docx: %w", err)
+		}
+		switch t := tok.(type) {
+		case xml.StartElement:
+			switch t.Name.Local {
+			case "t":
+				inText = true
+			case "tab":
+				page.WriteByte('\t')
+			case "br", "cr":
+				if t.Name.Local == "br" && xmlAttr(t, "type") == "page" {
+					newPage()
+				} else {
+					page.WriteByte('\n')
+				}
+			case "lastRenderedPageBreak":
+				if page.Len() > 0 {
+					newPage()
+				}
+			}
+		case xml.EndElement:
+			switch t.Name.Local {
+			case "t":
+				inText = false
+			case "p":
+				page.WriteByte('\n')
+			}
+		case xml.CharData:
+			if inText {
+				page.Write(t)
+			}
+		}
+	}
+	newPage()
+	return pages, nil
+}
+
+// limitedReader как io.LimitReader, но после n байт возвращает ErrTooLarge, а не io.EOF,
+// чтобы обрезанный XML не принимался за целый документ
+type limitedReader struct {
+	r io.Reader
+	n int64
+}
+
+func (l *limitedReader) Read(p []byte) (int, error) {
+	if l.n <= 0 {
+		// Ровно n байт — ещё не превышение, если дальше конец
+		var probe [1]byte
+		if n, _ := l.r.Read(probe[:]); n > 0 {
+			return 0, ErrTooLarge
+		}
+		return 0, io.EOF
+	}
+	if int64(len(p)) > l.n {
+		p = p[:l.n]
+	}
+	n, err := l.r.Read(p)
+	l.n -= int64(n)
+	return n, err
+}
+
+func xmlAttr(el xml.StartElement, name string) string {
+	for _, a := range el.Attr {
+		if a.Name.Local == name {
+			return a.Value
+		}
+	}
+	return ""
+}
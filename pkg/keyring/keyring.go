@@ -0,0 +1,218 @@
+package keyring
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// KeySize размер мастер-ключа и ключа данных (AES-256)
+const KeySize = 32
+
+var ErrUnknownVersion = errors.New("unknown master key version")
+
+// Keyring набор мастер-ключей по версиям. Новые данные шифруются старшей версией,
+// старые версии остаются для расшифровки до перешифрования (rekey).
+type Keyring struct {
+	keys   map[int][]byte
+	active int
+}
+
+// Sealed зашифрованное значение (envelope encryption): данные зашифрованы
+// случайным ключом данных (DEK), а DEK — мастер-ключом версии Version
+type Sealed struct {
+	Ciphertext string // base64(nonce || AES-GCM(DEK, plaintext))
+	WrappedKey string // base64(nonce || AES-GCM(master, DEK))
+	Version    int
+}
+
+// New создаёт keyring из ключей по версиям (версии > 0, ключи по 32 байта)
+func New(keys map[int][]byte) (*Keyring, error) {
+	if len(keys) == 0 {
+		return nil, errors.New("keyring: no keys")
+	}
+	k := &Keyring{keys: make(map[int][]byte, len(keys))}
+	for v, key := range keys {
+		if v <= 0 {
+			return nil, fmt.Errorf("keyring: invalid key version %d", v)
+		}
+		if len(key) != KeySize {
+			return nil, fmt.Errorf("keyring: key version %d must be %d bytes, got %d", v, KeySize, len(key))
+		}
+		k.keys[v] = key
+		if v > k.active {
+			k.active = v
+		}
+	}
+	return k, nil
+}
+
+// Parse разбирает ключи в формате "1:base64,2:base64" (или по одному на строку).
+// Строки, начинающиеся с #, игнорируются.
+func Parse(spec string) (*Keyring, error) {
+	keys := map[int][]byte{}
+	fields := strings.FieldsFunc(spec, func(r rune) bool { return r == ',' || r == '\n' })
+	for _, field := range fields {
+		field = strings.TrimSpace(field)
+		if field == "" || strings.HasPrefix(field, "#") {
+			continue
+		}
+		versionStr, encoded, ok := strings.Cut(field, ":")
+		if !ok {
+			return nil, fmt.Errorf("keyring: expected version:key, got %q", field)
+		}
+		version, err := strconv.Atoi(strings.TrimSpace(versionStr))
+		if err != nil {
+			return nil, fmt.Errorf("keyring: invalid version %q", versionStr)
+		}
+		key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(encoded))
+		if err != nil {
+			return nil, fmt.Errorf("keyring: key version %d is not valid base64", version)
+		}
+		if _, dup := keys[version]; dup {
+			return nil, fmt.Errorf("keyring: duplicate key version %d", version)
+		}
+		keys[version] = key
+	}
+	return New(keys)
+}
+
+// Load собирает keyring из строки конфига и/или файла ключей.
+// Если ни то ни другое не задано, возвращает nil без ошибки — шифрование выключено.
+func Load(spec, file string) (*Keyring, error) {
+	if file != "" {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return nil, fmt.Errorf("keyring: read key file: %w", err)
+		}
+		if spec != "" {
+			spec += ","
+		}
+		spec += string(data)
+	}
+	if strings.TrimSpace(spec) == "" {
+		return nil, nil
+	}
+	return Parse(spec)
+}
+
+// Generate возвращает новый случайный мастер-ключ в base64
+func Generate() (string, error) {
+	key := make([]byte, KeySize)
+	if _, err := rand.Read(key); err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(key), nil
+}
+
+// ActiveVersion версия мастер-ключа, которой шифруются новые данные
+func (k *Keyring) ActiveVersion() int { return k.active }
+
+// Has проверяет, есть ли мастер-ключ указанной версии
+func (k *Keyring) Has(version int) bool {
+	_, ok := k.keys[version]
+	return ok
+}
+
+// Seal шифрует plaintext новым DEK, а DEK — активным мастер-ключом.
+// aad привязывает шифротекст к контексту (например, к id записи).
+func (k *Keyring) Seal(plaintext, aad []byte) (Sealed, error) {
+	dek := make([]byte, KeySize)
+	if _, err := rand.Read(dek); err != nil {
+		return Sealed{}, err
+	}
+	ciphertext, err := encrypt(dek, plaintext, aad)
+	if err != nil {
+		return Sealed{}, err
+	}
+	wrapped, err := encrypt(k.keys[k.active], dek, aad)
+	if err != nil {
+		return Sealed{}, err
+	}
+	return Sealed{
+		Ciphertext: base64.StdEncoding.EncodeToString(ciphertext),
+		WrappedKey: base64.StdEncoding.EncodeToString(wrapped),
+		Version:    k.active,
+	}, nil
+}
+
+// Open расшифровывает значение, созданное Seal
+func (k *Keyring) Open(s Sealed, aad []byte) ([]byte, error) {
+	dek, err := k.unwrap(s, aad)
+	if err != nil {
+		return nil, err
+	}
+	ciphertext, err := base64.StdEncoding.DecodeString(s.Ciphertext)
+	if err != nil {
+		return nil, err
+	}
+	return decrypt(dek, ciphertext, aad)
+}
+
+// Rewrap перешифровывает DEK активным мастер-ключом; сами данные не трогаются
+func (k *Keyring) Rewrap(s Sealed, aad []byte) (Sealed, error) {
+	if s.Version == k.active {
+		return s, nil
+	}
+	dek, err := k.unwrap(s, aad)
+	if err != nil {
+		return Sealed{}, err
+	}
+	wrapped, err := encrypt(k.keys[k.active], dek, aad)
+	if err != nil {
+		return Sealed{}, err
+	}
+	s.WrappedKey = base64.StdEncoding.EncodeToString(wrapped)
+	s.Version = k.active
+	return s, nil
+}
+
+func (k *Keyring) unwrap(s Sealed, aad []byte) ([]byte, error) {
+	master, ok := k.keys[s.Version]
+	if !ok {
+		return nil, fmt.Errorf("%w: %d", ErrUnknownVersion, s.Version)
+	}
+	wrapped, err := base64.StdEncoding.DecodeString(s.WrappedKey)
+	if err != nil {
+		return nil, err
+	}
+	return decrypt(master, wrapped, aad)
+}
+
+func encrypt(key, plaintext, aad []byte) ([]byte, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return gcm.Seal(nonce, nonce, plaintext, aad), nil
+}
+
+func decrypt(key, data, aad []byte) ([]byte, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) < gcm.NonceSize() {
+		return nil, errors.New("keyring: ciphertext too short")
+	}
+	nonce, ciphertext := data[:gcm.NonceSize()], data[gcm.NonceSize():]
+	return gcm.Open(nil, nonce, ciphertext, aad)
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
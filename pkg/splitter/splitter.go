@@ -0,0 +1,303 @@
+// Package splitter делит длинный текст на части для модели. Длина считается в символах
+// Unicode или приблизительных токенах; текст режется по самой крупной подходящей границе:
+// абзац (блок Markdown), строка, предложение, слово, а если не помогло — посимвольно.
+// Заголовок Markdown остаётся с текстом под ним, блок кода не режется, пока помещается
+// в часть. Разделители не теряются: каждая часть — подстрока исходного текста.
+package splitter
+
+import (
+	"regexp"
+	"sort"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Options параметры разбиения
+type Options struct {
+	MaxSize int              // макс. длина части; < 1 — текст не делится
+	Overlap int              // сколько конца предыдущей части повторять в начале следующей; не больше MaxSize/2
+	Length  func(string) int // мера длины (Runes, Tokens); nil — Runes. Не должна убывать при дописывании текста
+}
+
+// Chunk часть текста: Text == text[Start:End] (смещения в байтах). Первые Overlap байт
+// повторяют конец предыдущей части, поэтому Text[Overlap:] всех частей подряд — исходный текст.
+type Chunk struct {
+	Text    string
+	Start   int
+	End     int
+	Overlap int
+}
+
+// Runes длина в символах Unicode
+func Runes(s string) int { return utf8.RuneCountInString(s) }
+
+// Tokens приблизительное число токенов: около 4 символов на токен
+func Tokens(s string) int { return (utf8.RuneCountInString(s) + 3) / 4 }
+
+// Уровни границ от крупных к мелким
+const (
+	levelBlock = iota // абзацы и блоки Markdown
+	levelLine
+	levelSentence
+	levelWord
+	levelRune
+)
+
+var (
+	sentenceEnd = regexp.MustCompile(`[.!?…]+["'»”)\]]*\s+`)
+	wordEnd     = regexp.MustCompile(`\s+`)
+)
+
+// Split делит text на части не длиннее MaxSize. Часть может оказаться длиннее, только если
+// Length одного символа больше MaxSize.
+func Split(text string, opts Options) []Chunk {
+	if text == "" {
+		return nil
+	}
+	s := &splitter{text: text, max: opts.MaxSize, length: opts.Length}
+	if s.length == nil {
+		s.length = Runes
+	}
+	if s.max < 1 || s.length(text) <= s.max {
+		return []Chunk{{Text: text, End: len(text)}}
+	}
+	overlap := min(max(opts.Overlap, 0), s.max/2)
+
+	// Места под повтор оставляем заранее, чтобы часть с ним не вышла за MaxSize
+	s.budget = s.max - overlap
+	spans := s.split(0, len(text), levelBlock)
+
+	chunks := make([]Chunk, len(spans))
+	for i, sp := range spans {
+		start := sp.start
+		if i > 0 && overlap > 0 {
+			start = s.overlapStart(spans[i-1].start, sp.start, sp.end, overlap)
+		}
+		chunks[i] = Chunk{Text: text[start:sp.end], Start: start, End: sp.end, Overlap: sp.start - start}
+	}
+	return chunks
+}
+
+// Texts тексты частей
+func Texts(chunks []Chunk) []string {
+	texts := make([]string, len(chunks))
+	for i, c := range chunks {
+		texts[i] = c.Text
+	}
+	return texts
+}
+
+type span struct{ start, end int }
+
+type splitter struct {
+	text   string
+	max    int
+	budget int // длина части без повтора
+	length func(string) int
+}
+
+func (s *splitter) fits(start, end int) bool { return s.length(s.text[start:end]) <= s.budget }
+
+// split делит [start, end) по границам уровня level, жадно собирая соседние отрезки
+// в части; отрезок длиннее лимита делится по границам следующего уровня
+func (s *splitter) split(start, end, level int) []span {
+	if s.fits(start, end) {
+		return []span{{start, end}}
+	}
+	if level == levelRune {
+		return s.hardCut(start, end)
+	}
+	cuts := s.cuts(start, end, level)
+	if len(cuts) == 0 {
+		return s.split(start, end, level+1)
+	}
+
+	var spans []span
+	cur := span{start, start}
+	flush := func() {
+		if cur.end > cur.start {
+			spans = append(spans, cur)
+		}
+	}
+	from := start
+	for _, to := range append(cuts, end) {
+		switch {
+		case !s.fits(from, to):
+			flush()
+			spans = append(spans, s.split(from, to, level+1)...)
+			cur = span{to, to}
+		case s.fits(cur.start, to):
+			cur.end = to
+		default:
+			flush()
+			cur = span{from, to}
+		}
+		from = to
+	}
+	flush()
+	return spans
+}
+
+// cuts границы уровня внутри (start, end): начала отрезков без первого
+func (s *splitter) cuts(start, end, level int) []int {
+	part := s.text[start:end]
+	var cuts []int
+	add := func(pos int) {
+		if pos > 0 && pos < len(part) {
+			cuts = append(cuts, start+pos)
+		}
+	}
+	switch level {
+	case levelBlock:
+		for _, pos := range blockStarts(part) {
+			add(pos)
+		}
+	case levelLine:
+		// После строки заголовка не режем — он остаётся с первой строкой текста под ним
+		lineStart := 0
+		for i := 0; i < len(part); i++ {
+			if part[i] == '\n' {
+				if !isHeading(part[lineStart:i]) {
+					add(i + 1)
+				}
+				lineStart = i + 1
+			}
+		}
+	case levelSentence:
+		for _, m := range sentenceEnd.FindAllStringIndex(part, -1) {
+			add(m[1])
+		}
+	case levelWord:
+		for _, m := range wordEnd.FindAllStringIndex(part, -1) {
+			add(m[1])
+		}
+	}
+	return cuts
+}
+
+// hardCut режет [start, end) по границам символов на самые длинные части, укладывающиеся в лимит
+func (s *splitter) hardCut(start, end int) []span {
+	var bounds []int // концы символов
+	for pos := start; pos < end; {
+		_, size := utf8.DecodeRuneInString(s.text[pos:end])
+		pos += size
+		bounds = append(bounds, pos)
+	}
+	var spans []span
+	for len(bounds) > 0 {
+		// Самая длинная подходящая часть; один символ длиннее лимита всё равно отдаём, чтобы не зациклиться
+		n := sort.Search(len(bounds), func(i int) bool { return !s.fits(start, bounds[i]) })
+		n = max(n, 1)
+		spans = append(spans, span{start, bounds[n-1]})
+		start = bounds[n-1]
+		bounds = bounds[n:]
+	}
+	return spans
+}
+
+// overlapStart начало части [start, end) с повтором конца предыдущей части (она начинается
+// с prevStart): повтор не длиннее overlap, по возможности с начала слова, а вся часть
+// не длиннее MaxSize
+func (s *splitter) overlapStart(prevStart, start, end, overlap int) int {
+	b := start
+	for {
+		_, size := utf8.DecodeLastRuneInString(s.text[prevStart:b])
+		if b-size <= prevStart || s.length(s.text[b-size:start]) > overlap {
+			break
+		}
+		b -= size
+	}
+	if r, _ := utf8.DecodeLastRuneInString(s.text[:b]); b < start && !unicode.IsSpace(r) {
+		for p := b; p < start; {
+			_, size := utf8.DecodeRuneInString(s.text[p:])
+			p += size
+			if r, _ := utf8.DecodeLastRuneInString(s.text[:p]); unicode.IsSpace(r) && p < start {
+				b = p
+				break
+			}
+		}
+	}
+	for b < start && s.length(s.text[b:end]) > s.max {
+		_, size := utf8.DecodeRuneInString(s.text[b:])
+		b += size
+	}
+	return b
+}
+
+// blockStarts начала блоков Markdown без первого: абзац после пустой строки, заголовок,
+// блок кода и текст после него. Блок кода — один блок вместе с пустыми строками внутри.
+// Заголовок не отделяется от следующего за ним блока.
+func blockStarts(text string) []int {
+	var starts []int
+	fence := ""
+	prevBlank, afterFence, hasContent := false, false, false
+	for pos := 0; pos < len(text); {
+		lineEnd := len(text)
+		if i := strings.IndexByte(text[pos:], '\n'); i >= 0 {
+			lineEnd = pos + i + 1
+		}
+		line := text[pos:lineEnd]
+		trimmed := strings.TrimSpace(line)
+
+		if fence != "" {
+			if strings.HasPrefix(trimmed, fence) && strings.Trim(trimmed, fence[:1]) == "" {
+				fence, afterFence, prevBlank = "", true, false
+			}
+			pos = lineEnd
+			continue
+		}
+		blank := trimmed == ""
+		if !blank {
+			marker := fenceMarker(line)
+			heading := isHeading(line)
+			if (prevBlank || afterFence || marker != "" || heading) && hasContent {
+				starts = append(starts, pos)
+				hasContent = false
+			}
+			if !heading {
+				hasContent = true
+			}
+			afterFence = false
+			fence = marker
+		}
+		prevBlank = blank
+		pos = lineEnd
+	}
+	return starts
+}
+
+// fenceMarker открывающая граница блока кода (``` или ~~~ любой длины от трёх) или пусто
+func fenceMarker(line string) string {
+	line = strings.TrimRight(line, "\r\n")
+	trimmed := strings.TrimLeft(line, " ")
+	if len(line)-len(trimmed) > 3 || len(trimmed) < 3 {
+		return ""
+	}
+	c := trimmed[0]
+	if c != '`' && c != '~' {
+		return ""
+	}
+	n := 0
+	for n < len(trimmed) && trimmed[n] == c {
+		n++
+	}
+	if n < 3 {
+		return ""
+	}
+	return trimmed[:n]
+}
+
+// isHeading заголовок ATX: до трёх пробелов, 1–6 символов # и пробел или конец строки
+func isHeading(line string) bool {
+	line = strings.TrimRight(line, "\r\n")
+	trimmed := strings.TrimLeft(line, " ")
+	if len(line)-len(trimmed) > 3 {
+		return false
+	}
+	n := 0
+	for n < len(trimmed) && trimmed[n] == '#' {
+		n++
+	}
+	return n >= 1 && n <= 6 && (n == len(trimmed) || trimmed[n] == ' ' || trimmed[n] == '\t')
+}
@@ -0,0 +1,216 @@
+// Package textdiff сравнивает два текста по словам. Текст делится на слова и промежутки
+// между ними; различия ищутся алгоритмом Майерса в линейной памяти. Фрагменты Equal
+// и Delete подряд дают исходный текст, Equal и Insert — новый.
+package textdiff
+
+import (
+	"strings"
+	"unicode"
+)
+
+// Виды фрагментов
+const (
+	Equal  = "equal"
+	Delete = "delete"
+	Insert = "insert"
+)
+
+// Edit фрагмент сравнения
+type Edit struct {
+	Op   string
+	Text string
+}
+
+// Words различия между a и b по словам. Внутри изменённого участка сначала идёт
+// удалённый текст, затем вставленный. Время — O((N+M)·D), где D — число правок.
+func Words(a, b string) []Edit {
+	return merge(diff(tokens(a), tokens(b), nil))
+}
+
+// Join склеивает сравнения идущих подряд кусков текста в одно: на стыках фрагменты
+// одного вида объединяются, в изменённом участке удалённое — перед вставленным
+func Join(parts ...[]Edit) []Edit {
+	var ops []op
+	for _, edits := range parts {
+		for _, e := range edits {
+			ops = append(ops, op{e.Op, e.Text})
+		}
+	}
+	return merge(ops)
+}
+
+// Ratio доля изменённых слов от 0 (тексты совпадают) до 1 (общих слов нет):
+// 1 − 2·общие / (слов в a + слов в b)
+func Ratio(edits []Edit) float64 {
+	same, total := 0, 0
+	for _, e := range edits {
+		n := len(strings.Fields(e.Text))
+		if e.Op == Equal {
+			same += n
+			n *= 2
+		}
+		total += n
+	}
+	if total == 0 {
+		return 0
+	}
+	return 1 - float64(2*same)/float64(total)
+}
+
+type op struct {
+	kind  string
+	token string
+}
+
+// tokens слова и промежутки между ними вперемешку
+func tokens(s string) []string {
+	var out []string
+	start := 0
+	prevSpace := false
+	for i, r := range s {
+		space := unicode.IsSpace(r)
+		if i > 0 && space != prevSpace {
+			out = append(out, s[start:i])
+			start = i
+		}
+		prevSpace = space
+	}
+	if start < len(s) {
+		out = append(out, s[start:])
+	}
+	return out
+}
+
+// diff дописывает к ops кратчайшую последовательность правок, превращающую a в b.
+// Общие начало и конец отбрасываются, середина делится средней «змеёй» (midSnake)
+// на две задачи примерно с половиной правок каждая.
+func diff(a, b []string, ops []op) []op {
+	pre := 0
+	for pre < len(a) && pre < len(b) && a[pre] == b[pre] {
+		ops = append(ops, op{Equal, a[pre]})
+		pre++
+	}
+	a, b = a[pre:], b[pre:]
+	suf := 0
+	for suf < len(a) && suf < len(b) && a[len(a)-1-suf] == b[len(b)-1-suf] {
+		suf++
+	}
+	tail := a[len(a)-suf:]
+	a, b = a[:len(a)-suf], b[:len(b)-suf]
+
+	if len(a) == 0 || len(b) == 0 {
+		ops = append(ops, replace(a, b)...)
+	} else {
+		// Без общих краёв правок не меньше двух, и каждая половина короче целого
+		x, y, u, v := midSnake(a, b)
+		ops = diff(a[:x], b[:y], ops)
+		for _, t := range a[x:u] {
+			ops = append(ops, op{Equal, t})
+		}
+		ops = diff(a[u:], b[v:], ops)
+	}
+	for _, t := range tail {
+		ops = append(ops, op{Equal, t})
+	}
+	return ops
+}
+
+// midSnake средняя «змея» кратчайшего пути правок (Майерс, 1986): пути ищутся
+// одновременно от начала и от конца, пока не встретятся. Возвращает участок
+// совпадения a[x:u] == b[y:v], через который проходит кратчайший путь.
+func midSnake(a, b []string) (x, y, u, v int) {
+	n, m := len(a), len(b)
+	delta := n - m
+	odd := delta%2 != 0
+	maxD := (n + m + 1) / 2
+	offset := maxD + 1
+	// fwd[k] — самый дальний x на диагонали k = x − y от начала,
+	// bwd[k] — то же от конца (по перевёрнутым текстам)
+	fwd := make([]int, 2*offset+1)
+	bwd := make([]int, 2*offset+1)
+	for d := 0; d <= maxD; d++ {
+		for k := -d; k <= d; k += 2 {
+			if k == -d || (k != d && fwd[offset+k-1] < fwd[offset+k+1]) {
+				x = fwd[offset+k+1]
+			} else {
+				x = fwd[offset+k-1] + 1
+			}
+			y = x - k
+			u, v = x, y
+			for u < n && v < m && a[u] == b[v] {
+				u++
+				v++
+			}
+			fwd[offset+k] = u
+			if back := delta - k; odd && back >= -(d-1) && back <= d-1 && u+bwd[offset+back] >= n {
+				return x, y, u, v
+			}
+		}
+		for k := -d; k <= d; k += 2 {
+			if k == -d || (k != d && bwd[offset+k-1] < bwd[offset+k+1]) {
+				u = bwd[offset+k+1]
+			} else {
+				u = bwd[offset+k-1] + 1
+			}
+			v = u - k
+			x, y = u, v
+			for x < n && y < m && a[n-1-x] == b[m-1-y] {
+				x++
+				y++
+			}
+			bwd[offset+k] = x
+			if front := delta - k; !odd && front >= -d && front <= d && x+fwd[offset+front] >= n {
+				return n - x, m - y, n - u, m - v
+			}
+		}
+	}
+	panic("textdiff: middle snake not found") // кратчайший путь всегда не длиннее n + m
+}
+
+func replace(a, b []string) []op {
+	ops := make([]op, 0, len(a)+len(b))
+	for _, t := range a {
+		ops = append(ops, op{Delete, t})
+	}
+	for _, t := range b {
+		ops = append(ops, op{Insert, t})
+	}
+	return ops
+}
+
+// merge склеивает соседние фрагменты одного вида; в изменённом участке удалённое — перед вставленным
+func merge(ops []op) []Edit {
+	var edits []Edit
+	var eq, del, ins strings.Builder
+	flush := func() {
+		for _, part := range []struct {
+			kind string
+			b    *strings.Builder
+		}{{Equal, &eq}, {Delete, &del}, {Insert, &ins}} {
+			if part.b.Len() > 0 {
+				edits = append(edits, Edit{Op: part.kind, Text: part.b.String()})
+				part.b.Reset()
+			}
+		}
+	}
+	for _, o := range ops {
+		switch o.kind {
+		case Delete, Insert:
+			if eq.Len() > 0 {
+				flush()
+			}
+			if o.kind == Delete {
+				del.WriteString(o.token)
+			} else {
+				ins.WriteString(o.token)
+			}
+		default:
+			if del.Len() > 0 || ins.Len() > 0 {
+				flush()
+			}
+			eq.WriteString(o.token)
+		}
+	}
+	flush()
+	return edits
+}
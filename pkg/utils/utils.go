@@ -30,3 +30,24 @@ func Error(w http.ResponseWriter, httpStatus int, code, message string) {
 		},
 	})
 }
+
+// OpenAIError: ошибка в формате OpenAI API ({"error": {"message", "type", "param", "code"}})
+func OpenAIError(w http.ResponseWriter, httpStatus int, errType, code, message string) {
+	OpenAIParamError(w, httpStatus, errType, code, "", message)
+}
+
+// OpenAIParamError: ошибка в формате OpenAI API с указанием поля запроса (param)
+func OpenAIParamError(w http.ResponseWriter, httpStatus int, errType, code, param, message string) {
+	var paramValue interface{}
+	if param != "" {
+		paramValue = param
+	}
+	RespondWithJSON(w, httpStatus, map[string]interface{}{
+		"error": map[string]interface{}{
+			"message": message,
+			"type":    errType,
+			"param":   paramValue,
+			"code":    code,
+		},
+	})
+}
@@ -0,0 +1,146 @@
+package tests
+
+import (
+	"geminiBackend/pkg/splitter"
+	"math/rand"
+	"reflect"
+	"strings"
+	"testing"
+	"testing/quick"
+	"unicode/utf8"
+)
+
+// splitInput случайный текст вперемешку из русских и английских слов, длинных слов без
+// пробелов, предложений, строк, абзацев, заголовков и блоков кода Markdown, а также
+// случайные параметры разбиения
+type splitInput struct {
+	Text    string
+	MaxSize int
+	Overlap int
+	Tokens  bool
+}
+
+var splitPieces = []string{
+	"привет", "мир", "текст", "распознавания", "hello", "world", "chunk", "日本語", "🙂",
+	" ", " ", " ", ". ", "! ", "? ", "… ", ", ", "\n", "\n\n", "\n\n\n", "\t",
+	"# Заголовок\n", "## Section title\n\n", "```go\nfunc main() {\n\n\tprintln(\"x\")\n}\n```\n",
+	"~~~\nкод\n~~~\n", "» ", "\r\n",
+}
+
+func (splitInput) Generate(r *rand.Rand, size int) reflect.Value {
+	var sb strings.Builder
+	for n := r.Intn(size*4 + 1); n > 0; n-- {
+		if r.Intn(20) == 0 {
+			// Длинное слово без пробелов — режется только посимвольно
+			sb.WriteString(strings.Repeat(splitPieces[r.Intn(4)], 5+r.Intn(20)))
+			continue
+		}
+		sb.WriteString(splitPieces[r.Intn(len(splitPieces))])
+	}
+	maxSize := 1 + r.Intn(120)
+	return reflect.ValueOf(splitInput{
+		Text:    sb.String(),
+		MaxSize: maxSize,
+		Overlap: r.Intn(maxSize + 10),
+		Tokens:  r.Intn(3) == 0,
+	})
+}
+
+func (in splitInput) options() splitter.Options {
+	opts := splitter.Options{MaxSize: in.MaxSize, Overlap: in.Overlap, Length: splitter.Runes}
+	if in.Tokens {
+		opts.Length = splitter.Tokens
+	}
+	return opts
+}
+
+func TestSplitterProperties(t *testing.T) {
+	cfg := &quick.Config{MaxCount: 3000}
+
+	// Ни одна часть не длиннее лимита и не рвёт символ
+	withinLimit := func(in splitInput) bool {
+		opts := in.options()
+		for _, c := range splitter.Split(in.Text, opts) {
+			if opts.Length(c.Text) > opts.MaxSize || !utf8.ValidString(c.Text) {
+				t.Logf("chunk %q exceeds %d (overlap %d)", c.Text, opts.MaxSize, opts.Overlap)
+				return false
+			}
+		}
+		return true
+	}
+	if err := quick.Check(withinLimit, cfg); err != nil {
+		t.Error(err)
+	}
+
+	// Текст не теряется: части без повтора складываются в исходный текст
+	lossless := func(in splitInput) bool {
+		chunks := splitter.Split(in.Text, in.options())
+		var sb strings.Builder
+		end := 0
+		for i, c := range chunks {
+			if c.Text != in.Text[c.Start:c.End] || c.Start != end-c.Overlap || c.Overlap < 0 || (i == 0 && c.Overlap != 0) {
+				t.Logf("chunk %d %+v does not continue at %d", i, c, end)
+				return false
+			}
+			sb.WriteString(c.Text[c.Overlap:])
+			end = c.End
+		}
+		return sb.String() == in.Text
+	}
+	if err := quick.Check(lossless, cfg); err != nil {
+		t.Error(err)
+	}
+
+	// Повтор не длиннее заданного и не больше половины лимита; части не пусты и идут вперёд
+	overlapBounded := func(in splitInput) bool {
+		opts := in.options()
+		prevStart := -1
+		for _, c := range splitter.Split(in.Text, opts) {
+			if opts.Length(c.Text[:c.Overlap]) > min(opts.Overlap, opts.MaxSize/2) || c.Start <= prevStart || c.End <= c.Start {
+				return false
+			}
+			prevStart = c.Start
+		}
+		return true
+	}
+	if err := quick.Check(overlapBounded, cfg); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestSplitterBoundaries(t *testing.T) {
+	texts := func(text string, opts splitter.Options) []string {
+		return splitter.Texts(splitter.Split(text, opts))
+	}
+
+	// Кириллица считается в символах, а не байтах
+	if got := texts("Привет мир", splitter.Options{MaxSize: 10}); len(got) != 1 {
+		t.Errorf("Expected Cyrillic text of 10 runes to fit, got %q", got)
+	}
+
+	// Разделители сохраняются, граница выбирается от крупной к мелкой
+	got := texts("Первый абзац.\n\nВторое предложение. Третье предложение.", splitter.Options{MaxSize: 25})
+	want := []string{"Первый абзац.\n\n", "Второе предложение. ", "Третье предложение."}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Expected %q, got %q", want, got)
+	}
+
+	// Блок кода с пустой строкой внутри не режется, заголовок остаётся с текстом под ним
+	doc := "Вступление.\n\n# Пример\n\n```go\nfunc main() {\n\n\tprintln(1)\n}\n```\n\nЗаключение."
+	got = texts(doc, splitter.Options{MaxSize: 50})
+	want = []string{"Вступление.\n\n", "# Пример\n\n```go\nfunc main() {\n\n\tprintln(1)\n}\n```\n\n", "Заключение."}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Expected %q, got %q", want, got)
+	}
+
+	// Повтор начинается с начала слова
+	chunks := splitter.Split("один два три четыре пять шесть", splitter.Options{MaxSize: 16, Overlap: 6})
+	if len(chunks) < 2 || chunks[1].Overlap == 0 || strings.HasPrefix(chunks[1].Text, " ") {
+		t.Errorf("Expected word-aligned overlap, got %+v", chunks)
+	}
+
+	// Токены: около 4 символов на токен
+	if got := texts(strings.Repeat("word ", 40), splitter.Options{MaxSize: 10, Length: splitter.Tokens}); len(got) != 5 {
+		t.Errorf("Expected 5 chunks of ~10 tokens, got %d: %q", len(got), got)
+	}
+}
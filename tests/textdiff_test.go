@@ -0,0 +1,131 @@
+package tests
+
+import (
+	"fmt"
+	"geminiBackend/pkg/textdiff"
+	"math/rand"
+	"reflect"
+	"strings"
+	"testing"
+	"testing/quick"
+)
+
+// diffInput два текста из общего набора слов: второй — первый со случайными правками
+type diffInput struct {
+	A, B string
+}
+
+var diffWords = []string{"привет", "мир", "рaспознавание", "текст", "OCR", "0шибка", "ошибка", "1", "l", " ", "  ", "\n", "\n\n", ".", ","}
+
+func (diffInput) Generate(r *rand.Rand, size int) reflect.Value {
+	var a []string
+	for n := r.Intn(size*3 + 1); n > 0; n-- {
+		a = append(a, diffWords[r.Intn(len(diffWords))])
+	}
+	var b []string
+	for _, w := range a {
+		switch r.Intn(6) {
+		case 0: // удалить
+		case 1: // заменить
+			b = append(b, diffWords[r.Intn(len(diffWords))])
+		case 2: // вставить
+			b = append(b, w, diffWords[r.Intn(len(diffWords))])
+		default:
+			b = append(b, w)
+		}
+	}
+	return reflect.ValueOf(diffInput{A: strings.Join(a, ""), B: strings.Join(b, "")})
+}
+
+func TestTextDiffProperties(t *testing.T) {
+	cfg := &quick.Config{MaxCount: 2000}
+
+	// Фрагменты восстанавливают оба текста, соседние фрагменты разного вида
+	restores := func(in diffInput) bool {
+		edits := textdiff.Words(in.A, in.B)
+		var a, b strings.Builder
+		for i, e := range edits {
+			if e.Text == "" || (i > 0 && edits[i-1].Op == e.Op) {
+				return false
+			}
+			if e.Op != textdiff.Insert {
+				a.WriteString(e.Text)
+			}
+			if e.Op != textdiff.Delete {
+				b.WriteString(e.Text)
+			}
+		}
+		return a.String() == in.A && b.String() == in.B
+	}
+	if err := quick.Check(restores, cfg); err != nil {
+		t.Error(err)
+	}
+
+	// Доля изменений в [0, 1], у одинаковых текстов — 0
+	ratio := func(in diffInput) bool {
+		r := textdiff.Ratio(textdiff.Words(in.A, in.B))
+		return r >= 0 && r <= 1 && textdiff.Ratio(textdiff.Words(in.A, in.A)) == 0
+	}
+	if err := quick.Check(ratio, cfg); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestTextDiffWords(t *testing.T) {
+	edits := textdiff.Words("Прив0т  мнр, как дела?", "Привет мир, как дела?")
+	want := []textdiff.Edit{
+		{Op: textdiff.Delete, Text: "Прив0т  мнр,"},
+		{Op: textdiff.Insert, Text: "Привет мир,"},
+		{Op: textdiff.Equal, Text: " как дела?"},
+	}
+	if !reflect.DeepEqual(edits, want) {
+		t.Errorf("Expected %q, got %q", want, edits)
+	}
+	// 2 общих слова из 4 + 4
+	if r := textdiff.Ratio(edits); r != 0.5 {
+		t.Errorf("Expected ratio 0.5, got %v", r)
+	}
+
+	// Меньше правок — меньше доля
+	light := textdiff.Ratio(textdiff.Words("один два три четыре", "один два три пять"))
+	heavy := textdiff.Ratio(textdiff.Words("один два три четыре", "раз два три пять"))
+	if light <= 0 || light >= heavy {
+		t.Errorf("Expected 0 < %v < %v", light, heavy)
+	}
+}
+
+func TestTextDiffManyEdits(t *testing.T) {
+	// 3000 слов, каждое второе исправлено: 1500 замен — 3000 правок
+	var a, b []string
+	for i := 0; i < 3000; i++ {
+		w := fmt.Sprintf("слово%d", i)
+		a = append(a, w)
+		if i%2 == 1 {
+			w = fmt.Sprintf("правка%d", i)
+		}
+		b = append(b, w)
+	}
+	edits := textdiff.Words(strings.Join(a, " "), strings.Join(b, " "))
+	changed := 0
+	for _, e := range edits {
+		if e.Op == textdiff.Delete {
+			changed += len(strings.Fields(e.Text))
+		}
+	}
+	if changed != 1500 {
+		t.Errorf("Expected 1500 changed words, got %d in %d fragments", changed, len(edits))
+	}
+	if r := textdiff.Ratio(edits); r != 0.5 {
+		t.Errorf("Expected ratio 0.5, got %v", r)
+	}
+
+	// Сравнения кусков подряд склеиваются в сравнение целого
+	half := len(a) / 2
+	joined := textdiff.Join(
+		textdiff.Words(strings.Join(a[:half], " ")+" ", strings.Join(b[:half], " ")+" "),
+		textdiff.Words(strings.Join(a[half:], " "), strings.Join(b[half:], " ")),
+	)
+	if !reflect.DeepEqual(joined, edits) {
+		t.Errorf("Expected joined diff to match the whole-text diff")
+	}
+}
@@ -0,0 +1,37 @@
+package tests
+
+import (
+	"geminiBackend/pkg/tokenizer"
+	"strings"
+	"testing"
+)
+
+func TestTokenizerCount(t *testing.T) {
+	cases := []struct {
+		text string
+		want int
+	}{
+		{"", 0},
+		{"hello", 1},
+		{"hello world", 2}, // пробел перед словом входит в его токен
+		{"internationalization", 4},
+		{"привет", 2},
+		{"Привет, мир!", 5},
+		{"12345", 2},
+		{"日本語", 3},
+		{"a  b", 3},
+		{"x\n\ny", 3},
+		{"f(x) == 1", 8},
+	}
+	for _, c := range cases {
+		if got := tokenizer.Count(c.text); got != c.want {
+			t.Errorf("Count(%q) = %d, want %d", c.text, got, c.want)
+		}
+	}
+
+	// Кириллица дробится мельче латиницы той же длины
+	ru, en := strings.Repeat("слово ", 100), strings.Repeat("words ", 100)
+	if tokenizer.Count(ru) <= tokenizer.Count(en) {
+		t.Errorf("Expected more tokens for Cyrillic: ru=%d en=%d", tokenizer.Count(ru), tokenizer.Count(en))
+	}
+}